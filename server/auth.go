@@ -0,0 +1,160 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// apiKeyは名前付きのAPIキー。キー自体は保持せず、ハッシュのみを保持する
+type apiKey struct {
+	name string
+	hash [sha256.Size]byte
+}
+
+// 環境変数の "name1:key1,name2:key2" 形式の文字列からAPIキーを読み込む。
+// 名前を省略した場合は "key1"、"key2" のように番号で名前を付ける
+func parseAPIKeys(spec string) ([]apiKey, error) {
+	var keys []apiKey
+	seen := make(map[string]bool)
+	for i, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, key, ok := strings.Cut(entry, ":")
+		if !ok {
+			name, key = fmt.Sprintf("key%d", i+1), entry
+		}
+		name, key = strings.TrimSpace(name), strings.TrimSpace(key)
+		if key == "" {
+			return nil, fmt.Errorf("api key %q is empty", name)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("duplicate api key name %q", name)
+		}
+		seen[name] = true
+		keys = append(keys, apiKey{name: name, hash: sha256.Sum256([]byte(key))})
+	}
+	return keys, nil
+}
+
+// 提示されたキーに一致するAPIキーの名前を返す。
+// ハッシュ同士を比較することで長さに依存せず、すべてのキーと定数時間で比較する
+func matchAPIKey(keys []apiKey, presented string) (string, bool) {
+	hash := sha256.Sum256([]byte(presented))
+	matched := ""
+	for _, k := range keys {
+		if subtle.ConstantTimeCompare(hash[:], k.hash[:]) == 1 {
+			matched = k.name
+		}
+	}
+	return matched, matched != ""
+}
+
+// リクエストからAPIキーを取り出す。Authorization: Bearer とX-API-Keyヘッダーに対応する
+func presentedAPIKey(req *http.Request) string {
+	if auth := req.Header.Get("Authorization"); auth != "" {
+		scheme, token, ok := strings.Cut(auth, " ")
+		if ok && strings.EqualFold(scheme, "Bearer") {
+			return strings.TrimSpace(token)
+		}
+	}
+	return req.Header.Get("X-API-Key")
+}
+
+// auditEventは監査ログの1件
+type auditEvent struct {
+	Time     time.Time `json:"time"`
+	Outcome  string    `json:"outcome"` // allowed または rejected
+	Reason   string    `json:"reason,omitempty"`
+	KeyName  string    `json:"keyName,omitempty"`
+	Method   string    `json:"method"`
+	Path     string    `json:"path"`
+	RemoteIP string    `json:"remoteIp"`
+}
+
+// auditLoggerは管理用エンドポイントへのアクセスをJSON Lines形式で記録する
+type auditLogger struct {
+	mu  sync.Mutex
+	out io.Writer
+}
+
+// pathが空の場合は標準エラー出力に、それ以外はファイルに追記する
+func newAuditLogger(path string) (*auditLogger, error) {
+	if path == "" {
+		return &auditLogger{out: os.Stderr}, nil
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("opening audit log: %w", err)
+	}
+	return &auditLogger{out: f}, nil
+}
+
+func (a *auditLogger) record(ev auditEvent) {
+	js, err := json.Marshal(ev)
+	if err != nil {
+		log.Printf("encoding audit event: %v", err)
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if _, err := a.out.Write(append(js, '\n')); err != nil {
+		log.Printf("writing audit event: %v", err)
+	}
+}
+
+// requireAPIKeyは有効なAPIキーを持つリクエストのみをnextに渡すミドルウェア。
+// APIキーが1つも設定されていない場合は、すべてのリクエストを拒否する
+func (rs *ragServer) requireAPIKey(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		ev := auditEvent{
+			Time:     time.Now(),
+			Method:   req.Method,
+			Path:     req.URL.Path,
+			RemoteIP: remoteIP(req),
+		}
+
+		presented := presentedAPIKey(req)
+		name, ok := matchAPIKey(rs.apiKeys, presented)
+		switch {
+		case len(rs.apiKeys) == 0:
+			ev.Reason = "no api keys configured"
+		case presented == "":
+			ev.Reason = "missing api key"
+		case !ok:
+			ev.Reason = "invalid api key"
+		}
+		if !ok {
+			ev.Outcome = "rejected"
+			rs.audit.record(ev)
+			w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		ev.Outcome = "allowed"
+		ev.KeyName = name
+		rs.audit.record(ev)
+		next(w, req)
+	}
+}
+
+// リクエスト元のIPアドレスを返す
+func remoteIP(req *http.Request) string {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseAPIKeys(t *testing.T) {
+	keys, err := parseAPIKeys(" admin:abc , def,,ops: ghi ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := []string{"admin", "key2", "ops"}
+	if len(keys) != len(names) {
+		t.Fatalf("got %d keys, want %d", len(keys), len(names))
+	}
+	for i, key := range keys {
+		if key.name != names[i] {
+			t.Errorf("keys[%d].name = %q, want %q", i, key.name, names[i])
+		}
+	}
+
+	for presented, want := range map[string]string{"abc": "admin", "def": "key2", "ghi": "ops", "xyz": "", "": ""} {
+		name, ok := matchAPIKey(keys, presented)
+		if name != want || ok != (want != "") {
+			t.Errorf("matchAPIKey(%q) = %q, %v, want %q", presented, name, ok, want)
+		}
+	}
+}
+
+func TestParseAPIKeysErrors(t *testing.T) {
+	for _, spec := range []string{"admin:", "admin:a,admin:b"} {
+		if _, err := parseAPIKeys(spec); err == nil {
+			t.Errorf("parseAPIKeys(%q) succeeded, want error", spec)
+		}
+	}
+	if keys, err := parseAPIKeys(""); err != nil || len(keys) != 0 {
+		t.Errorf("parseAPIKeys(\"\") = %v, %v, want no keys", keys, err)
+	}
+}
+
+func TestRequireAPIKey(t *testing.T) {
+	keys, err := parseAPIKeys("admin:abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		keys    []apiKey
+		header  map[string]string
+		status  int
+		reason  string
+		keyName string
+	}{
+		{name: "bearer", keys: keys, header: map[string]string{"Authorization": "Bearer abc"}, status: http.StatusOK, keyName: "admin"},
+		{name: "x-api-key", keys: keys, header: map[string]string{"X-API-Key": "abc"}, status: http.StatusOK, keyName: "admin"},
+		{name: "missing", keys: keys, status: http.StatusUnauthorized, reason: "missing api key"},
+		{name: "invalid", keys: keys, header: map[string]string{"Authorization": "Bearer xyz"}, status: http.StatusUnauthorized, reason: "invalid api key"},
+		{name: "basic scheme", keys: keys, header: map[string]string{"Authorization": "Basic abc"}, status: http.StatusUnauthorized, reason: "missing api key"},
+		{name: "no keys configured", header: map[string]string{"X-API-Key": "abc"}, status: http.StatusUnauthorized, reason: "no api keys configured"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var audit bytes.Buffer
+			rs := &ragServer{apiKeys: tt.keys, audit: &auditLogger{out: &audit}}
+			handler := rs.requireAPIKey(func(w http.ResponseWriter, req *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			})
+
+			req := httptest.NewRequest(http.MethodGet, "/documents/", nil)
+			for k, v := range tt.header {
+				req.Header.Set(k, v)
+			}
+			rec := httptest.NewRecorder()
+			handler(rec, req)
+
+			if rec.Code != tt.status {
+				t.Errorf("status = %d, want %d", rec.Code, tt.status)
+			}
+			if tt.status == http.StatusUnauthorized && rec.Header().Get("WWW-Authenticate") == "" {
+				t.Error("WWW-Authenticate header is not set")
+			}
+
+			var ev auditEvent
+			if err := json.Unmarshal(audit.Bytes(), &ev); err != nil {
+				t.Fatalf("decoding audit event %q: %v", audit.String(), err)
+			}
+			if ev.Reason != tt.reason || ev.KeyName != tt.keyName || ev.Path != "/documents/" {
+				t.Errorf("audit event = %+v", ev)
+			}
+		})
+	}
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/imaikosuke/iput-tokyo-ai/server/pkg/vectorstore"
+	"github.com/imaikosuke/iput-tokyo-ai/server/universitydocs"
+)
+
+// 起動時の自動登録の状態
+const (
+	bootstrapDisabled = "disabled" // BOOTSTRAP_SOURCEが未設定
+	bootstrapChecking = "checking" // 登録済みのドキュメントと比較している
+	bootstrapRunning  = "running"  // 変更されたドキュメントを登録している
+	bootstrapDone     = "done"     // 登録が完了した（変更がなかった場合を含む）
+	bootstrapFailed   = "failed"   // 読み込みや登録に失敗した
+)
+
+// BootstrapStatusは起動時の自動登録の状態
+type BootstrapStatus struct {
+	Status     string       `json:"status"`
+	Source     string       `json:"source,omitempty"`
+	Documents  int          `json:"documents"`       // 入力のドキュメント数
+	Stale      int          `json:"stale"`           // 未登録または内容が変更されたドキュメント数
+	JobID      string       `json:"jobId,omitempty"` // 登録に使ったジョブのID
+	Progress   *JobProgress `json:"progress,omitempty"`
+	Error      string       `json:"error,omitempty"`
+	StartedAt  *time.Time   `json:"startedAt,omitempty"`
+	FinishedAt *time.Time   `json:"finishedAt,omitempty"`
+}
+
+// bootstrapperは起動時にインデックスが空、または入力の内容と異なる場合に、
+// 入力のドキュメントをバックグラウンドで登録する
+type bootstrapper struct {
+	source string
+	jobs   *jobManager
+
+	mu    sync.Mutex
+	state BootstrapStatus
+}
+
+func newBootstrapper(source string, jobs *jobManager) *bootstrapper {
+	b := &bootstrapper{source: source, jobs: jobs}
+	b.state.Status = bootstrapDisabled
+	if source != "" {
+		b.state = BootstrapStatus{Status: bootstrapChecking, Source: source}
+	}
+	return b
+}
+
+// 登録が完了していて、リクエストを受け付けられるかを返す。
+// 失敗した場合も登録できたドキュメントで応答できるため受け付ける
+func (s BootstrapStatus) ready() bool {
+	return s.Status != bootstrapChecking && s.Status != bootstrapRunning
+}
+
+// 現在の状態を返す。登録中の場合はジョブの進捗を含める
+func (b *bootstrapper) status() BootstrapStatus {
+	b.mu.Lock()
+	state := b.state
+	b.mu.Unlock()
+	if state.JobID != "" {
+		if job, ok := b.jobs.get(state.JobID); ok {
+			state.Progress = &job.Progress
+		}
+	}
+	return state
+}
+
+// 入力のドキュメントと登録済みのドキュメントを比較し、未登録または内容が変更されたものを登録する。
+// ctxがキャンセルされた場合は登録の完了を待たずに戻る（ジョブは次の起動時に再開する）
+func (b *bootstrapper) run(ctx context.Context, rs *ragServer) {
+	if b.source == "" {
+		return
+	}
+	now := time.Now()
+	b.update(func(s *BootstrapStatus) { s.StartedAt = &now })
+
+	stale, total, err := b.staleDocuments(ctx, rs)
+	if err != nil {
+		b.fail(err)
+		return
+	}
+	b.update(func(s *BootstrapStatus) {
+		s.Documents = total
+		s.Stale = len(stale)
+	})
+	if len(stale) == 0 {
+		log.Printf("bootstrap: %d documents in %s are up to date", total, b.source)
+		b.finish(bootstrapDone)
+		return
+	}
+
+	job, err := b.jobs.submit(stale, true)
+	if err != nil {
+		b.fail(fmt.Errorf("submitting job: %w", err))
+		return
+	}
+	log.Printf("bootstrap: ingesting %d of %d documents from %s (job %s)", len(stale), total, b.source, job.ID)
+	b.update(func(s *BootstrapStatus) {
+		s.Status = bootstrapRunning
+		s.JobID = job.ID
+	})
+
+	result, err := b.jobs.wait(ctx, job.ID)
+	if err != nil {
+		return
+	}
+	if result.Status != jobSucceeded {
+		b.fail(fmt.Errorf("job %s %s: %d of %d documents failed", job.ID, result.Status, result.Progress.Failed, result.Progress.Total))
+		return
+	}
+	log.Printf("bootstrap: ingested %d documents", result.Progress.Succeeded)
+	b.finish(bootstrapDone)
+}
+
+// 入力のドキュメントのうち、未登録または登録時から内容が変更されたものを返す
+func (b *bootstrapper) staleDocuments(ctx context.Context, rs *ragServer) ([]universitydocs.Document, int, error) {
+	docs, err := universitydocs.Load(b.source)
+	if err != nil {
+		return nil, 0, fmt.Errorf("loading %s: %w", b.source, err)
+	}
+
+	var infos []vectorstore.DocumentInfo
+	err = rs.runStage(ctx, stageRetrieval, func(ctx context.Context) error {
+		infos, err = rs.store.ListDocuments(ctx)
+		return err
+	})
+	if err != nil {
+		return nil, 0, fmt.Errorf("listing documents: %w", err)
+	}
+	existing := make(map[string]vectorstore.DocumentInfo, len(infos))
+	for _, info := range infos {
+		existing[info.ID] = info
+	}
+
+	// 内容が変更されたドキュメントと、埋め込みモデルやチャンク分割の設定が変更される前に登録されたドキュメントを登録し直す
+	model := rs.embedder.Model()
+	var stale []universitydocs.Document
+	for _, doc := range docs {
+		info, ok := existing[doc.DocumentID()]
+		if !ok || info.ContentHash != doc.ContentHash() || rs.ingest.Stale(info, model) {
+			stale = append(stale, doc)
+		}
+	}
+	return stale, len(docs), nil
+}
+
+func (b *bootstrapper) update(fn func(s *BootstrapStatus)) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	fn(&b.state)
+}
+
+func (b *bootstrapper) finish(status string) {
+	now := time.Now()
+	b.update(func(s *BootstrapStatus) {
+		s.Status = status
+		s.FinishedAt = &now
+	})
+}
+
+func (b *bootstrapper) fail(err error) {
+	if errors.Is(err, context.Canceled) {
+		return
+	}
+	log.Printf("bootstrap: %v", err)
+	b.update(func(s *BootstrapStatus) { s.Error = err.Error() })
+	b.finish(bootstrapFailed)
+}
+
+// HealthResponseはヘルスチェックのレスポンス
+type HealthResponse struct {
+	Status    string           `json:"status"`
+	Bootstrap *BootstrapStatus `json:"bootstrap,omitempty"`
+}
+
+// healthHandlerはプロセスが応答できるか（liveness）を返す。自動登録の途中でも200を返す
+func (rs *ragServer) healthHandler(w http.ResponseWriter, req *http.Request) {
+	renderJSON(w, HealthResponse{Status: "ok"})
+}
+
+// readyHandlerはリクエストを受け付けられるか（readiness）を返す。自動登録の途中は503を返す
+func (rs *ragServer) readyHandler(w http.ResponseWriter, req *http.Request) {
+	bootstrap := rs.bootstrap.status()
+	if !bootstrap.ready() {
+		renderJSONStatus(w, http.StatusServiceUnavailable, HealthResponse{Status: "bootstrapping", Bootstrap: &bootstrap})
+		return
+	}
+	renderJSON(w, HealthResponse{Status: "ready", Bootstrap: &bootstrap})
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// 入力のディレクトリに更新日のあるドキュメントとないドキュメントを作成する
+func writeBootstrapSource(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	files := map[string]string{
+		"office-hours.md": "---\ntitle: オフィスアワー\ncategory: 授業\nupdated_at: \"2024-03-01\"\n---\n# 授業時間等\n\n## オフィスアワー\n\nオフィスアワーは毎週水曜日の午後です。",
+		"library.md":      "---\ntitle: 図書館\ncategory: 施設\n---\n# 図書館\n\n## 開館時間\n\n図書館は平日9時から20時まで開館しています。",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestBootstrapSkipsUnchangedDocuments(t *testing.T) {
+	rs := newTestServer(t)
+	jobs, err := newJobManager(t.TempDir(), 2, 0, rs.ingest.Upsert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { jobs.shutdown(context.Background()) })
+	source := writeBootstrapSource(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	first := newBootstrapper(source, jobs)
+	first.run(ctx, rs)
+	if s := first.status(); s.Status != bootstrapDone || s.Documents != 2 || s.Stale != 2 || s.Progress.Succeeded != 2 {
+		t.Fatalf("first bootstrap = %+v, want both documents ingested", s)
+	}
+
+	// 変更のない入力で再起動した場合は、更新日のないドキュメントも含めて登録し直さない
+	second := newBootstrapper(source, jobs)
+	second.run(ctx, rs)
+	if s := second.status(); s.Status != bootstrapDone || s.Stale != 0 || s.JobID != "" {
+		t.Errorf("second bootstrap = %+v, want no stale documents", s)
+	}
+}
+
+func TestBootstrapDisabled(t *testing.T) {
+	b := newBootstrapper("", nil)
+	b.run(context.Background(), newTestServer(t))
+	if s := b.status(); s.Status != bootstrapDisabled || !s.ready() {
+		t.Errorf("status = %+v, want disabled and ready", s)
+	}
+}
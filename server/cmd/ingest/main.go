@@ -0,0 +1,427 @@
+// cmd/ingestはcontent/のMarkdown、またはmdconvertが出力したJSONを読み込み、
+// サーバーと同じチャンク分割と埋め込みの処理でベクトルストアに登録する
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"slices"
+	"time"
+
+	"github.com/imaikosuke/iput-tokyo-ai/server/ingest"
+	"github.com/imaikosuke/iput-tokyo-ai/server/internal/envconfig"
+	"github.com/imaikosuke/iput-tokyo-ai/server/pkg/llm"
+	"github.com/imaikosuke/iput-tokyo-ai/server/pkg/vectorstore"
+	"github.com/imaikosuke/iput-tokyo-ai/server/universitydocs"
+
+	"github.com/joho/godotenv"
+)
+
+// 新しい版のクラスを確認するために検索するドキュメント数
+const smokeQueries = 5
+
+// 登録の設定
+type options struct {
+	DryRun      bool // チャンク分割のみ行う
+	OnlyChanged bool // 内容が変更されたドキュメントのみ登録する
+	Prune       bool // 入力に含まれないドキュメントを削除する
+	NewVersion  bool // 新しい版のクラスに登録する
+	Activate    bool // 新しい版のクラスを確認できたら検索に使うクラスを切り替える
+}
+
+// 登録結果の集計
+type summary struct {
+	Version   string // 登録した新しい版のクラス
+	Documents int
+	Added     int
+	Updated   int
+	Unchanged int
+	Failed    int
+	Pruned    int
+	Chunks    int
+}
+
+func main() {
+	dryRun := flag.Bool("dry-run", false, "チャンク分割のみ行い、埋め込みやベクトルストアへの書き込みをしない")
+	onlyChanged := flag.Bool("only-changed", false, "登録済みの内容から変更されたドキュメントのみ登録する")
+	prune := flag.Bool("prune", false, "入力に含まれない登録済みのドキュメントを削除する")
+	verbose := flag.Bool("v", false, "チャンク分割や保存の詳細なログを出力する")
+	watch := flag.Bool("watch", false, "登録後もcontentディレクトリを監視し、変更されたドキュメントを登録し直す")
+	interval := flag.Duration("interval", time.Second, "-watchでディレクトリを走査する間隔")
+	debounce := flag.Duration("debounce", 500*time.Millisecond, "-watchで最後の変更から登録までに待つ時間（連続した変更をまとめる）")
+	newVersion := flag.Bool("new-version", false, "新しい版のクラス（例: Document_v7）にすべてのドキュメントを登録し、確認してから検索に使うクラスを切り替える")
+	noActivate := flag.Bool("no-activate", false, "-new-versionで登録したクラスに切り替えない")
+	reembedStale := flag.Bool("reembed", false, "入力を読み込まず、現在の埋め込みモデルと異なるモデルで埋め込まれたチャンクだけを保存されているテキストで埋め込み直す")
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: ingest [flags] <content-directory | university_data.json>")
+		fmt.Fprintln(os.Stderr, "       ingest -reembed [-dry-run] [-new-version [-no-activate]]")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	wantArgs := 1
+	if *reembedStale {
+		wantArgs = 0
+	}
+	if flag.NArg() != wantArgs || (*reembedStale && *watch) {
+		flag.Usage()
+		os.Exit(1)
+	}
+	if !*verbose {
+		log.SetOutput(io.Discard)
+	}
+
+	// サーバーと同じ.envを読み込む（環境変数が優先される）
+	godotenv.Load(".env", "../.env")
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	if *reembedStale {
+		sum, err := reembed(ctx, options{DryRun: *dryRun, NewVersion: *newVersion, Activate: !*noActivate})
+		if sum != nil {
+			printReembedSummary(sum, *dryRun)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	input := flag.Arg(0)
+	if *newVersion && *dryRun {
+		fmt.Fprintln(os.Stderr, "Error: -new-version cannot be combined with -dry-run")
+		os.Exit(1)
+	}
+	// 最初の登録の前に監視の基準を記録し、登録中に変更されたファイルも登録し直す
+	var watcher *universitydocs.Watcher
+	if *watch {
+		if info, err := os.Stat(input); err != nil || !info.IsDir() {
+			fmt.Fprintln(os.Stderr, "Error: -watch requires a content directory")
+			os.Exit(1)
+		}
+		watcher = &universitydocs.Watcher{Dir: input, Interval: *interval, Debounce: *debounce}
+		if err := watcher.Baseline(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	sum, err := run(ctx, input, options{
+		DryRun:      *dryRun,
+		OnlyChanged: *onlyChanged,
+		Prune:       *prune,
+		NewVersion:  *newVersion,
+		Activate:    !*noActivate,
+	})
+	if sum != nil {
+		printSummary(sum, *dryRun)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	if watcher != nil {
+		if err := watchDirectory(ctx, watcher, *dryRun); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+	if sum.Failed > 0 {
+		os.Exit(1)
+	}
+}
+
+func run(ctx context.Context, input string, opts options) (*summary, error) {
+	docs, err := universitydocs.Load(input)
+	if err != nil {
+		return nil, fmt.Errorf("loading documents: %w", err)
+	}
+	if err := universitydocs.ValidateIDs(docs); err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool, len(docs))
+	for _, doc := range docs {
+		id := doc.DocumentID()
+		if seen[id] {
+			return nil, fmt.Errorf("duplicate document id %q", id)
+		}
+		seen[id] = true
+	}
+
+	// ドライランではスキーマを変更しないように読み取りのみで接続する。
+	// 読み込めない場合は登録済みのドキュメントがないものとして比較する
+	var store *vectorstore.WeaviateStore
+	if opts.DryRun {
+		store, err = envconfig.OpenWeaviateReadOnly(ctx, "ingest")
+		if err != nil {
+			fmt.Printf("! cannot read the index (%v); comparing against an empty index\n", err)
+			store = nil
+		}
+	} else {
+		store, err = newStore(ctx)
+		if err != nil {
+			return nil, err
+		}
+	}
+	// 新しい版のクラスに登録する場合は、確認して切り替えるまで検索に使うクラスは変更しない
+	target := store
+	if opts.NewVersion {
+		target, err = store.NewVersion(ctx)
+		if err != nil {
+			return nil, err
+		}
+		fmt.Printf("Building %s (active: %s)\n", target.Class(), store.Class())
+	}
+	pipeline, closer, err := newPipeline(ctx, target, opts.DryRun)
+	if err != nil {
+		return nil, err
+	}
+	defer closer.Close()
+
+	// 登録済みのドキュメントと内容のハッシュ
+	var infos []vectorstore.DocumentInfo
+	if target != nil {
+		infos, err = target.ListDocuments(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("listing documents: %w", err)
+		}
+	}
+	existing := make(map[string]vectorstore.DocumentInfo, len(infos))
+	for _, info := range infos {
+		existing[info.ID] = info
+	}
+	model := envconfig.Provider().EmbeddingModelName()
+
+	sum := &summary{Documents: len(docs)}
+	if opts.NewVersion {
+		sum.Version = target.Class()
+	}
+	stored := make(map[string]int, len(docs)) // ドキュメントごとの登録したチャンク数
+	for _, doc := range docs {
+		if err := ctx.Err(); err != nil {
+			return sum, err
+		}
+		id := doc.DocumentID()
+		// 埋め込みモデルやチャンク分割の設定が変更される前に登録されたドキュメントは内容が同じでも登録し直す
+		info, indexed := existing[id]
+		if indexed && opts.OnlyChanged && info.ContentHash == doc.ContentHash() && !pipeline.Stale(info, model) {
+			sum.Unchanged++
+			fmt.Printf("= %s\n", id)
+			continue
+		}
+
+		mark := "+"
+		if indexed {
+			mark = "~"
+		}
+		chunks, err := ingestDocument(ctx, pipeline, doc, opts.DryRun)
+		if err != nil {
+			sum.Failed++
+			fmt.Printf("! %s: %v\n", id, err)
+			continue
+		}
+		stored[id] = chunks
+		if indexed {
+			sum.Updated++
+		} else {
+			sum.Added++
+		}
+		sum.Chunks += chunks
+		fmt.Printf("%s %s (%d chunks)\n", mark, id, chunks)
+	}
+
+	if opts.Prune {
+		for _, info := range infos {
+			if seen[info.ID] {
+				continue
+			}
+			if !opts.DryRun {
+				if _, err := target.DeleteDocument(ctx, info.ID); err != nil {
+					return sum, fmt.Errorf("pruning %q: %w", info.ID, err)
+				}
+			}
+			sum.Pruned++
+			fmt.Printf("- %s\n", info.ID)
+		}
+	}
+
+	if opts.NewVersion {
+		if sum.Failed > 0 {
+			return sum, fmt.Errorf("%d documents failed, keeping %s active (%s was not switched to)", sum.Failed, store.Class(), target.Class())
+		}
+		if err := validateVersion(ctx, target, pipeline.Embedder, docs, stored); err != nil {
+			return sum, fmt.Errorf("validating %s: %w (keeping %s active)", target.Class(), err, store.Class())
+		}
+		fmt.Printf("Validated %s\n", target.Class())
+		if opts.Activate {
+			previous := store.Class()
+			if err := store.Activate(ctx, target.Class()); err != nil {
+				return sum, err
+			}
+			fmt.Printf("Switched %s -> %s (%s is kept for rollback)\n", previous, target.Class(), previous)
+		}
+	}
+	return sum, nil
+}
+
+// 新しい版のクラスを確認する。ドキュメントごとのチャンク数が登録した数と一致すること、
+// いくつかのドキュメントのタイトルで検索してそのドキュメントが見つかることを確認する
+func validateVersion(ctx context.Context, store vectorstore.VectorStore, embedder llm.Embedder, docs []universitydocs.Document, stored map[string]int) error {
+	infos, err := store.ListDocuments(ctx)
+	if err != nil {
+		return fmt.Errorf("listing documents: %w", err)
+	}
+	found := make(map[string]int, len(infos))
+	for _, info := range infos {
+		found[info.ID] = info.Chunks
+	}
+	for id, chunks := range stored {
+		if chunks > 0 && found[id] != chunks {
+			return fmt.Errorf("document %q has %d chunks, expected %d", id, found[id], chunks)
+		}
+	}
+
+	step := max(len(docs)/smokeQueries, 1)
+	for i := 0; i < len(docs); i += step {
+		doc := docs[i]
+		if doc.Title == "" || stored[doc.DocumentID()] == 0 {
+			continue
+		}
+		vector, err := embedder.EmbedQuery(ctx, doc.Title)
+		if err != nil {
+			return fmt.Errorf("embedding smoke query: %w", err)
+		}
+		results, err := store.Search(ctx, vectorstore.SearchQuery{
+			Mode:   vectorstore.ModeHybrid,
+			Vector: vector,
+			Text:   doc.Title,
+			Alpha:  0.5,
+			Limit:  5,
+		})
+		if err != nil {
+			return fmt.Errorf("smoke query %q: %w", doc.Title, err)
+		}
+		if !slices.ContainsFunc(results, func(r vectorstore.Result) bool { return r.DocumentID == doc.DocumentID() }) {
+			return fmt.Errorf("smoke query %q did not return document %q", doc.Title, doc.DocumentID())
+		}
+		fmt.Printf("* smoke query %q found %s\n", doc.Title, doc.DocumentID())
+	}
+	return nil
+}
+
+// contentディレクトリを監視し、作成・変更されたドキュメントを登録し直し、削除されたドキュメントのチャンクを削除する
+func watchDirectory(ctx context.Context, watcher *universitydocs.Watcher, dryRun bool) error {
+	// ドライランではベクトルストアに接続しない
+	var store *vectorstore.WeaviateStore
+	if !dryRun {
+		var err error
+		if store, err = newStore(ctx); err != nil {
+			return err
+		}
+	}
+	pipeline, closer, err := newPipeline(ctx, store, dryRun)
+	if err != nil {
+		return err
+	}
+	defer closer.Close()
+
+	fmt.Printf("\nWatching %s for changes (Ctrl+C to stop)\n", watcher.Dir)
+	return watcher.Run(ctx, func(ctx context.Context, change universitydocs.Change) {
+		for _, err := range change.Errors {
+			fmt.Printf("! %v\n", err)
+		}
+		for _, id := range change.Removed {
+			if !dryRun {
+				if _, err := store.DeleteDocument(ctx, id); err != nil {
+					fmt.Printf("! %s: %v\n", id, err)
+					continue
+				}
+			}
+			fmt.Printf("- %s\n", id)
+		}
+		for _, doc := range change.Updated {
+			chunks, err := ingestDocument(ctx, pipeline, doc, dryRun)
+			if err != nil {
+				fmt.Printf("! %s: %v\n", doc.DocumentID(), err)
+				continue
+			}
+			fmt.Printf("~ %s (%d chunks)\n", doc.DocumentID(), chunks)
+		}
+	})
+}
+
+// ドキュメントを登録し、チャンク数を返す。dryRunの場合はチャンク分割のみ行う
+func ingestDocument(ctx context.Context, pipeline *ingest.Pipeline, doc universitydocs.Document, dryRun bool) (int, error) {
+	if dryRun {
+		chunks, _, err := pipeline.Chunk(doc)
+		return len(chunks), err
+	}
+	result, err := pipeline.Upsert(ctx, doc)
+	return result.Stored, err
+}
+
+// サーバーと同じ環境変数でWeaviateに接続する
+func newStore(ctx context.Context) (*vectorstore.WeaviateStore, error) {
+	return envconfig.OpenWeaviate(ctx, "ingest")
+}
+
+// サーバーと同じ環境変数で登録処理を作成する。dryRunの場合は埋め込みモデルとストアを使わない
+func newPipeline(ctx context.Context, store *vectorstore.WeaviateStore, dryRun bool) (*ingest.Pipeline, io.Closer, error) {
+	chunker, err := ingest.NewChunker()
+	if err != nil {
+		return nil, nil, err
+	}
+	template, err := ingest.ParseEmbedTemplate(os.Getenv("EMBED_TEMPLATE"))
+	if err != nil {
+		return nil, nil, err
+	}
+	pipeline := &ingest.Pipeline{Chunker: chunker, Template: template}
+	if dryRun {
+		return pipeline, io.NopCloser(nil), nil
+	}
+	pipeline.Store = store
+
+	embedder, _, closer, err := llm.NewProviders(ctx, envconfig.Provider())
+	if err != nil {
+		return nil, nil, err
+	}
+	pipeline.Embedder = embedder
+	pipeline.Batch = envconfig.EmbedBatch()
+	embedTimeout := envconfig.Duration("EMBED_TIMEOUT", 15*time.Second)
+	storeTimeout := envconfig.Duration("STORE_TIMEOUT", 60*time.Second)
+	pipeline.RunStage = func(ctx context.Context, stage string, fn func(ctx context.Context) error) error {
+		timeout := storeTimeout
+		if stage == ingest.StageEmbedding {
+			timeout = embedTimeout
+		}
+		ctx, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
+		return fn(ctx)
+	}
+	return pipeline, closer, nil
+}
+
+func printSummary(sum *summary, dryRun bool) {
+	title := "Ingest summary"
+	if dryRun {
+		title += " (dry run, nothing was written)"
+	}
+	fmt.Printf("\n%s\n", title)
+	if sum.Version != "" {
+		fmt.Printf("  class:     %s\n", sum.Version)
+	}
+	fmt.Printf("  documents: %d\n", sum.Documents)
+	fmt.Printf("  added:     %d\n", sum.Added)
+	fmt.Printf("  updated:   %d\n", sum.Updated)
+	fmt.Printf("  unchanged: %d\n", sum.Unchanged)
+	fmt.Printf("  failed:    %d\n", sum.Failed)
+	fmt.Printf("  pruned:    %d\n", sum.Pruned)
+	fmt.Printf("  chunks:    %d\n", sum.Chunks)
+}
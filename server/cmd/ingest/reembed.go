@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/imaikosuke/iput-tokyo-ai/server/ingest"
+	"github.com/imaikosuke/iput-tokyo-ai/server/internal/envconfig"
+	"github.com/imaikosuke/iput-tokyo-ai/server/pkg/vectorstore"
+)
+
+// 埋め込み直したチャンクを1回に書き込む数
+const reembedBatch = 100
+
+// 埋め込み直しの結果の集計
+type reembedSummary struct {
+	Version     string // 書き込んだ新しい版のクラス
+	Model       string // 現在の埋め込みモデル
+	Dimension   int    // 現在の埋め込みモデルのベクトルの次元数
+	Chunks      int    // 保存されているチャンク数
+	Stale       int    // 埋め込みモデルや次元数、埋め込み用テキストのテンプレートが異なるチャンク数
+	Reembedded  int    // 埋め込み直したチャンク数
+	ChunkConfig int    // チャンク分割の設定が異なるドキュメント数（チャンク分割は埋め込み直しでは直らない）
+}
+
+// 現在の埋め込みモデルと異なるモデル（または次元数）や、現在のEMBED_TEMPLATEと異なるテンプレートで埋め込まれたチャンクだけを、
+// 保存されているテキストで埋め込み直す。埋め込み直したチャンクにはテンプレートの部分だけを現在のものにした設定のハッシュを記録し、
+// チャンク分割の設定が異なるチャンクは登録し直すまで古いものとして残す。
+// 次元数が変わる場合は同じクラスに書き込めないため、opts.NewVersionで新しい版のクラスにすべてのチャンクを書き込む
+func reembed(ctx context.Context, opts options) (*reembedSummary, error) {
+	// ドライランではスキーマを変更しないように読み取りのみで接続する
+	open := newStore
+	if opts.DryRun {
+		open = func(ctx context.Context) (*vectorstore.WeaviateStore, error) {
+			return envconfig.OpenWeaviateReadOnly(ctx, "ingest")
+		}
+	}
+	store, err := open(ctx)
+	if err != nil {
+		return nil, err
+	}
+	pipeline, closer, err := newPipeline(ctx, store, false)
+	if err != nil {
+		return nil, err
+	}
+	defer closer.Close()
+
+	var chunks []vectorstore.Chunk
+	if err := store.ScanChunks(ctx, func(c vectorstore.Chunk) error {
+		chunks = append(chunks, c)
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	sum := &reembedSummary{Model: pipeline.Embedder.Model(), Chunks: len(chunks)}
+	if len(chunks) == 0 {
+		return sum, nil
+	}
+
+	// 現在のモデルのベクトルの次元数
+	text, err := pipeline.EmbedText(chunks[0])
+	if err != nil {
+		return sum, err
+	}
+	probe, err := pipeline.Embedder.EmbedQuery(ctx, text)
+	if err != nil {
+		return sum, fmt.Errorf("embedding probe: %w", err)
+	}
+	sum.Dimension = len(probe)
+
+	chunkingHash, templateHash := ingest.ChunkingHash(), pipeline.TemplateHash()
+	current := func(c vectorstore.Chunk) bool {
+		return c.EmbeddingModel == sum.Model && len(c.Vector) == sum.Dimension &&
+			ingest.TemplatePart(c.ChunkConfig) == templateHash
+	}
+	staleConfig := make(map[string]bool)
+	var stale []vectorstore.Chunk
+	dimensionChanged := false
+	for _, c := range chunks {
+		if ingest.ChunkingPart(c.ChunkConfig) != chunkingHash {
+			staleConfig[c.DocumentID] = true
+		}
+		if len(c.Vector) != sum.Dimension {
+			dimensionChanged = true
+		}
+		if !current(c) {
+			stale = append(stale, c)
+		}
+	}
+	sum.Stale = len(stale)
+	sum.ChunkConfig = len(staleConfig)
+	if len(stale) == 0 || opts.DryRun {
+		return sum, nil
+	}
+	if dimensionChanged && !opts.NewVersion {
+		return sum, fmt.Errorf("%s returns %d-dimensional vectors, which cannot be stored in %s with the existing vectors; use -reembed -new-version", sum.Model, sum.Dimension, store.Class())
+	}
+
+	target := store
+	if opts.NewVersion {
+		target, err = store.NewVersion(ctx)
+		if err != nil {
+			return sum, err
+		}
+		sum.Version = target.Class()
+		fmt.Printf("Building %s (active: %s)\n", target.Class(), store.Class())
+	}
+
+	for start := 0; start < len(stale); start += reembedBatch {
+		batch := stale[start:min(start+reembedBatch, len(stale))]
+		texts := make([]string, len(batch))
+		for i, c := range batch {
+			if texts[i], err = pipeline.EmbedText(c); err != nil {
+				return sum, err
+			}
+		}
+		if err := pipeline.Embed(ctx, batch, texts); err != nil {
+			return sum, err
+		}
+		if err := target.PutChunks(ctx, batch); err != nil {
+			return sum, fmt.Errorf("storing re-embedded chunks: %w", err)
+		}
+		sum.Reembedded += len(batch)
+		fmt.Printf("~ %d/%d chunks\n", sum.Reembedded, len(stale))
+	}
+	if !opts.NewVersion {
+		return sum, nil
+	}
+
+	// 新しい版のクラスには埋め込み直さなかったチャンクもそのまま書き込む
+	var fresh []vectorstore.Chunk
+	for _, c := range chunks {
+		if current(c) {
+			fresh = append(fresh, c)
+		}
+	}
+	for start := 0; start < len(fresh); start += reembedBatch {
+		if err := target.PutChunks(ctx, fresh[start:min(start+reembedBatch, len(fresh))]); err != nil {
+			return sum, fmt.Errorf("copying chunks: %w", err)
+		}
+	}
+	count, err := target.Count(ctx)
+	if err != nil {
+		return sum, err
+	}
+	if count != len(chunks) {
+		return sum, fmt.Errorf("%s has %d chunks, expected %d (keeping %s active)", target.Class(), count, len(chunks), store.Class())
+	}
+	if opts.Activate {
+		previous := store.Class()
+		if err := store.Activate(ctx, target.Class()); err != nil {
+			return sum, err
+		}
+		fmt.Printf("Switched %s -> %s (%s is kept for rollback)\n", previous, target.Class(), previous)
+	}
+	return sum, nil
+}
+
+func printReembedSummary(sum *reembedSummary, dryRun bool) {
+	title := "Re-embed summary"
+	if dryRun {
+		title += " (dry run, nothing was written)"
+	}
+	fmt.Printf("\n%s\n", title)
+	if sum.Version != "" {
+		fmt.Printf("  class:      %s\n", sum.Version)
+	}
+	fmt.Printf("  model:      %s (%d dimensions)\n", sum.Model, sum.Dimension)
+	fmt.Printf("  chunks:     %d\n", sum.Chunks)
+	fmt.Printf("  stale:      %d\n", sum.Stale)
+	fmt.Printf("  reembedded: %d\n", sum.Reembedded)
+	if sum.ChunkConfig > 0 {
+		fmt.Printf("\n%d documents were created with different chunk settings; re-ingest them from content (make ingest) to re-chunk\n", sum.ChunkConfig)
+	}
+}
@@ -0,0 +1,76 @@
+// cmd/migrateはWeaviateのDocumentクラスにスキーマのマイグレーションを適用する。
+// --checkではスキーマを変更せず、コードのスキーマとの差分を表示する
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+
+	"github.com/imaikosuke/iput-tokyo-ai/server/internal/envconfig"
+	"github.com/imaikosuke/iput-tokyo-ai/server/pkg/vectorstore"
+
+	"github.com/joho/godotenv"
+)
+
+func main() {
+	check := flag.Bool("check", false, "スキーマを変更せず、未適用のマイグレーションとスキーマの差分を表示する（差分がある場合は終了コード1）")
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: migrate [--check]")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	// サーバーと同じ.envを読み込む（環境変数が優先される）
+	godotenv.Load(".env", "../.env")
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	migrator, err := vectorstore.NewMigrator(ctx, envconfig.Weaviate("localhost"))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	if !*check {
+		class, err := migrator.Migrate(ctx)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("applied migrations (class %s)\n\n", class)
+	}
+
+	status, err := migrator.Status(ctx)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	printStatus(status)
+	if !status.UpToDate() {
+		os.Exit(1)
+	}
+}
+
+func printStatus(status vectorstore.SchemaStatus) {
+	fmt.Printf("class:          %s\n", status.Class)
+	fmt.Printf("schema version: %d (latest %d)\n", status.Version, status.Latest)
+	if len(status.Pending) > 0 {
+		fmt.Println("pending migrations:")
+		for _, p := range status.Pending {
+			fmt.Printf("  %s\n", p)
+		}
+	}
+	if len(status.Drift) > 0 {
+		fmt.Println("drift from code schema:")
+		for _, d := range status.Drift {
+			fmt.Printf("  %s\n", d)
+		}
+	}
+	if status.UpToDate() {
+		fmt.Println("no drift")
+	}
+}
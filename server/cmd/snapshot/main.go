@@ -0,0 +1,296 @@
+// cmd/snapshotはWeaviateの検索に使っているクラスのすべてのオブジェクトをベクトルを含めてスナップショットに書き出し、
+// スナップショットから新しい版のクラスに復元する
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"time"
+
+	"github.com/imaikosuke/iput-tokyo-ai/server/ingest"
+	"github.com/imaikosuke/iput-tokyo-ai/server/internal/envconfig"
+	"github.com/imaikosuke/iput-tokyo-ai/server/pkg/llm"
+	"github.com/imaikosuke/iput-tokyo-ai/server/pkg/vectorstore"
+	"github.com/imaikosuke/iput-tokyo-ai/server/snapshot"
+
+	"github.com/joho/godotenv"
+)
+
+// 復元で1回に書き込むオブジェクト数
+const importBatch = 100
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "Usage:")
+	fmt.Fprintln(os.Stderr, "  snapshot export <file.jsonl.gz>")
+	fmt.Fprintln(os.Stderr, "  snapshot import [--force] [--no-activate] <file.jsonl.gz>")
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(1)
+	}
+
+	// サーバーと同じ.envを読み込む（環境変数が優先される）
+	godotenv.Load(".env", "../.env")
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	var err error
+	switch cmd, args := os.Args[1], os.Args[2:]; cmd {
+	case "export":
+		fs := flag.NewFlagSet("export", flag.ExitOnError)
+		fs.Usage = usage
+		fs.Parse(args)
+		if fs.NArg() != 1 {
+			usage()
+			os.Exit(1)
+		}
+		err = exportSnapshot(ctx, fs.Arg(0))
+	case "import":
+		fs := flag.NewFlagSet("import", flag.ExitOnError)
+		force := fs.Bool("force", false, "埋め込みモデルが現在の設定と異なるスナップショットも復元する")
+		noActivate := fs.Bool("no-activate", false, "復元したクラスに切り替えない")
+		fs.Usage = func() {
+			usage()
+			fs.PrintDefaults()
+		}
+		fs.Parse(args)
+		if fs.NArg() != 1 {
+			fs.Usage()
+			os.Exit(1)
+		}
+		err = importSnapshot(ctx, fs.Arg(0), *force, !*noActivate)
+	default:
+		usage()
+		os.Exit(1)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// 検索に使っているクラスをpathに書き出す。途中で失敗した場合はファイルを残さない
+func exportSnapshot(ctx context.Context, path string) (err error) {
+	store, err := newStore(ctx)
+	if err != nil {
+		return err
+	}
+	version, err := store.SchemaVersion(ctx)
+	if err != nil {
+		return err
+	}
+	profile, err := store.Profile(ctx)
+	if err != nil {
+		return err
+	}
+	model, dimension, err := indexEmbedding(profile)
+	if err != nil {
+		return fmt.Errorf("cannot export %s: %w", store.Class(), err)
+	}
+	template, err := ingest.ParseEmbedTemplate(os.Getenv("EMBED_TEMPLATE"))
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("creating snapshot: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+
+	w := snapshot.NewWriter(tmp, snapshot.Manifest{
+		CreatedAt:      time.Now().UTC(),
+		Class:          store.Class(),
+		SchemaVersion:  version,
+		EmbeddingModel: model,
+		Dimension:      dimension,
+		ChunkConfigs:   profile.ChunkConfigs,
+		Objects:        profile.Objects,
+		Chunking:       ingest.Chunking,
+		EmbedTemplate:  template.Source(),
+	})
+	if err := store.ScanChunks(ctx, w.Write); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("writing snapshot: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("writing snapshot: %w", err)
+	}
+
+	fmt.Printf("Exported %s to %s\n", store.Class(), path)
+	printManifest(w.Manifest())
+	return nil
+}
+
+// チャンクに記録されている埋め込みモデルと次元数を返す。
+// 複数のモデルや次元数が混在している場合や、記録がないチャンクがある場合は1つのモデルとして復元できないためエラーにする
+func indexEmbedding(profile vectorstore.IndexProfile) (string, int, error) {
+	if profile.Objects == 0 {
+		return "", 0, nil
+	}
+	if len(profile.Models) > 1 {
+		return "", 0, fmt.Errorf("chunks were embedded with different models %v; run make reembed first", profile.Models)
+	}
+	if len(profile.Dimensions) > 1 {
+		return "", 0, fmt.Errorf("chunks have different dimensions %v; run make reembed first", profile.Dimensions)
+	}
+	var model string
+	for m := range profile.Models {
+		model = m
+	}
+	var dimension int
+	for d := range profile.Dimensions {
+		dimension = d
+	}
+	if model == "" || dimension == 0 {
+		return "", 0, fmt.Errorf("chunks have no recorded embedding model; run make reembed to record it")
+	}
+	return model, dimension, nil
+}
+
+// pathのスナップショットを新しい版のクラスに復元し、オブジェクト数を確認してから検索に使うクラスを切り替える
+func importSnapshot(ctx context.Context, path string, force, activate bool) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("opening snapshot: %w", err)
+	}
+	defer f.Close()
+	r, err := snapshot.NewReader(f)
+	if err != nil {
+		return err
+	}
+	manifest := r.Manifest()
+	printManifest(manifest)
+	template, err := ingest.ParseEmbedTemplate(os.Getenv("EMBED_TEMPLATE"))
+	if err != nil {
+		return err
+	}
+	hash := (&ingest.Pipeline{Template: template}).ConfigHash()
+	if err := checkManifest(manifest, envconfig.Provider(), hash, force); err != nil {
+		return err
+	}
+
+	store, err := newStore(ctx)
+	if err != nil {
+		return err
+	}
+	target, err := store.NewVersion(ctx)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("\nRestoring into %s (active: %s)\n", target.Class(), store.Class())
+
+	batch := make([]vectorstore.Chunk, 0, importBatch)
+	restored := 0
+	for {
+		c, err := r.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("%w (keeping %s active)", err, store.Class())
+		}
+		batch = append(batch, c)
+		if len(batch) < importBatch {
+			continue
+		}
+		if err := target.PutChunks(ctx, batch); err != nil {
+			return fmt.Errorf("restoring into %s: %w (keeping %s active)", target.Class(), err, store.Class())
+		}
+		restored += len(batch)
+		batch = batch[:0]
+	}
+	if err := target.PutChunks(ctx, batch); err != nil {
+		return fmt.Errorf("restoring into %s: %w (keeping %s active)", target.Class(), err, store.Class())
+	}
+	restored += len(batch)
+
+	count, err := target.Count(ctx)
+	if err != nil {
+		return err
+	}
+	if count != manifest.Objects {
+		return fmt.Errorf("%s has %d objects, snapshot has %d (keeping %s active)", target.Class(), count, manifest.Objects, store.Class())
+	}
+	fmt.Printf("Restored %d objects into %s\n", restored, target.Class())
+
+	if activate {
+		previous := store.Class()
+		if err := store.Activate(ctx, target.Class()); err != nil {
+			return err
+		}
+		fmt.Printf("Switched %s -> %s (%s is kept for rollback)\n", previous, target.Class(), previous)
+	}
+	return nil
+}
+
+// スナップショットを現在の設定で復元できるか確認する。
+// 埋め込みモデルが異なる場合は検索のベクトルと比較できないため、forceを指定しない限り復元しない。
+// chunkConfigは現在のチャンク分割の設定と埋め込み用テキストのテンプレートのハッシュ
+func checkManifest(manifest snapshot.Manifest, cfg llm.ProviderConfig, chunkConfig string, force bool) error {
+	if manifest.SchemaVersion > vectorstore.LatestSchemaVersion() {
+		return fmt.Errorf("snapshot schema version %d is newer than this build supports (%d)", manifest.SchemaVersion, vectorstore.LatestSchemaVersion())
+	}
+	if model := cfg.EmbeddingModelName(); manifest.Objects > 0 && manifest.EmbeddingModel != model {
+		if !force {
+			return fmt.Errorf("snapshot was embedded with %s but the current model is %s/%s (use --force to import anyway)",
+				manifest.EmbeddingModel, cfg.Provider, model)
+		}
+		fmt.Printf("! embedding model mismatch: snapshot %s, current %s (--force)\n", manifest.EmbeddingModel, model)
+	}
+	if cfg.Provider == llm.ProviderLocal && manifest.Dimension > 0 && manifest.Dimension != cfg.LocalEmbeddingDim {
+		if !force {
+			return fmt.Errorf("snapshot has %d dimensions but LOCAL_EMBEDDING_DIM is %d (use --force to import anyway)", manifest.Dimension, cfg.LocalEmbeddingDim)
+		}
+		fmt.Printf("! dimension mismatch: snapshot %d, current %d (--force)\n", manifest.Dimension, cfg.LocalEmbeddingDim)
+	}
+	stale := 0
+	for hash, count := range manifest.ChunkConfigs {
+		if hash != chunkConfig {
+			stale += count
+		}
+	}
+	if stale > 0 {
+		fmt.Printf("! %d objects were chunked with different chunk settings or EMBED_TEMPLATE (current %s); re-ingest them from content after importing\n", stale, chunkConfig)
+		if manifest.Chunking != ingest.Chunking {
+			fmt.Printf("  chunking: snapshot %+v, current %+v\n", manifest.Chunking, ingest.Chunking)
+		}
+	}
+	return nil
+}
+
+func printManifest(m snapshot.Manifest) {
+	fmt.Printf("  class:           %s\n", m.Class)
+	fmt.Printf("  created:         %s\n", m.CreatedAt.Format(time.RFC3339))
+	fmt.Printf("  schema version:  %d\n", m.SchemaVersion)
+	fmt.Printf("  embedding model: %s\n", m.EmbeddingModel)
+	fmt.Printf("  dimension:       %d\n", m.Dimension)
+	fmt.Printf("  chunk configs:   %v\n", m.ChunkConfigs)
+	fmt.Printf("  objects:         %d\n", m.Objects)
+	fmt.Printf("  chunking:        max %d, min %d, overlap %d tokens\n", m.Chunking.MaxTokens, m.Chunking.MinTokens, m.Chunking.OverlapTokens)
+	fmt.Printf("  embed template:  %q\n", m.EmbedTemplate)
+}
+
+// サーバーと同じ環境変数でWeaviateに接続する
+func newStore(ctx context.Context) (*vectorstore.WeaviateStore, error) {
+	return envconfig.OpenWeaviate(ctx, "snapshot")
+}
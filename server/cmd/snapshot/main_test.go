@@ -0,0 +1,36 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/imaikosuke/iput-tokyo-ai/server/pkg/llm"
+	"github.com/imaikosuke/iput-tokyo-ai/server/pkg/vectorstore"
+	"github.com/imaikosuke/iput-tokyo-ai/server/snapshot"
+)
+
+func TestCheckManifest(t *testing.T) {
+	local := llm.ProviderConfig{Provider: llm.ProviderLocal, LocalEmbeddingDim: 64}
+	model := local.EmbeddingModelName()
+	tests := []struct {
+		name     string
+		manifest snapshot.Manifest
+		cfg      llm.ProviderConfig
+		force    bool
+		wantErr  bool
+	}{
+		{name: "same model", manifest: snapshot.Manifest{EmbeddingModel: model, Dimension: 64, Objects: 3}, cfg: local},
+		{name: "empty snapshot", manifest: snapshot.Manifest{}, cfg: llm.ProviderConfig{Provider: llm.ProviderOpenAI}},
+		{name: "other model", manifest: snapshot.Manifest{EmbeddingModel: "text-embedding-3-small", Dimension: 64, Objects: 3}, cfg: local, wantErr: true},
+		{name: "other model with force", manifest: snapshot.Manifest{EmbeddingModel: "text-embedding-3-small", Dimension: 64, Objects: 3}, cfg: local, force: true},
+		{name: "other dimension", manifest: snapshot.Manifest{EmbeddingModel: model, Dimension: 32, Objects: 3}, cfg: local, wantErr: true},
+		{name: "newer schema", manifest: snapshot.Manifest{SchemaVersion: vectorstore.LatestSchemaVersion() + 1}, cfg: local, force: true, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkManifest(tt.manifest, tt.cfg, "", tt.force)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("checkManifest = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"cmp"
+	"os"
+	"time"
+
+	"github.com/imaikosuke/iput-tokyo-ai/server/internal/envconfig"
+)
+
+// serverConfigは環境変数から読み込むサーバーの設定
+type serverConfig struct {
+	LLMProvider       string // 埋め込みと生成のプロバイダー（gemini, openai, local）
+	GenerativeModel   string // 生成モデルの名前（空の場合はプロバイダーのデフォルト）
+	EmbeddingModel    string // 埋め込みモデルの名前（空の場合はプロバイダーのデフォルト）
+	OpenAIBaseURL     string // openaiプロバイダーのAPIのベースURL（例: Ollamaでは http://ollama:11434/v1）
+	OpenAIAPIKey      string // openaiプロバイダーのAPIキー（空の場合は送信しない）
+	LocalEmbeddingDim int    // localプロバイダーの埋め込みベクトルの次元数
+	LocalAnswer       string // localプロバイダーが返す固定の回答（空の場合はプロンプトの最後の行）
+
+	SessionTTL         time.Duration // 会話セッションの有効期限
+	SessionMaxTurns    int           // セッションごとに保持する最大ターン数
+	HistoryTokenBudget int           // プロンプトに含める会話履歴の最大トークン数
+
+	TopK      int     // 検索で取得するチャンク数のデフォルト値
+	MaxTopK   int     // リクエストで指定できるチャンク数の上限
+	Certainty float32 // ベクトル検索での類似度の閾値のデフォルト値（vectorモードのみ）
+
+	NeighborWindow      int // 検索でヒットしたチャンクに追加する前後のチャンク数のデフォルト値（0の場合は追加しない）
+	NeighborTokenBudget int // 前後のチャンクとして追加するチャンクのトークン数の合計の上限
+
+	VectorStore         string  // ベクトルストアの種類（weaviate, memory）
+	SearchMode          string  // 検索モード（hybrid, vector, local）
+	HybridAlpha         float32 // ハイブリッド検索でのベクトル検索の重み（0はBM25のみ、1はベクトルのみ）
+	HybridCandidatePool int     // local検索でBM25による再ランキングの対象とする候補数
+
+	EmbedBatchSize      int           // ドキュメントの登録時に1回のリクエストで埋め込むチャンク数の上限
+	EmbedMaxAttempts    int           // 埋め込みのバッチごとの最大試行回数（429や5xxの場合に再試行する）
+	EmbedRetryBaseDelay time.Duration // 埋め込みの1回目の再試行までの待ち時間（再試行ごとに2倍）
+	EmbedRetryMaxDelay  time.Duration // 埋め込みの再試行までの待ち時間の上限
+	EmbedTemplate       string        // チャンクの埋め込みに使うテキストのテンプレート（空の場合はデフォルト）
+
+	IngestWorkers int           // 非同期ジョブで並列に登録するドキュメント数
+	JobsDir       string        // 非同期ジョブの状態を保存するディレクトリ
+	JobRetention  time.Duration // 終了した非同期ジョブの状態を残す期間（0の場合は削除しない）
+
+	BootstrapSource string // 起動時に自動登録するcontentディレクトリまたはJSONファイル（空の場合は登録しない）
+
+	IndexRefreshInterval time.Duration // 他のプロセスによる検索に使うクラスの切り替えを確認する間隔（0の場合は確認しない）
+	EmbeddingCheck       string        // 起動時にインデックスの埋め込みモデルが設定と異なる場合の動作（warn, refuse, off）
+
+	EmbedTimeout    time.Duration // 埋め込み1回あたりのタイムアウト
+	RetrieveTimeout time.Duration // ベクトルストアからの検索1回あたりのタイムアウト
+	GenerateTimeout time.Duration // 回答の生成1回あたりのタイムアウト（ストリーミングでは全体）
+	StoreTimeout    time.Duration // ベクトルストアへの書き込み1回あたりのタイムアウト
+	ShutdownTimeout time.Duration // 終了時に処理中のリクエストの完了を待つ時間
+
+	AdminAPIKeys string // 管理用エンドポイントのAPIキー（"name1:key1,name2:key2" 形式）
+	AuditLogPath string // 監査ログの出力先（空の場合は標準エラー出力）
+}
+
+// 環境変数から設定を読み込む。未設定や不正な値の場合はデフォルト値を使用する。
+// コマンドと共通の設定はenvconfigで読み込む
+func loadConfig() *serverConfig {
+	provider := envconfig.Provider()
+	batch := envconfig.EmbedBatch()
+	cfg := &serverConfig{
+		LLMProvider:       provider.Provider,
+		GenerativeModel:   provider.GenerativeModel,
+		EmbeddingModel:    provider.EmbeddingModel,
+		OpenAIBaseURL:     provider.OpenAIBaseURL,
+		OpenAIAPIKey:      provider.OpenAIAPIKey,
+		LocalEmbeddingDim: provider.LocalEmbeddingDim,
+		LocalAnswer:       provider.LocalAnswer,
+
+		SessionTTL:         envconfig.Duration("SESSION_TTL", 30*time.Minute),
+		SessionMaxTurns:    envconfig.Int("SESSION_MAX_TURNS", 20),
+		HistoryTokenBudget: envconfig.Int("HISTORY_TOKEN_BUDGET", 1000),
+
+		TopK:      envconfig.Int("SEARCH_TOP_K", 5),
+		MaxTopK:   envconfig.Int("SEARCH_MAX_TOP_K", 20),
+		Certainty: float32(min(max(envconfig.Float("SEARCH_CERTAINTY", 0.7), 0), 1)),
+
+		NeighborWindow:      envconfig.Int("NEIGHBOR_WINDOW", 0),
+		NeighborTokenBudget: envconfig.Int("NEIGHBOR_TOKEN_BUDGET", 1000),
+
+		VectorStore:         cmp.Or(os.Getenv("VECTOR_STORE"), storeWeaviate),
+		SearchMode:          cmp.Or(os.Getenv("SEARCH_MODE"), searchModeHybrid),
+		HybridAlpha:         float32(min(max(envconfig.Float("HYBRID_ALPHA", 0.5), 0), 1)),
+		HybridCandidatePool: envconfig.Int("HYBRID_CANDIDATE_POOL", 50),
+
+		EmbedBatchSize:      batch.Size,
+		EmbedMaxAttempts:    batch.Retry.MaxAttempts,
+		EmbedRetryBaseDelay: batch.Retry.BaseDelay,
+		EmbedRetryMaxDelay:  batch.Retry.MaxDelay,
+		EmbedTemplate:       os.Getenv("EMBED_TEMPLATE"),
+
+		IngestWorkers: envconfig.Int("INGEST_WORKERS", 4),
+		JobsDir:       cmp.Or(os.Getenv("JOBS_DIR"), "data/jobs"),
+		JobRetention:  envconfig.Duration("JOB_RETENTION", 7*24*time.Hour),
+
+		BootstrapSource: os.Getenv("BOOTSTRAP_SOURCE"),
+
+		IndexRefreshInterval: envconfig.Duration("INDEX_REFRESH_INTERVAL", 10*time.Second),
+		EmbeddingCheck:       cmp.Or(os.Getenv("EMBEDDING_CHECK"), embeddingCheckWarn),
+
+		EmbedTimeout:    envconfig.Duration("EMBED_TIMEOUT", 15*time.Second),
+		RetrieveTimeout: envconfig.Duration("RETRIEVE_TIMEOUT", 10*time.Second),
+		GenerateTimeout: envconfig.Duration("GENERATE_TIMEOUT", 60*time.Second),
+		StoreTimeout:    envconfig.Duration("STORE_TIMEOUT", 60*time.Second),
+		ShutdownTimeout: envconfig.Duration("SHUTDOWN_TIMEOUT", 30*time.Second),
+
+		AdminAPIKeys: os.Getenv("ADMIN_API_KEYS"),
+		AuditLogPath: os.Getenv("AUDIT_LOG_PATH"),
+	}
+
+	cfg.MaxTopK = max(cfg.MaxTopK, 1)
+	cfg.TopK = min(max(cfg.TopK, 1), cfg.MaxTopK)
+	cfg.NeighborWindow = min(max(cfg.NeighborWindow, 0), maxNeighborWindow)
+	return cfg
+}
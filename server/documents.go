@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+const (
+	defaultDocumentsLimit = 20  // 一覧で返すドキュメント数のデフォルト値
+	maxDocumentsLimit     = 100 // 一覧で返すドキュメント数の上限
+)
+
+// DocumentSummaryはインデックスに登録されたドキュメントの概要
+type DocumentSummary struct {
+	ID         string `json:"id"`
+	Title      string `json:"title"`
+	Category   string `json:"category"`
+	Department string `json:"department"`
+	UpdatedAt  string `json:"updatedAt"`
+	Chunks     int    `json:"chunks"`
+}
+
+type DocumentListResponse struct {
+	Documents []DocumentSummary `json:"documents"`
+	Total     int               `json:"total"`
+	Limit     int               `json:"limit"`
+	Offset    int               `json:"offset"`
+}
+
+// StoredChunkはベクトルストアに保存されたチャンク
+type StoredChunk struct {
+	UUID        string   `json:"uuid"`
+	ChunkIndex  int      `json:"chunkIndex"`
+	TotalChunks int      `json:"totalChunks"`
+	Content     string   `json:"content"`
+	StartChar   int      `json:"startChar"`
+	EndChar     int      `json:"endChar"`
+	TokenCount  int      `json:"tokenCount"`
+	Headings    []string `json:"headings"`
+	SectionPath string   `json:"sectionPath"`
+}
+
+type DocumentDetail struct {
+	ID         string        `json:"id"`
+	Title      string        `json:"title"`
+	Category   string        `json:"category"`
+	Tags       []string      `json:"tags"`
+	Department string        `json:"department"`
+	UpdatedAt  string        `json:"updatedAt"`
+	Chunks     []StoredChunk `json:"chunks"`
+}
+
+// listDocumentsHandlerは登録されたドキュメントの一覧をチャンク数とともにIDの順で返す
+func (rs *ragServer) listDocumentsHandler(w http.ResponseWriter, req *http.Request) {
+	limit, err := queryInt(req, "limit", defaultDocumentsLimit)
+	if err != nil || limit < 1 || limit > maxDocumentsLimit {
+		http.Error(w, fmt.Sprintf("limit must be between 1 and %d", maxDocumentsLimit), http.StatusBadRequest)
+		return
+	}
+	offset, err := queryInt(req, "offset", 0)
+	if err != nil || offset < 0 {
+		http.Error(w, "offset must be a non-negative integer", http.StatusBadRequest)
+		return
+	}
+
+	var docs []DocumentSummary
+	err = rs.runStage(req.Context(), stageRetrieval, func(ctx context.Context) error {
+		docs, err = rs.listDocuments(ctx)
+		return err
+	})
+	if err != nil {
+		writeError(w, req, err)
+		return
+	}
+
+	page := docs[min(offset, len(docs)):min(offset+limit, len(docs))]
+	renderJSON(w, DocumentListResponse{
+		Documents: append([]DocumentSummary{}, page...),
+		Total:     len(docs),
+		Limit:     limit,
+		Offset:    offset,
+	})
+}
+
+// getDocumentHandlerはドキュメントの保存されたチャンクをチャンク番号の順に返す
+func (rs *ragServer) getDocumentHandler(w http.ResponseWriter, req *http.Request) {
+	id := req.PathValue("id")
+	var doc *DocumentDetail
+	err := rs.runStage(req.Context(), stageRetrieval, func(ctx context.Context) error {
+		var err error
+		doc, err = rs.getDocument(ctx, id)
+		return err
+	})
+	if err != nil {
+		writeError(w, req, err)
+		return
+	}
+	if doc == nil {
+		http.Error(w, fmt.Sprintf("document %q not found", id), http.StatusNotFound)
+		return
+	}
+	renderJSON(w, doc)
+}
+
+// deleteDocumentHandlerはドキュメントのすべてのチャンクを削除する
+func (rs *ragServer) deleteDocumentHandler(w http.ResponseWriter, req *http.Request) {
+	id := req.PathValue("id")
+	var deleted int
+	err := rs.runStage(req.Context(), stageStorage, func(ctx context.Context) error {
+		var err error
+		deleted, err = rs.store.DeleteDocument(ctx, id)
+		return err
+	})
+	if err != nil {
+		writeError(w, req, err)
+		return
+	}
+	if deleted == 0 {
+		http.Error(w, fmt.Sprintf("document %q not found", id), http.StatusNotFound)
+		return
+	}
+	renderJSON(w, map[string]any{
+		"id":      id,
+		"deleted": deleted,
+	})
+}
+
+// クエリパラメータを整数として読み込む。指定されていない場合はdefを返す
+func queryInt(req *http.Request, name string, def int) (int, error) {
+	v := req.URL.Query().Get(name)
+	if v == "" {
+		return def, nil
+	}
+	return strconv.Atoi(v)
+}
+
+// ドキュメントの一覧をIDの順で返す
+func (rs *ragServer) listDocuments(ctx context.Context) ([]DocumentSummary, error) {
+	infos, err := rs.store.ListDocuments(ctx)
+	if err != nil {
+		return nil, err
+	}
+	docs := make([]DocumentSummary, len(infos))
+	for i, info := range infos {
+		docs[i] = DocumentSummary{
+			ID:         info.ID,
+			Title:      info.Title,
+			Category:   info.Category,
+			Department: info.Department,
+			UpdatedAt:  info.UpdatedAt,
+			Chunks:     info.Chunks,
+		}
+	}
+	return docs, nil
+}
+
+// ドキュメントのチャンクをチャンク番号の順に取得する。存在しない場合はnilを返す
+func (rs *ragServer) getDocument(ctx context.Context, id string) (*DocumentDetail, error) {
+	chunks, err := rs.store.GetDocument(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if len(chunks) == 0 {
+		return nil, nil
+	}
+
+	first := chunks[0]
+	doc := &DocumentDetail{
+		ID:         id,
+		Title:      first.Title,
+		Category:   first.Category,
+		Tags:       first.Tags,
+		Department: first.Department,
+		UpdatedAt:  first.UpdatedAt,
+	}
+	for _, c := range chunks {
+		doc.Chunks = append(doc.Chunks, StoredChunk{
+			UUID:        c.UUID,
+			ChunkIndex:  c.ChunkIndex,
+			TotalChunks: c.TotalChunks,
+			Content:     c.Content,
+			StartChar:   c.StartChar,
+			EndChar:     c.EndChar,
+			TokenCount:  c.TokenCount,
+			Headings:    c.Headings,
+			SectionPath: c.SectionPath,
+		})
+	}
+	return doc, nil
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/imaikosuke/iput-tokyo-ai/server/pkg/vectorstore"
+)
+
+// updatedAtの日付形式
+const dateLayout = "2006-01-02"
+
+// stringListは単一の文字列と文字列の配列のどちらでもデコードできるリスト
+type stringList []string
+
+func (l *stringList) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		if single == "" {
+			*l = nil
+		} else {
+			*l = stringList{single}
+		}
+		return nil
+	}
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return fmt.Errorf("expected a string or a list of strings: %w", err)
+	}
+	*l = list
+	return nil
+}
+
+// queryFiltersは検索対象のチャンクをメタデータで絞り込む条件
+type queryFilters struct {
+	Category      stringList `json:"category"`      // いずれかのカテゴリに一致
+	Department    stringList `json:"department"`    // いずれかの所属に一致
+	Tags          stringList `json:"tags"`          // いずれかのタグを含む
+	UpdatedAfter  string     `json:"updatedAfter"`  // この日付以降に更新（YYYY-MM-DD）
+	UpdatedBefore string     `json:"updatedBefore"` // この日付以前に更新（YYYY-MM-DD）
+	Section       stringList `json:"section"`       // いずれかの見出しのパス、またはその下の見出しに一致（例: 授業時間等 › オフィスアワー）
+}
+
+// 条件を検証する
+func (f *queryFilters) validate() error {
+	if f == nil {
+		return nil
+	}
+	if err := validateDate("updatedAfter", f.UpdatedAfter); err != nil {
+		return err
+	}
+	return validateDate("updatedBefore", f.UpdatedBefore)
+}
+
+// 空でない場合にYYYY-MM-DD形式の日付であるかを検証する
+func validateDate(name, v string) error {
+	if v == "" {
+		return nil
+	}
+	if _, err := time.Parse(dateLayout, v); err != nil {
+		return fmt.Errorf("invalid %s %q: expected YYYY-MM-DD", name, v)
+	}
+	return nil
+}
+
+// 条件をベクトルストアの検索条件に変換する。条件がない場合はnilを返す
+func (f *queryFilters) filter() *vectorstore.Filter {
+	if f == nil {
+		return nil
+	}
+	vf := &vectorstore.Filter{
+		Categories:    f.Category,
+		Departments:   f.Department,
+		Tags:          f.Tags,
+		UpdatedAfter:  f.UpdatedAfter,
+		UpdatedBefore: f.UpdatedBefore,
+		Sections:      f.Section,
+	}
+	if len(vf.Categories) == 0 && len(vf.Departments) == 0 && len(vf.Tags) == 0 &&
+		vf.UpdatedAfter == "" && vf.UpdatedBefore == "" && len(vf.Sections) == 0 {
+		return nil
+	}
+	return vf
+}
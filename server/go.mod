@@ -3,7 +3,9 @@ module github.com/imaikosuke/iput-tokyo-ai/server
 go 1.22
 
 require (
+	github.com/go-openapi/strfmt v0.23.0
 	github.com/google/generative-ai-go v0.17.0
+	github.com/google/uuid v1.6.0
 	github.com/joho/godotenv v1.5.1
 	github.com/weaviate/weaviate v1.26.1
 	github.com/weaviate/weaviate-go-client/v4 v4.15.1
@@ -30,12 +32,10 @@ require (
 	github.com/go-openapi/jsonreference v0.19.6 // indirect
 	github.com/go-openapi/loads v0.21.1 // indirect
 	github.com/go-openapi/spec v0.20.4 // indirect
-	github.com/go-openapi/strfmt v0.23.0 // indirect
 	github.com/go-openapi/swag v0.22.3 // indirect
 	github.com/go-openapi/validate v0.21.0 // indirect
 	github.com/golang/groupcache v0.0.0-20210331224755-41bb18bfe9da // indirect
 	github.com/google/s2a-go v0.1.8 // indirect
-	github.com/google/uuid v1.6.0 // indirect
 	github.com/googleapis/enterprise-certificate-proxy v0.3.2 // indirect
 	github.com/googleapis/gax-go/v2 v2.13.0 // indirect
 	github.com/josharian/intern v1.0.0 // indirect
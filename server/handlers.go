@@ -1,217 +1,329 @@
 package main
 
 import (
+	"cmp"
+	"context"
 	"fmt"
 	"log"
 	"net/http"
 	"strings"
+	"time"
 
-	"github.com/imaikosuke/iput-tokyo-ai/server/pkg/chunking"
-	"github.com/imaikosuke/iput-tokyo-ai/server/pkg/chunking/config"
-
-	"github.com/google/generative-ai-go/genai"
-	"github.com/weaviate/weaviate-go-client/v4/weaviate/graphql"
-	"github.com/weaviate/weaviate/entities/models"
+	"github.com/imaikosuke/iput-tokyo-ai/server/ingest"
+	"github.com/imaikosuke/iput-tokyo-ai/server/pkg/llm"
+	"github.com/imaikosuke/iput-tokyo-ai/server/universitydocs"
 )
 
 func (rs *ragServer) addDocumentsHandler(w http.ResponseWriter, req *http.Request) {
 	log.Printf("Starting addDocumentsHandler")
-	type document struct {
-		Title      string   `json:"title"`
-		Content    string   `json:"content"`
-		Category   string   `json:"category"`
-		Tags       []string `json:"tags"`
-		Department string   `json:"department"`
-		UpdatedAt  string   `json:"updated_at"`
-	}
-	type addRequest struct {
-		Documents []document `json:"documents"`
-	}
-	addRequestDocuments := &addRequest{}
+	addRequestDocuments := &universitydocs.AddDocumentsRequest{}
 
 	err := readRequestJSON(req, addRequestDocuments)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-
-	// チャンカーの設定を構築
-	cfg, err := config.NewConfigBuilder().
-		WithMaxTokens(512).
-		WithMinTokens(100).
-		WithOverlapTokens(50).
-		WithJapaneseConfig(config.NewDefaultJapaneseConfig()).
-		Build()
-
-	if err != nil {
-		http.Error(w, fmt.Sprintf("configuring chunker: %v", err), http.StatusInternalServerError)
+	// IDのないドキュメントは他のドキュメントのチャンクを上書きしうるため、1つでもあれば登録しない
+	if err := universitydocs.ValidateIDs(addRequestDocuments.Documents); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	// チャンカーの初期化
-	chunker, err := chunking.NewChunker(cfg)
-	if err != nil {
-		http.Error(w, fmt.Sprintf("initializing chunker: %v", err), http.StatusInternalServerError)
+	// 非同期の場合はジョブとして登録し、ジョブIDを返す
+	if addRequestDocuments.Async {
+		job, err := rs.jobs.submit(addRequestDocuments.Documents, addRequestDocuments.ContinueOnError)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		log.Printf("queued job %s with %d documents", job.ID, len(job.Results))
+		resp, _ := rs.jobs.get(job.ID)
+		w.Header().Set("Location", "/jobs/"+job.ID)
+		renderJSONStatus(w, http.StatusAccepted, resp)
 		return
 	}
 
-	var allObjects []*models.Object
-
 	// ドキュメントごとの処理
+	ctx := req.Context()
+	resp := AddDocumentsResponse{Documents: []ingest.DocumentResult{}}
+	var firstErr error
 	for i, doc := range addRequestDocuments.Documents {
-		log.Printf("Processing document %d: %s", i, doc.Title)
-		log.Printf("Document content length: %d", len(doc.Content))
-
-		// コンテンツをチャンクに分割
-		chunks, err := chunker.ChunkDocument(doc.Content)
-		if err != nil {
-			log.Printf("Error chunking document: %v", err)
-			http.Error(w, fmt.Sprintf("chunking document: %v", err), http.StatusInternalServerError)
-			return
-		}
-		log.Printf("Document '%s' was split into %d chunks", doc.Title, len(chunks))
-		for i, chunk := range chunks {
-			log.Printf("Chunk %d: %d tokens, %d-%d chars",
-				i, chunk.TokenCount, chunk.StartChar, chunk.EndChar)
+		if (firstErr != nil && !addRequestDocuments.ContinueOnError) || ctx.Err() != nil {
+			// 中断した場合、残りのドキュメントは処理しない
+			resp.Documents = append(resp.Documents, ingest.DocumentResult{
+				ID: doc.DocumentID(), Title: doc.Title, Status: ingest.StatusSkipped,
+			})
+			resp.Skipped++
+			continue
 		}
 
-		// チャンクごとのembedding用バッチを作成
-		batch := rs.embModel.NewBatch()
-		for _, chunk := range chunks {
-			fullText := fmt.Sprintf(
-				"Title: %s\nCategory: %s\nDepartment: %s\nContent: %s",
-				doc.Title, doc.Category, doc.Department, chunk.Content,
-			)
-			batch.AddContent(genai.Text(fullText))
-		}
-
-		// バッチembedding処理
-		rsp, err := rs.embModel.BatchEmbedContents(rs.ctx, batch)
+		log.Printf("Processing document %d: %s", i, doc.Title)
+		result, err := rs.ingest.Upsert(ctx, doc)
+		resp.Documents = append(resp.Documents, result)
+		resp.Chunks += result.Stored
 		if err != nil {
-			http.Error(w, fmt.Sprintf("batch embedding: %v", err), http.StatusInternalServerError)
-			return
-		}
-
-		// チャンクごとにWeaviateオブジェクトを作成
-		for i, chunk := range chunks {
-			obj := &models.Object{
-				Class: "Document",
-				Properties: map[string]any{
-					"title":       doc.Title,
-					"content":     chunk.Content,
-					"category":    doc.Category,
-					"tags":        doc.Tags,
-					"department":  doc.Department,
-					"updatedAt":   doc.UpdatedAt,
-					"chunkIndex":  chunk.Index,
-					"totalChunks": len(chunks),
-					"startChar":   chunk.StartChar,
-					"endChar":     chunk.EndChar,
-					"tokenCount":  chunk.TokenCount,
-					"precedence":  chunk.Precedence,
-				},
-				Vector: rsp.Embeddings[i].Values,
-			}
-			allObjects = append(allObjects, obj)
+			log.Printf("adding document %q: %v", result.ID, err)
+			firstErr = cmp.Or(firstErr, err)
+			resp.Failed++
+			continue
 		}
+		resp.Succeeded++
 	}
 
-	// Weaviateへの保存
-	log.Printf("storing %v objects in weaviate", len(allObjects))
-	_, err = rs.wvClient.Batch().ObjectsBatcher().WithObjects(allObjects...).Do(rs.ctx)
-	if err != nil {
-		http.Error(w, fmt.Sprintf("storing in weaviate: %v", err), http.StatusInternalServerError)
+	if ctx.Err() != nil {
+		log.Printf("request canceled by client: %v", ctx.Err())
 		return
 	}
+	resp.Message = fmt.Sprintf("Added %d document chunks (%d documents succeeded, %d failed, %d skipped)",
+		resp.Chunks, resp.Succeeded, resp.Failed, resp.Skipped)
 
-	renderJSON(w, map[string]interface{}{
-		"message": fmt.Sprintf("Successfully added %d document chunks", len(allObjects)),
-	})
+	// 一部のドキュメントが失敗した場合、continueOnErrorでは207、それ以外は最初のエラーに応じたステータスを返す
+	status := http.StatusOK
+	switch {
+	case firstErr == nil:
+	case addRequestDocuments.ContinueOnError:
+		status = http.StatusMultiStatus
+	default:
+		status = errorStatus(firstErr)
+	}
+	renderJSONStatus(w, status, resp)
+}
+
+type AddDocumentsResponse struct {
+	Message   string                  `json:"message"`
+	Chunks    int                     `json:"chunks"` // 保存したチャンクの合計
+	Succeeded int                     `json:"succeeded"`
+	Failed    int                     `json:"failed"`
+	Skipped   int                     `json:"skipped"`
+	Documents []ingest.DocumentResult `json:"documents"`
+}
+
+// 設定からドキュメント登録時の埋め込みのバッチサイズと再試行の方法を作成する
+func (rs *ragServer) embedBatchOptions() llm.BatchOptions {
+	return llm.BatchOptions{
+		Size: rs.cfg.EmbedBatchSize,
+		Retry: llm.RetryPolicy{
+			MaxAttempts: rs.cfg.EmbedMaxAttempts,
+			BaseDelay:   rs.cfg.EmbedRetryBaseDelay,
+			MaxDelay:    rs.cfg.EmbedRetryMaxDelay,
+		},
+	}
 }
 
 type Response struct {
-	Answer string `json:"answer"`
+	Answer    string   `json:"answer"`
+	Sources   []Source `json:"sources"`
+	SessionID string   `json:"sessionId"`
+}
+
+type queryRequest struct {
+	Content   string
+	SessionID string `json:"sessionId"`
+	retrievalOptions
+}
+
+// ストリーミング時に送信するテキスト差分のイベント
+type streamDelta struct {
+	Text string `json:"text"`
+}
+
+// ストリーミングの最後に送信するメタデータのイベント
+type streamDone struct {
+	SessionID string   `json:"sessionId"`
+	Sources   []Source `json:"sources"`
+	llm.Generation
 }
 
 func (rs *ragServer) queryHandler(w http.ResponseWriter, req *http.Request) {
-	type queryRequest struct {
-		Content string
-	}
+	rs.handleQuery(w, req, wantsEventStream(req))
+}
+
+// queryStreamHandlerはAcceptヘッダーに関わらず回答をSSEで返す
+func (rs *ragServer) queryStreamHandler(w http.ResponseWriter, req *http.Request) {
+	rs.handleQuery(w, req, true)
+}
+
+func (rs *ragServer) handleQuery(w http.ResponseWriter, req *http.Request, stream bool) {
 	qr := &queryRequest{}
 	err := readRequestJSON(req, qr)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	opts, err := qr.resolve(rs.cfg)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx := req.Context()
 
-	// クエリの埋め込み処理
-	rsp, err := rs.embModel.EmbedContent(rs.ctx, genai.Text(qr.Content))
+	// セッションIDがなければ新しい会話として扱う
+	if qr.SessionID == "" {
+		qr.SessionID = newSessionID()
+	}
+	history, err := rs.sessions.History(ctx, qr.SessionID)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, fmt.Sprintf("reading session: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	// 追加の質問は履歴を踏まえた単独の質問に書き換えてから検索する
+	searchQuery, err := rs.condenseQuestion(ctx, history, qr.Content)
+	if err != nil {
+		writeError(w, req, fmt.Errorf("condensing question: %w", err))
 		return
 	}
 
-	// Weaviateでの類似検索（上位3チャンクを取得）
-	gql := rs.wvClient.GraphQL()
-	result, err := gql.Get().
-		WithClassName("Document").
-		WithFields(
-			graphql.Field{Name: "title"},
-			graphql.Field{Name: "content"},
-			graphql.Field{Name: "category"},
-			graphql.Field{Name: "department"},
-			graphql.Field{Name: "chunkIndex"},
-			graphql.Field{Name: "totalChunks"},
-			graphql.Field{Name: "_additional", Fields: []graphql.Field{
-				{Name: "certainty"},
-			}},
-		).
-		WithNearVector(
-			gql.NearVectorArgBuilder().
-				WithVector(rsp.Embedding.Values).
-				WithCertainty(0.7)).
-		WithLimit(5).
-		Do(rs.ctx)
-
-	log.Printf("Query response: %+v", result.Data)
-	if werr := combinedWeaviateError(result, err); werr != nil {
-		http.Error(w, werr.Error(), http.StatusInternalServerError)
-		return
-	}
-
-	contents, err := decodeGetResults(result)
+	sources, err := rs.retrieveSources(ctx, searchQuery, opts)
 	if err != nil {
-		http.Error(w, fmt.Errorf("reading weaviate response: %w", err).Error(), http.StatusInternalServerError)
+		writeError(w, req, err)
 		return
 	}
-	log.Printf("Retrieved %d relevant chunks from Weaviate", len(contents))
+	log.Printf("Retrieved %d relevant chunks", len(sources))
 
 	// RAGクエリの生成と実行
-	ragQuery := fmt.Sprintf(GetRAGTemplate(), qr.Content, strings.Join(contents, "\n\n---\n\n"))
+	ragQuery := fmt.Sprintf(GetRAGTemplate(),
+		cmp.Or(formatHistory(history, rs.cfg.HistoryTokenBudget), "なし"),
+		qr.Content, formatContext(sources))
 	log.Printf("RAG query:\n%s", ragQuery)
-	resp, err := rs.genModel.GenerateContent(rs.ctx, genai.Text(ragQuery))
+
+	if stream {
+		rs.streamAnswer(w, req, ragQuery, qr, sources)
+		return
+	}
+
+	var gen *llm.Generation
+	err = rs.runStage(ctx, stageGeneration, func(ctx context.Context) error {
+		gen, err = rs.generator.Generate(ctx, ragQuery)
+		return err
+	})
+	if err != nil {
+		writeError(w, req, err)
+		return
+	}
+
+	rs.saveTurn(ctx, qr, gen.Text)
+	renderJSON(w, Response{Answer: gen.Text, Sources: nonNilSources(sources), SessionID: qr.SessionID})
+}
+
+// 検索結果のチャンク。デバッグ用に本文全体を含める
+type SearchResult struct {
+	Source
+	Content string `json:"content"`
+}
+
+type SearchResponse struct {
+	Query   string         `json:"query"`
+	Mode    string         `json:"mode"`
+	Results []SearchResult `json:"results"`
+}
+
+// searchHandlerは回答を生成せず、検索したチャンクをスコアとメタデータ付きで返す
+func (rs *ragServer) searchHandler(w http.ResponseWriter, req *http.Request) {
+	type searchRequest struct {
+		Content string
+		retrievalOptions
+	}
+	sr := &searchRequest{}
+	if err := readRequestJSON(req, sr); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	opts, err := sr.resolve(rs.cfg)
 	if err != nil {
-		log.Printf("calling generative model: %v", err.Error())
-		http.Error(w, "generative model error", http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	if len(resp.Candidates) != 1 {
-		log.Printf("got %v candidates, expected 1", len(resp.Candidates))
-		http.Error(w, "generative model error", http.StatusInternalServerError)
+	sources, err := rs.retrieveSources(req.Context(), sr.Content, opts)
+	if err != nil {
+		writeError(w, req, err)
 		return
 	}
 
-	var respTexts []string
-	for _, part := range resp.Candidates[0].Content.Parts {
-		if pt, ok := part.(genai.Text); ok {
-			respTexts = append(respTexts, string(pt))
-		} else {
-			log.Printf("bad type of part: %v", pt)
-			http.Error(w, "generative model error", http.StatusInternalServerError)
-			return
+	results := make([]SearchResult, len(sources))
+	for i, src := range sources {
+		results[i] = SearchResult{Source: src, Content: src.Content}
+	}
+	renderJSON(w, SearchResponse{Query: sr.Content, Mode: rs.cfg.SearchMode, Results: results})
+}
+
+// 生成結果をトークン差分ごとにSSEで送信する。
+// クライアントが切断した場合はリクエストのコンテキストがキャンセルされ、生成も中断される。
+// 生成のタイムアウトはストリーム全体に適用する
+func (rs *ragServer) streamAnswer(w http.ResponseWriter, req *http.Request, ragQuery string, qr *queryRequest, sources []Source) {
+	sse, err := newSSEWriter(w)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	ctx := req.Context()
+	genCtx, cancel := context.WithTimeout(ctx, rs.cfg.GenerateTimeout)
+	defer cancel()
+
+	gen, err := rs.generator.GenerateStream(genCtx, ragQuery, func(text string) error {
+		return sse.writeEvent("delta", streamDelta{Text: text})
+	})
+	if err != nil {
+		switch {
+		case ctx.Err() != nil:
+			log.Printf("client disconnected, generation stopped: %v", ctx.Err())
+		case genCtx.Err() != nil:
+			log.Printf("generation timed out: %v", err)
+			sse.writeEvent("error", map[string]string{"error": "generation timed out"})
+		default:
+			log.Printf("streaming generative model: %v", err)
+			sse.writeEvent("error", map[string]string{"error": "generative model error"})
 		}
+		return
+	}
+
+	rs.saveTurn(ctx, qr, gen.Text)
+	done := streamDone{SessionID: qr.SessionID, Sources: nonNilSources(sources), Generation: *gen}
+	if err := sse.writeEvent("done", done); err != nil {
+		log.Printf("writing stream event: %v", err)
+	}
+}
+
+// 会話履歴を踏まえて、追加の質問を単独で意味が通る質問に書き換える。
+// 履歴がない場合は質問をそのまま返す
+func (rs *ragServer) condenseQuestion(ctx context.Context, history []Turn, question string) (string, error) {
+	if len(history) == 0 {
+		return question, nil
 	}
 
-	renderJSON(w, Response{Answer: strings.Join(respTexts, "\n")})
+	prompt := fmt.Sprintf(GetCondenseTemplate(), formatHistory(history, rs.cfg.HistoryTokenBudget), question)
+	var gen *llm.Generation
+	err := rs.runStage(ctx, stageGeneration, func(ctx context.Context) error {
+		var err error
+		gen, err = rs.generator.Generate(ctx, prompt)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	condensed := strings.TrimSpace(gen.Text)
+	if condensed == "" {
+		return question, nil
+	}
+	log.Printf("Condensed question: %q -> %q", question, condensed)
+	return condensed, nil
+}
+
+// 質問と回答を会話履歴に保存する。保存に失敗しても回答は返す
+func (rs *ragServer) saveTurn(ctx context.Context, qr *queryRequest, answer string) {
+	turn := Turn{Question: qr.Content, Answer: answer, CreatedAt: time.Now()}
+	if err := rs.sessions.Append(ctx, qr.SessionID, turn); err != nil {
+		log.Printf("saving session %s: %v", qr.SessionID, err)
+	}
+}
+
+// JSONで空配列を返すため、nilのスライスを空スライスに置き換える
+func nonNilSources(sources []Source) []Source {
+	if sources == nil {
+		return []Source{}
+	}
+	return sources
 }
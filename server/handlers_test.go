@@ -0,0 +1,436 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/imaikosuke/iput-tokyo-ai/server/ingest"
+	"github.com/imaikosuke/iput-tokyo-ai/server/pkg/llm"
+	"github.com/imaikosuke/iput-tokyo-ai/server/pkg/vectorstore"
+	"github.com/imaikosuke/iput-tokyo-ai/server/universitydocs"
+)
+
+const (
+	testAPIKey = "secret"
+	testAnswer = "オフィスアワーは水曜日です。"
+)
+
+var testDocuments = []universitydocs.Document{
+	{
+		ID:         "office-hours",
+		Title:      "オフィスアワー",
+		Content:    "# オフィスアワー\n\n教員のオフィスアワーは毎週水曜日の午後に研究室で実施します。",
+		Category:   "授業",
+		Tags:       []string{"教員"},
+		Department: "情報学部",
+		UpdatedAt:  "2024-04-01",
+	},
+	{
+		ID:         "library",
+		Title:      "図書館の利用",
+		Content:    "# 図書館の利用\n\n図書館は平日の9時から20時まで開館しています。学生証で入館できます。",
+		Category:   "施設",
+		Tags:       []string{"図書館"},
+		Department: "事務局",
+		UpdatedAt:  "2024-05-01",
+	},
+}
+
+// テスト用の設定。環境変数に依存しないよう、loadConfigを使わずに組み立てる
+func testConfig() *serverConfig {
+	return &serverConfig{
+		LLMProvider:       llm.ProviderLocal,
+		LocalEmbeddingDim: 64,
+		LocalAnswer:       testAnswer,
+
+		SessionTTL:         30 * time.Minute,
+		SessionMaxTurns:    20,
+		HistoryTokenBudget: 1000,
+
+		TopK:    5,
+		MaxTopK: 20,
+
+		NeighborTokenBudget: 1000,
+
+		VectorStore:         storeMemory,
+		SearchMode:          searchModeHybrid,
+		HybridAlpha:         0.5,
+		HybridCandidatePool: 50,
+
+		EmbedBatchSize:   100,
+		EmbedMaxAttempts: 1,
+
+		IngestWorkers: 2,
+
+		EmbeddingCheck: embeddingCheckWarn,
+
+		EmbedTimeout:    10 * time.Second,
+		RetrieveTimeout: 10 * time.Second,
+		GenerateTimeout: 10 * time.Second,
+		StoreTimeout:    10 * time.Second,
+		ShutdownTimeout: 10 * time.Second,
+	}
+}
+
+// ネットワークを使わないプロバイダーとメモリ上のベクトルストアでサーバーを作成する
+func newTestServer(t *testing.T) *ragServer {
+	t.Helper()
+	cfg := testConfig()
+
+	keys, err := parseAPIKeys("test:" + testAPIKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := vectorstore.NewMemoryStore()
+	embedder := llm.NewLocalEmbedder(64)
+	rs := &ragServer{
+		cfg:       cfg,
+		sessions:  newMemorySessionStore(cfg.SessionTTL, cfg.SessionMaxTurns),
+		apiKeys:   keys,
+		audit:     &auditLogger{out: io.Discard},
+		store:     store,
+		generator: llm.NewLocalGenerator(testAnswer),
+		embedder:  embedder,
+	}
+	chunker, err := ingest.NewChunker()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rs.ingest = &ingest.Pipeline{
+		Chunker:  chunker,
+		Embedder: embedder,
+		Store:    store,
+		Batch:    rs.embedBatchOptions(),
+		RunStage: rs.runStage,
+	}
+	return rs
+}
+
+// テスト用のドキュメントを登録する
+func addTestDocuments(t *testing.T, rs *ragServer) {
+	t.Helper()
+	for _, doc := range testDocuments {
+		if _, err := rs.ingest.Upsert(context.Background(), doc); err != nil {
+			t.Fatalf("adding %s: %v", doc.ID, err)
+		}
+	}
+}
+
+// ハンドラーにリクエストを送り、レスポンスを返す
+func serve(rs *ragServer, method, path, body string, header map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	rec := httptest.NewRecorder()
+	rs.routes().ServeHTTP(rec, req)
+	return rec
+}
+
+func decodeBody[T any](t *testing.T, rec *httptest.ResponseRecorder) T {
+	t.Helper()
+	var v T
+	if err := json.Unmarshal(rec.Body.Bytes(), &v); err != nil {
+		t.Fatalf("decoding %q: %v", rec.Body.String(), err)
+	}
+	return v
+}
+
+// SSEのレスポンスをイベント名とデータの組に分解する
+type sseEvent struct {
+	name string
+	data string
+}
+
+func parseEvents(t *testing.T, body string) []sseEvent {
+	t.Helper()
+	var events []sseEvent
+	var ev sseEvent
+	scanner := bufio.NewScanner(strings.NewReader(body))
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "event: "):
+			ev.name = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			ev.data = strings.TrimPrefix(line, "data: ")
+		case line == "" && ev.name != "":
+			events = append(events, ev)
+			ev = sseEvent{}
+		}
+	}
+	return events
+}
+
+func TestQueryHandlerJSON(t *testing.T) {
+	rs := newTestServer(t)
+	addTestDocuments(t, rs)
+
+	rec := serve(rs, http.MethodPost, "/query/", `{"content":"オフィスアワーはいつですか"}`, nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", rec.Code, rec.Body)
+	}
+	resp := decodeBody[Response](t, rec)
+	if resp.Answer != testAnswer {
+		t.Errorf("answer = %q, want %q", resp.Answer, testAnswer)
+	}
+	if resp.SessionID == "" {
+		t.Error("sessionId is empty")
+	}
+	if len(resp.Sources) == 0 || resp.Sources[0].DocumentID != "office-hours" {
+		t.Fatalf("sources = %+v, want office-hours first", resp.Sources)
+	}
+	if resp.Sources[0].Index != 1 {
+		t.Errorf("first source index = %d, want 1", resp.Sources[0].Index)
+	}
+
+	// 同じセッションの質問は会話履歴に保存される
+	history, _ := rs.sessions.History(context.Background(), resp.SessionID)
+	if len(history) != 1 || history[0].Answer != testAnswer {
+		t.Errorf("history = %+v", history)
+	}
+}
+
+func TestQueryHandlerFilters(t *testing.T) {
+	rs := newTestServer(t)
+	addTestDocuments(t, rs)
+
+	rec := serve(rs, http.MethodPost, "/query/", `{"content":"オフィスアワー","filters":{"category":"施設"}}`, nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", rec.Code, rec.Body)
+	}
+	sources := decodeBody[Response](t, rec).Sources
+	if len(sources) == 0 {
+		t.Fatal("no sources")
+	}
+	for _, src := range sources {
+		if src.Category != "施設" {
+			t.Errorf("source %s has category %q, want 施設", src.DocumentID, src.Category)
+		}
+	}
+}
+
+func TestQueryHandlerRejectsInvalidOptions(t *testing.T) {
+	rs := newTestServer(t)
+	for _, body := range []string{
+		`{"content":"q","topK":0}`,
+		`{"content":"q","certainty":2}`,
+		`{"content":"q","filters":{"updatedAfter":"2024/01/01"}}`,
+		`{"content":`,
+	} {
+		if rec := serve(rs, http.MethodPost, "/query/", body, nil); rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want 400", body, rec.Code)
+		}
+	}
+}
+
+func TestCertaintyRequiresVectorMode(t *testing.T) {
+	tests := []struct {
+		mode string
+		want int
+	}{
+		{searchModeVector, http.StatusOK},
+		{searchModeHybrid, http.StatusBadRequest},
+		{searchModeLocal, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		rs := newTestServer(t)
+		rs.cfg.SearchMode = tt.mode
+		addTestDocuments(t, rs)
+		if rec := serve(rs, http.MethodPost, "/search/", `{"content":"オフィスアワー","certainty":0.1}`, nil); rec.Code != tt.want {
+			t.Errorf("%s: status = %d, want %d", tt.mode, rec.Code, tt.want)
+		}
+	}
+}
+
+func TestQueryHandlerStream(t *testing.T) {
+	tests := []struct {
+		name   string
+		path   string
+		header map[string]string
+	}{
+		{name: "accept header", path: "/query/", header: map[string]string{"Accept": "text/event-stream"}},
+		{name: "stream endpoint", path: "/query/stream/"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rs := newTestServer(t)
+			addTestDocuments(t, rs)
+
+			rec := serve(rs, http.MethodPost, tt.path, `{"content":"オフィスアワーはいつですか","sessionId":"s1"}`, tt.header)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d, body = %s", rec.Code, rec.Body)
+			}
+			if ct := rec.Header().Get("Content-Type"); ct != "text/event-stream" {
+				t.Errorf("Content-Type = %q", ct)
+			}
+
+			events := parseEvents(t, rec.Body.String())
+			if len(events) < 2 {
+				t.Fatalf("events = %+v, want deltas and done", events)
+			}
+			var text strings.Builder
+			for _, ev := range events[:len(events)-1] {
+				if ev.name != "delta" {
+					t.Fatalf("event %q before done", ev.name)
+				}
+				var delta streamDelta
+				if err := json.Unmarshal([]byte(ev.data), &delta); err != nil {
+					t.Fatal(err)
+				}
+				text.WriteString(delta.Text)
+			}
+			if text.String() != testAnswer {
+				t.Errorf("streamed text = %q, want %q", text.String(), testAnswer)
+			}
+
+			last := events[len(events)-1]
+			if last.name != "done" {
+				t.Fatalf("last event = %q, want done", last.name)
+			}
+			var done streamDone
+			if err := json.Unmarshal([]byte(last.data), &done); err != nil {
+				t.Fatal(err)
+			}
+			if done.SessionID != "s1" || done.FinishReason != "STOP" || len(done.Sources) == 0 {
+				t.Errorf("done = %+v", done)
+			}
+		})
+	}
+}
+
+func TestSearchHandler(t *testing.T) {
+	rs := newTestServer(t)
+	addTestDocuments(t, rs)
+
+	rec := serve(rs, http.MethodPost, "/search/", `{"content":"図書館の開館時間","topK":1}`, nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", rec.Code, rec.Body)
+	}
+	resp := decodeBody[SearchResponse](t, rec)
+	if resp.Mode != searchModeHybrid || resp.Query != "図書館の開館時間" {
+		t.Errorf("resp = %+v", resp)
+	}
+	if len(resp.Results) != 1 {
+		t.Fatalf("results = %d, want 1", len(resp.Results))
+	}
+	if got := resp.Results[0]; got.DocumentID != "library" || !strings.Contains(got.Content, "開館") {
+		t.Errorf("result = %+v, want library chunk with content", got)
+	}
+}
+
+func TestSearchHandlerLocalMode(t *testing.T) {
+	rs := newTestServer(t)
+	rs.cfg.SearchMode = searchModeLocal
+	addTestDocuments(t, rs)
+
+	rec := serve(rs, http.MethodPost, "/search/", `{"content":"オフィスアワー"}`, nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", rec.Code, rec.Body)
+	}
+	resp := decodeBody[SearchResponse](t, rec)
+	if len(resp.Results) == 0 || resp.Results[0].DocumentID != "office-hours" {
+		t.Errorf("results = %+v, want office-hours first", resp.Results)
+	}
+}
+
+func TestAddDocumentsHandler(t *testing.T) {
+	rs := newTestServer(t)
+	js, err := json.Marshal(universitydocs.AddDocumentsRequest{Documents: testDocuments})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if rec := serve(rs, http.MethodPost, "/add/", string(js), nil); rec.Code != http.StatusUnauthorized {
+		t.Errorf("without key: status = %d, want 401", rec.Code)
+	}
+
+	rec := serve(rs, http.MethodPost, "/add/", string(js), map[string]string{"X-API-Key": testAPIKey})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", rec.Code, rec.Body)
+	}
+	resp := decodeBody[AddDocumentsResponse](t, rec)
+	if resp.Succeeded != 2 || resp.Failed != 0 || resp.Chunks == 0 {
+		t.Errorf("resp = %+v", resp)
+	}
+	for _, doc := range resp.Documents {
+		if doc.Status != ingest.StatusStored {
+			t.Errorf("document %s status = %q, want %q", doc.ID, doc.Status, ingest.StatusStored)
+		}
+	}
+
+	chunks, _ := rs.store.GetDocument(context.Background(), "library")
+	if len(chunks) == 0 || len(chunks[0].Vector) != 64 || chunks[0].EmbeddingModel != rs.embedder.Model() {
+		t.Errorf("stored chunks = %+v", chunks)
+	}
+}
+
+func TestDocumentsHandlers(t *testing.T) {
+	rs := newTestServer(t)
+	addTestDocuments(t, rs)
+	auth := map[string]string{"Authorization": "Bearer " + testAPIKey}
+
+	rec := serve(rs, http.MethodGet, "/documents/?limit=1", "", auth)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("list: status = %d, body = %s", rec.Code, rec.Body)
+	}
+	list := decodeBody[DocumentListResponse](t, rec)
+	if list.Total != 2 || len(list.Documents) != 1 || list.Documents[0].ID != "library" {
+		t.Errorf("list = %+v, want library of 2", list)
+	}
+	if rec := serve(rs, http.MethodGet, "/documents/?limit=0", "", auth); rec.Code != http.StatusBadRequest {
+		t.Errorf("list limit=0: status = %d, want 400", rec.Code)
+	}
+
+	rec = serve(rs, http.MethodGet, "/documents/office-hours", "", auth)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("get: status = %d, body = %s", rec.Code, rec.Body)
+	}
+	doc := decodeBody[DocumentDetail](t, rec)
+	if doc.Title != "オフィスアワー" || len(doc.Chunks) == 0 || doc.Chunks[0].ChunkIndex != 0 {
+		t.Errorf("doc = %+v", doc)
+	}
+
+	rec = serve(rs, http.MethodDelete, "/documents/office-hours", "", auth)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("delete: status = %d, body = %s", rec.Code, rec.Body)
+	}
+	if rec := serve(rs, http.MethodGet, "/documents/office-hours", "", auth); rec.Code != http.StatusNotFound {
+		t.Errorf("get after delete: status = %d, want 404", rec.Code)
+	}
+	if rec := serve(rs, http.MethodDelete, "/documents/office-hours", "", auth); rec.Code != http.StatusNotFound {
+		t.Errorf("delete twice: status = %d, want 404", rec.Code)
+	}
+	if rec := serve(rs, http.MethodGet, "/documents/", "", nil); rec.Code != http.StatusUnauthorized {
+		t.Errorf("list without key: status = %d, want 401", rec.Code)
+	}
+}
+
+func TestAddDocumentsHandlerRejectsMissingID(t *testing.T) {
+	rs := newTestServer(t)
+	for _, async := range []bool{false, true} {
+		js, err := json.Marshal(universitydocs.AddDocumentsRequest{
+			Documents: []universitydocs.Document{testDocuments[0], {Title: "無題", Content: "本文"}},
+			Async:     async,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		rec := serve(rs, http.MethodPost, "/add/", string(js), map[string]string{"X-API-Key": testAPIKey})
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("async=%v: status = %d, want 400", async, rec.Code)
+		}
+	}
+	// 1つでもIDのないドキュメントがあれば、他のドキュメントも登録しない
+	if docs, _ := rs.store.ListDocuments(context.Background()); len(docs) != 0 {
+		t.Errorf("documents = %+v, want none", docs)
+	}
+}
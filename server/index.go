@@ -0,0 +1,236 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/imaikosuke/iput-tokyo-ai/server/ingest"
+	"github.com/imaikosuke/iput-tokyo-ai/server/pkg/vectorstore"
+)
+
+// 起動時にインデックスの埋め込みモデルが設定と異なる場合の動作
+const (
+	embeddingCheckWarn   = "warn"   // 警告を出力して起動する
+	embeddingCheckRefuse = "refuse" // 起動しない
+	embeddingCheckOff    = "off"    // 確認しない
+)
+
+// IndexResponseは検索に使っているクラスと、すべての版のクラス
+type IndexResponse struct {
+	Active   string                     `json:"active"`
+	Versions []vectorstore.IndexVersion `json:"versions"`
+}
+
+type ActivateIndexRequest struct {
+	Class string `json:"class"`
+}
+
+// 版の切り替えに対応したベクトルストアを返す。対応していない場合は501を返してfalseを返す
+func (rs *ragServer) versionedStore(w http.ResponseWriter) (vectorstore.VersionedStore, bool) {
+	store, ok := rs.store.(vectorstore.VersionedStore)
+	if !ok {
+		http.Error(w, fmt.Sprintf("VECTOR_STORE=%s does not support index versions", rs.cfg.VectorStore), http.StatusNotImplemented)
+	}
+	return store, ok
+}
+
+// indexHandlerはすべての版のクラスとチャンク数、検索に使っているクラスと切り戻し先を返す
+func (rs *ragServer) indexHandler(w http.ResponseWriter, req *http.Request) {
+	store, ok := rs.versionedStore(w)
+	if !ok {
+		return
+	}
+	rs.renderIndex(w, req.Context(), store)
+}
+
+// activateIndexHandlerは検索に使うクラスを切り替える。切り替える前のクラスは切り戻し先として残す
+func (rs *ragServer) activateIndexHandler(w http.ResponseWriter, req *http.Request) {
+	store, ok := rs.versionedStore(w)
+	if !ok {
+		return
+	}
+	ar := &ActivateIndexRequest{}
+	if err := readRequestJSON(req, ar); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if ar.Class == "" {
+		http.Error(w, "class is required", http.StatusBadRequest)
+		return
+	}
+	if err := store.Activate(req.Context(), ar.Class); err != nil {
+		writeIndexError(w, err)
+		return
+	}
+	rs.renderIndex(w, req.Context(), store)
+}
+
+// rollbackIndexHandlerは切り戻し先のクラスに切り替える
+func (rs *ragServer) rollbackIndexHandler(w http.ResponseWriter, req *http.Request) {
+	store, ok := rs.versionedStore(w)
+	if !ok {
+		return
+	}
+	if _, err := store.Rollback(req.Context()); err != nil {
+		writeIndexError(w, err)
+		return
+	}
+	rs.renderIndex(w, req.Context(), store)
+}
+
+// dropIndexHandlerは検索にも切り戻しにも使われていない版のクラスを削除する
+func (rs *ragServer) dropIndexHandler(w http.ResponseWriter, req *http.Request) {
+	store, ok := rs.versionedStore(w)
+	if !ok {
+		return
+	}
+	if err := store.DropVersion(req.Context(), req.PathValue("class")); err != nil {
+		writeIndexError(w, err)
+		return
+	}
+	rs.renderIndex(w, req.Context(), store)
+}
+
+// 版の操作のエラーをHTTPレスポンスとして返す。
+// 存在しない版は404、使用中の版の削除などの状態の競合は409、Weaviateのエラーは502
+func writeIndexError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, vectorstore.ErrVersionNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, vectorstore.ErrVersionConflict):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		log.Printf("changing index version: %v", err)
+		http.Error(w, err.Error(), http.StatusBadGateway)
+	}
+}
+
+func (rs *ragServer) renderIndex(w http.ResponseWriter, ctx context.Context, store vectorstore.VersionedStore) {
+	versions, err := store.Versions(ctx)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	resp := IndexResponse{Versions: versions}
+	for _, v := range versions {
+		if v.Active {
+			resp.Active = v.Class
+		}
+	}
+	renderJSON(w, resp)
+}
+
+// 他のプロセス（cmd/ingest）による検索に使うクラスの切り替えを定期的に反映する
+func (rs *ragServer) refreshIndex(ctx context.Context, interval time.Duration) {
+	store, ok := rs.store.(vectorstore.VersionedStore)
+	if !ok || interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if err := rs.runStage(ctx, stageRetrieval, store.Refresh); err != nil {
+			log.Printf("refreshing index alias: %v", err)
+		}
+	}
+}
+
+// 起動時に、インデックスのチャンクが設定されている埋め込みモデルとチャンク分割の設定で作成されたかを確認する。
+// 埋め込みモデルや次元数が異なるチャンクは検索のベクトルと比較できないため、EMBEDDING_CHECK=refuse ではエラーを返す。
+// チャンク分割の設定やテンプレートが異なるだけのチャンクは検索できるため、警告のみ出力する
+func (rs *ragServer) checkIndexProfile(ctx context.Context) error {
+	mode := rs.cfg.EmbeddingCheck
+	if mode == embeddingCheckOff {
+		return nil
+	}
+	var profile vectorstore.IndexProfile
+	err := rs.runStage(ctx, stageRetrieval, func(ctx context.Context) (err error) {
+		profile, err = rs.store.Profile(ctx)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("reading index profile: %w", err)
+	}
+	if profile.Objects == 0 {
+		return nil
+	}
+
+	// 設定されているモデルのベクトルの次元数を確認する。埋め込みに失敗した場合は次元数を確認しない
+	dim := 0
+	err = rs.runStage(ctx, stageEmbedding, func(ctx context.Context) error {
+		vector, err := rs.embedder.EmbedQuery(ctx, "index check")
+		dim = len(vector)
+		return err
+	})
+	if err != nil {
+		log.Printf("Warning: embedding a probe query failed, skipping the dimension check: %v", err)
+	}
+
+	model := rs.embedder.Model()
+	var mismatches []string
+	for _, m := range sortedKeys(profile.Models) {
+		if m == model {
+			continue
+		}
+		if m == "" {
+			mismatches = append(mismatches, fmt.Sprintf("%d chunks have no recorded embedding model", profile.Models[m]))
+			continue
+		}
+		mismatches = append(mismatches, fmt.Sprintf("%d chunks were embedded with %s (configured %s)", profile.Models[m], m, model))
+	}
+	if dim > 0 {
+		for _, d := range sortedKeys(profile.Dimensions) {
+			if d != 0 && d != dim {
+				mismatches = append(mismatches, fmt.Sprintf("%d chunks have %d dimensions (configured model returns %d)", profile.Dimensions[d], d, dim))
+			}
+		}
+	}
+	if len(mismatches) > 0 {
+		msg := fmt.Sprintf("index does not match EMBEDDING_MODEL: %s; run `make reembed` to re-embed the stale chunks", strings.Join(mismatches, "; "))
+		if mode == embeddingCheckRefuse {
+			return errors.New(msg)
+		}
+		log.Printf("Warning: %s", msg)
+	}
+
+	// チャンク分割の設定が異なるチャンクは登録し直す必要があり、テンプレートだけが異なるチャンクは埋め込み直しでも直る
+	chunkingHash, templateHash := ingest.ChunkingHash(), rs.ingest.TemplateHash()
+	staleChunking, staleTemplate := 0, 0
+	for config, count := range profile.ChunkConfigs {
+		switch {
+		case ingest.ChunkingPart(config) != chunkingHash:
+			staleChunking += count
+		case ingest.TemplatePart(config) != templateHash:
+			staleTemplate += count
+		}
+	}
+	if staleChunking > 0 {
+		log.Printf("Warning: %d of %d chunks were created with different chunk settings; run `make ingest` to re-chunk them", staleChunking, profile.Objects)
+	}
+	if staleTemplate > 0 {
+		log.Printf("Warning: %d of %d chunks were embedded with a different EMBED_TEMPLATE; run `make reembed` or `make ingest` to re-embed them", staleTemplate, profile.Objects)
+	}
+	return nil
+}
+
+// マップのキーを順に返す
+func sortedKeys[K cmp.Ordered, V any](m map[K]V) []K {
+	keys := make([]K, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	return keys
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"testing"
+
+	"github.com/imaikosuke/iput-tokyo-ai/server/pkg/vectorstore"
+)
+
+// 版の記録をメモリに持つVersionedStore。errが設定されている場合はWeaviateのエラーとして返す
+type fakeVersionedStore struct {
+	*vectorstore.MemoryStore
+	classes  []string
+	active   string
+	previous string
+	err      error
+}
+
+func (s *fakeVersionedStore) Versions(ctx context.Context) ([]vectorstore.IndexVersion, error) {
+	versions := make([]vectorstore.IndexVersion, len(s.classes))
+	for i, class := range s.classes {
+		versions[i] = vectorstore.IndexVersion{Class: class, Active: class == s.active, Previous: class == s.previous}
+	}
+	return versions, nil
+}
+
+func (s *fakeVersionedStore) Activate(ctx context.Context, class string) error {
+	if s.err != nil {
+		return s.err
+	}
+	if !slices.Contains(s.classes, class) {
+		return fmt.Errorf("%w: class %s does not exist", vectorstore.ErrVersionNotFound, class)
+	}
+	if class != s.active {
+		s.active, s.previous = class, s.active
+	}
+	return nil
+}
+
+func (s *fakeVersionedStore) Rollback(ctx context.Context) (string, error) {
+	if s.err != nil {
+		return "", s.err
+	}
+	if s.previous == "" {
+		return "", fmt.Errorf("%w: no previous class to roll back to", vectorstore.ErrVersionConflict)
+	}
+	s.active, s.previous = s.previous, s.active
+	return s.active, nil
+}
+
+func (s *fakeVersionedStore) DropVersion(ctx context.Context, class string) error {
+	if s.err != nil {
+		return s.err
+	}
+	if !slices.Contains(s.classes, class) {
+		return fmt.Errorf("%w: class %s does not exist", vectorstore.ErrVersionNotFound, class)
+	}
+	if class == s.active || class == s.previous {
+		return fmt.Errorf("%w: class %s is in use", vectorstore.ErrVersionConflict, class)
+	}
+	s.classes = slices.DeleteFunc(s.classes, func(c string) bool { return c == class })
+	return nil
+}
+
+func (s *fakeVersionedStore) Refresh(ctx context.Context) error {
+	return nil
+}
+
+func TestIndexHandlers(t *testing.T) {
+	admin := map[string]string{"Authorization": "Bearer " + testAPIKey}
+	tests := []struct {
+		name         string
+		method, path string
+		body         string
+		err          error
+		wantStatus   int
+		wantActive   string
+		wantPrevious string
+		wantClasses  int
+	}{
+		{name: "list", method: http.MethodGet, path: "/index/", wantStatus: http.StatusOK, wantActive: "Document_v2", wantPrevious: "Document_v1", wantClasses: 3},
+		{name: "activate", method: http.MethodPost, path: "/index/activate", body: `{"class":"Document_v3"}`, wantStatus: http.StatusOK, wantActive: "Document_v3", wantPrevious: "Document_v2", wantClasses: 3},
+		{name: "activate missing", method: http.MethodPost, path: "/index/activate", body: `{"class":"Document_v9"}`, wantStatus: http.StatusNotFound},
+		{name: "activate without class", method: http.MethodPost, path: "/index/activate", body: `{}`, wantStatus: http.StatusBadRequest},
+		{name: "activate backend error", method: http.MethodPost, path: "/index/activate", body: `{"class":"Document_v3"}`, err: errors.New("connection refused"), wantStatus: http.StatusBadGateway},
+		{name: "rollback", method: http.MethodPost, path: "/index/rollback", wantStatus: http.StatusOK, wantActive: "Document_v1", wantPrevious: "Document_v2", wantClasses: 3},
+		{name: "drop", method: http.MethodDelete, path: "/index/Document_v3", wantStatus: http.StatusOK, wantActive: "Document_v2", wantPrevious: "Document_v1", wantClasses: 2},
+		{name: "drop active", method: http.MethodDelete, path: "/index/Document_v2", wantStatus: http.StatusConflict},
+		{name: "drop previous", method: http.MethodDelete, path: "/index/Document_v1", wantStatus: http.StatusConflict},
+		{name: "drop missing", method: http.MethodDelete, path: "/index/Document_v9", wantStatus: http.StatusNotFound},
+		{name: "drop backend error", method: http.MethodDelete, path: "/index/Document_v3", err: errors.New("connection refused"), wantStatus: http.StatusBadGateway},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rs := newTestServer(t)
+			rs.store = &fakeVersionedStore{
+				MemoryStore: vectorstore.NewMemoryStore(),
+				classes:     []string{"Document_v1", "Document_v2", "Document_v3"},
+				active:      "Document_v2",
+				previous:    "Document_v1",
+				err:         tt.err,
+			}
+			rec := serve(rs, tt.method, tt.path, tt.body, admin)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if rec.Code != http.StatusOK {
+				return
+			}
+			resp := decodeBody[IndexResponse](t, rec)
+			previous := ""
+			for _, v := range resp.Versions {
+				if v.Previous {
+					previous = v.Class
+				}
+			}
+			if resp.Active != tt.wantActive || previous != tt.wantPrevious || len(resp.Versions) != tt.wantClasses {
+				t.Errorf("index = %+v, want active %s, previous %s, %d classes", resp, tt.wantActive, tt.wantPrevious, tt.wantClasses)
+			}
+		})
+	}
+}
+
+func TestRollbackWithoutPrevious(t *testing.T) {
+	rs := newTestServer(t)
+	rs.store = &fakeVersionedStore{MemoryStore: vectorstore.NewMemoryStore(), classes: []string{"Document_v1"}, active: "Document_v1"}
+	rec := serve(rs, http.MethodPost, "/index/rollback", "", map[string]string{"Authorization": "Bearer " + testAPIKey})
+	if rec.Code != http.StatusConflict {
+		t.Errorf("status = %d, want 409", rec.Code)
+	}
+}
+
+func TestIndexHandlersRequireVersionedStore(t *testing.T) {
+	rs := newTestServer(t)
+	rec := serve(rs, http.MethodGet, "/index/", "", map[string]string{"Authorization": "Bearer " + testAPIKey})
+	if rec.Code != http.StatusNotImplemented {
+		t.Errorf("status = %d, want 501", rec.Code)
+	}
+}
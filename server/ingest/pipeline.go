@@ -0,0 +1,284 @@
+// Package ingest はドキュメントをチャンクに分割して埋め込み、ベクトルストアに保存する処理を提供する。
+// サーバーの /add/ と cmd/ingest で同じ処理を使う
+package ingest
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/imaikosuke/iput-tokyo-ai/server/pkg/chunking"
+	"github.com/imaikosuke/iput-tokyo-ai/server/pkg/chunking/config"
+	"github.com/imaikosuke/iput-tokyo-ai/server/pkg/llm"
+	"github.com/imaikosuke/iput-tokyo-ai/server/pkg/vectorstore"
+	"github.com/imaikosuke/iput-tokyo-ai/server/universitydocs"
+)
+
+// 処理の段階。Pipeline.RunStage に渡される
+const (
+	StageEmbedding = "embedding" // チャンクの埋め込み
+	StageStorage   = "storage"   // ベクトルストアへの書き込みと削除
+)
+
+// ドキュメントの登録結果
+const (
+	StatusStored  = "stored"  // すべてのチャンクを保存した
+	StatusFailed  = "failed"  // 一部またはすべてのチャンクの保存に失敗した
+	StatusSkipped = "skipped" // 前のドキュメントの失敗やキャンセルにより処理しなかった
+	StatusPending = "pending" // 非同期ジョブでまだ処理していない
+)
+
+// DocumentResultは1つのドキュメントの登録結果
+type DocumentResult struct {
+	ID     string   `json:"id"`
+	Title  string   `json:"title"`
+	Status string   `json:"status"`
+	Chunks int      `json:"chunks"` // 分割したチャンク数
+	Stored int      `json:"stored"` // 保存したチャンク数
+	Failed int      `json:"failed"` // 保存に失敗したチャンク数
+	Errors []string `json:"errors,omitempty"`
+}
+
+// ChunkSettingsはドキュメントの登録に使うチャンク分割の設定。スナップショットのマニフェストにも書き出したときの設定を記録する
+type ChunkSettings struct {
+	MaxTokens     int `json:"maxTokens"`
+	MinTokens     int `json:"minTokens"`
+	OverlapTokens int `json:"overlapTokens"`
+}
+
+// Chunkingはドキュメントの登録に使うチャンク分割の設定
+var Chunking = ChunkSettings{MaxTokens: 512, MinTokens: 100, OverlapTokens: 50}
+
+// NewChunkerはドキュメントの登録に使うチャンカーを作成する
+func NewChunker() (chunking.Chunker, error) {
+	// チャンカーの設定を構築
+	cfg, err := config.NewConfigBuilder().
+		WithMaxTokens(Chunking.MaxTokens).
+		WithMinTokens(Chunking.MinTokens).
+		WithOverlapTokens(Chunking.OverlapTokens).
+		WithJapaneseConfig(config.NewDefaultJapaneseConfig()).
+		Build()
+	if err != nil {
+		return nil, fmt.Errorf("configuring chunker: %w", err)
+	}
+
+	// チャンカーの初期化
+	chunker, err := chunking.NewChunker(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("initializing chunker: %w", err)
+	}
+	return chunker, nil
+}
+
+// Pipelineはドキュメントの登録処理。複数のゴルーチンから同時に使える
+type Pipeline struct {
+	Chunker  chunking.Chunker
+	Embedder llm.Embedder
+	Store    vectorstore.VectorStore
+	Batch    llm.BatchOptions // 埋め込みのバッチサイズと再試行の方法
+	Template *EmbedTemplate   // 埋め込み用テキストのテンプレート。nilの場合はDefaultEmbedTemplate
+
+	// RunStageは各段階の処理を実行する。タイムアウトの設定などに使う。nilの場合はそのまま実行する
+	RunStage func(ctx context.Context, stage string, fn func(ctx context.Context) error) error
+}
+
+func (p *Pipeline) runStage(ctx context.Context, stage string, fn func(ctx context.Context) error) error {
+	if p.RunStage == nil {
+		return fn(ctx)
+	}
+	return p.RunStage(ctx, stage, fn)
+}
+
+func (p *Pipeline) template() *EmbedTemplate {
+	if p.Template == nil {
+		return defaultEmbedTemplate
+	}
+	return p.Template
+}
+
+// EmbedTextはチャンクの埋め込みに使うテキストを返す
+func (p *Pipeline) EmbedText(c vectorstore.Chunk) (string, error) {
+	return p.template().Text(c)
+}
+
+// ConfigHashはチャンク分割の設定のハッシュと埋め込み用テキストのテンプレートのハッシュを "-" でつないで返す。
+// チャンクに記録し、設定を変更する前に登録されたチャンクの検出に使う
+func (p *Pipeline) ConfigHash() string {
+	return ChunkingHash() + "-" + p.TemplateHash()
+}
+
+// ChunkingHashはチャンク分割の設定のハッシュを返す
+func ChunkingHash() string {
+	return shortHash(Chunking)
+}
+
+// TemplateHashは埋め込み用テキストのテンプレートのハッシュを返す
+func (p *Pipeline) TemplateHash() string {
+	return shortHash(p.template().Source())
+}
+
+// ChunkingPartはチャンクに記録された設定のハッシュのうち、チャンク分割の設定の部分を返す。
+// 分割する前の形式のハッシュや記録がない場合は空を返す（現在の設定とは一致しない）
+func ChunkingPart(configHash string) string {
+	chunking, _, ok := strings.Cut(configHash, "-")
+	if !ok {
+		return ""
+	}
+	return chunking
+}
+
+// TemplatePartはチャンクに記録された設定のハッシュのうち、埋め込み用テキストのテンプレートの部分を返す
+func TemplatePart(configHash string) string {
+	_, template, _ := strings.Cut(configHash, "-")
+	return template
+}
+
+func shortHash(v any) string {
+	data, _ := json.Marshal(v)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:6])
+}
+
+// Staleは登録済みのドキュメントのチャンクが、現在の埋め込みモデルmodel、チャンク分割の設定、
+// 埋め込み用テキストのテンプレートと異なる設定で作成されたかを返す。設定の記録がないチャンクも古いものとして扱う
+func (p *Pipeline) Stale(info vectorstore.DocumentInfo, model string) bool {
+	return info.EmbeddingModel != model || info.ChunkConfig != p.ConfigHash()
+}
+
+// Chunkはドキュメントをチャンクに分割し、埋め込み前のチャンクと埋め込み用のテキストを返す。
+// IDのないドキュメントは universitydocs.ErrMissingID を返す
+func (p *Pipeline) Chunk(doc universitydocs.Document) ([]vectorstore.Chunk, []string, error) {
+	docID := doc.DocumentID()
+	if docID == "" {
+		return nil, nil, fmt.Errorf("document %q: %w", doc.Title, universitydocs.ErrMissingID)
+	}
+	log.Printf("Document content length: %d", len(doc.Content))
+
+	// コンテンツをチャンクに分割
+	chunks, err := p.Chunker.ChunkDocument(doc.Content)
+	if err != nil {
+		log.Printf("Error chunking document: %v", err)
+		return nil, nil, fmt.Errorf("chunking document %q: %w", docID, err)
+	}
+	log.Printf("Document '%s' was split into %d chunks", doc.Title, len(chunks))
+
+	contentHash := doc.ContentHash()
+	chunkConfig := p.ConfigHash()
+	stored := make([]vectorstore.Chunk, len(chunks))
+	texts := make([]string, len(chunks))
+	for i, chunk := range chunks {
+		log.Printf("Chunk %d: %d tokens, %d-%d chars",
+			i, chunk.TokenCount, chunk.StartChar, chunk.EndChar)
+
+		stored[i] = vectorstore.Chunk{
+			UUID:        universitydocs.ChunkUUID(docID, i),
+			DocumentID:  docID,
+			Title:       doc.Title,
+			Content:     chunk.Content,
+			Category:    doc.Category,
+			Tags:        doc.Tags,
+			Department:  doc.Department,
+			UpdatedAt:   doc.UpdatedAt,
+			ChunkIndex:  i,
+			TotalChunks: len(chunks),
+			StartChar:   chunk.StartChar,
+			EndChar:     chunk.EndChar,
+			TokenCount:  chunk.TokenCount,
+			Precedence:  chunk.Precedence,
+			Headings:    chunk.References,
+			SectionPath: vectorstore.SectionPath(chunk.References),
+			ContentHash: contentHash,
+			ChunkConfig: chunkConfig,
+		}
+		// チャンクごとのembedding用テキストを作成
+		texts[i], err = p.EmbedText(stored[i])
+		if err != nil {
+			return nil, nil, fmt.Errorf("document %q: %w", docID, err)
+		}
+	}
+	return stored, texts, nil
+}
+
+// Embedはチャンクをtextsでバッチに分けて埋め込み、ベクトルと埋め込みの設定をチャンクに設定する。
+// textsはEmbedTextで作成したものとし、チャンクの設定のハッシュのうちテンプレートの部分だけを現在のものにする。
+// チャンク分割の部分はチャンクに記録されたものを残す（埋め込み直してもチャンク分割は変わらないため）
+func (p *Pipeline) Embed(ctx context.Context, chunks []vectorstore.Chunk, texts []string) error {
+	// バッチembedding処理。段階の処理はバッチの試行ごとに実行する
+	vectors, err := llm.EmbedBatches(ctx, texts, p.Batch, func(ctx context.Context, batch []string) ([][]float32, error) {
+		var vectors [][]float32
+		err := p.runStage(ctx, StageEmbedding, func(ctx context.Context) error {
+			var err error
+			vectors, err = p.Embedder.EmbedDocuments(ctx, batch)
+			return err
+		})
+		return vectors, err
+	})
+	if err != nil {
+		return fmt.Errorf("batch embedding: %w", err)
+	}
+	model := p.Embedder.Model()
+	templateHash := p.TemplateHash()
+	ingestedAt := time.Now().UTC().Format(time.RFC3339)
+	for i := range chunks {
+		chunks[i].Vector = vectors[i]
+		chunks[i].EmbeddingModel = model
+		chunks[i].EmbeddingDim = len(vectors[i])
+		chunks[i].ChunkConfig = ChunkingPart(chunks[i].ChunkConfig) + "-" + templateHash
+		chunks[i].IngestedAt = ingestedAt
+	}
+	return nil
+}
+
+// Upsertはドキュメントをチャンクに分割して埋め込み、ベクトルストアに保存する。
+// チャンクのUUIDはドキュメントIDとチャンク番号から決まるため、同じドキュメントを再登録すると
+// 既存のチャンクが上書きされる。新しい版のチャンク数が少ない場合は、残った古いチャンクを削除する。
+// 上書きしてから削除するため、再登録中にドキュメントのチャンクが1つもなくなる状態は発生しない。
+// エラーの場合も、返す結果にはどこまで処理したかとエラーメッセージを記録する
+func (p *Pipeline) Upsert(ctx context.Context, doc universitydocs.Document) (DocumentResult, error) {
+	docID := doc.DocumentID()
+	result := DocumentResult{ID: docID, Title: doc.Title, Status: StatusFailed}
+	fail := func(err error) (DocumentResult, error) {
+		result.Errors = append(result.Errors, err.Error())
+		return result, err
+	}
+
+	chunks, texts, err := p.Chunk(doc)
+	if err != nil {
+		return fail(err)
+	}
+	result.Chunks = len(chunks)
+
+	if err := p.Embed(ctx, chunks, texts); err != nil {
+		result.Failed = len(chunks)
+		return fail(err)
+	}
+
+	// ベクトルストアへの保存（前の版から残ったチャンクも削除される）
+	err = p.runStage(ctx, StageStorage, func(ctx context.Context) error {
+		return p.Store.UpsertDocument(ctx, docID, chunks)
+	})
+	var batchErr *vectorstore.BatchError
+	if errors.As(err, &batchErr) {
+		// 一部のチャンクのみ保存に失敗した場合は、チャンクごとのエラーを記録する
+		result.Failed = len(batchErr.Failed)
+		result.Stored = len(chunks) - result.Failed
+		for _, f := range batchErr.Failed {
+			result.Errors = append(result.Errors, fmt.Sprintf("chunk %d: %s", f.ChunkIndex, f.Message))
+		}
+		return result, fmt.Errorf("storing document %q: %w", docID, err)
+	}
+	if err != nil {
+		result.Failed = len(chunks)
+		return fail(fmt.Errorf("storing document %q: %w", docID, err))
+	}
+
+	result.Status = StatusStored
+	result.Stored = len(chunks)
+	return result, nil
+}
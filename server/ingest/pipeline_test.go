@@ -0,0 +1,79 @@
+package ingest
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/imaikosuke/iput-tokyo-ai/server/pkg/llm"
+	"github.com/imaikosuke/iput-tokyo-ai/server/pkg/vectorstore"
+	"github.com/imaikosuke/iput-tokyo-ai/server/universitydocs"
+)
+
+func TestConfigHash(t *testing.T) {
+	p := &Pipeline{}
+	hash := p.ConfigHash()
+	if ChunkingPart(hash) != ChunkingHash() || TemplatePart(hash) != p.TemplateHash() {
+		t.Errorf("ConfigHash = %q, want %s-%s", hash, ChunkingHash(), p.TemplateHash())
+	}
+	if ChunkingPart("0123456789ab") != "" || ChunkingPart("") != "" {
+		t.Error("hashes without a separator must not match any chunk settings")
+	}
+
+	custom, err := ParseEmbedTemplate("{{.Content}}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	other := (&Pipeline{Template: custom}).ConfigHash()
+	if ChunkingPart(other) != ChunkingPart(hash) || TemplatePart(other) == TemplatePart(hash) {
+		t.Errorf("changing the template must only change the template part: %q vs %q", other, hash)
+	}
+}
+
+func TestEmbedKeepsChunkingHash(t *testing.T) {
+	p := &Pipeline{Embedder: llm.NewLocalEmbedder(8)}
+	current := p.ConfigHash()
+	staleChunking := "000000000000-" + p.TemplateHash()
+
+	tests := []struct {
+		name   string
+		config string
+		want   string
+		stale  bool
+	}{
+		{name: "current", config: current, want: current},
+		{name: "old template", config: ChunkingHash() + "-ffffffffffff", want: current},
+		{name: "old chunk settings", config: "000000000000-ffffffffffff", want: staleChunking, stale: true},
+		{name: "unsplit hash", config: "0123456789ab", want: "-" + p.TemplateHash(), stale: true},
+		{name: "not recorded", config: "", want: "-" + p.TemplateHash(), stale: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chunks := []vectorstore.Chunk{{Content: "本文", ChunkConfig: tt.config}}
+			if err := p.Embed(context.Background(), chunks, []string{"本文"}); err != nil {
+				t.Fatal(err)
+			}
+			if chunks[0].ChunkConfig != tt.want {
+				t.Errorf("ChunkConfig = %q, want %q", chunks[0].ChunkConfig, tt.want)
+			}
+
+			// チャンク分割の設定が古いチャンクは埋め込み直した後も古いものとして扱う
+			info := vectorstore.DocumentInfo{EmbeddingModel: chunks[0].EmbeddingModel, ChunkConfig: chunks[0].ChunkConfig}
+			if got := p.Stale(info, p.Embedder.Model()); got != tt.stale {
+				t.Errorf("Stale = %v, want %v", got, tt.stale)
+			}
+		})
+	}
+}
+
+func TestUpsertRejectsMissingID(t *testing.T) {
+	store := vectorstore.NewMemoryStore()
+	p := &Pipeline{Embedder: llm.NewLocalEmbedder(8), Store: store}
+	result, err := p.Upsert(context.Background(), universitydocs.Document{Title: "無題", Content: "本文"})
+	if !errors.Is(err, universitydocs.ErrMissingID) {
+		t.Fatalf("Upsert = %v, want ErrMissingID", err)
+	}
+	if result.Status != StatusFailed || len(result.Errors) != 1 {
+		t.Errorf("result = %+v", result)
+	}
+}
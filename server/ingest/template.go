@@ -0,0 +1,82 @@
+package ingest
+
+import (
+	"fmt"
+	"strings"
+	"text/template"
+
+	"github.com/imaikosuke/iput-tokyo-ai/server/pkg/vectorstore"
+)
+
+// DefaultEmbedTemplateは埋め込み用テキストのデフォルトのテンプレート。
+// 見出しのパスを含めることで、見出しの下の短いチャンクも文脈を含めて埋め込まれる
+const DefaultEmbedTemplate = "Title: {{.Title}}\nCategory: {{.Category}}\nDepartment: {{.Department}}\n" +
+	"{{if .Section}}Section: {{.Section}}\n{{end}}Content: {{.Content}}"
+
+// EmbedTemplateはチャンクの埋め込みに使うテキストのテンプレート（text/template）。
+// .Title .Category .Department .Tags .UpdatedAt .Section（見出しのパス） .Content を使える
+type EmbedTemplate struct {
+	source string
+	tmpl   *template.Template
+}
+
+// テンプレートに渡すチャンクの情報
+type embedFields struct {
+	Title      string
+	Category   string
+	Department string
+	Tags       []string
+	UpdatedAt  string
+	Section    string
+	Content    string
+}
+
+var defaultEmbedTemplate = func() *EmbedTemplate {
+	t, err := ParseEmbedTemplate(DefaultEmbedTemplate)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}()
+
+// ParseEmbedTemplateはテンプレートを解析する。空の場合はDefaultEmbedTemplateを使う。
+// 環境変数で指定しやすいように、\nの2文字は改行として扱う
+func ParseEmbedTemplate(source string) (*EmbedTemplate, error) {
+	if source == "" {
+		source = DefaultEmbedTemplate
+	}
+	source = strings.ReplaceAll(source, `\n`, "\n")
+	tmpl, err := template.New("embed").Option("missingkey=error").Parse(source)
+	if err != nil {
+		return nil, fmt.Errorf("parsing embed template: %w", err)
+	}
+	t := &EmbedTemplate{source: source, tmpl: tmpl}
+	// 存在しないフィールドは実行時のエラーになるため、解析時に確認する
+	if _, err := t.Text(vectorstore.Chunk{}); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// Sourceはテンプレートの文字列を返す
+func (t *EmbedTemplate) Source() string {
+	return t.source
+}
+
+// Textはチャンクの埋め込みに使うテキストを返す
+func (t *EmbedTemplate) Text(c vectorstore.Chunk) (string, error) {
+	var b strings.Builder
+	err := t.tmpl.Execute(&b, embedFields{
+		Title:      c.Title,
+		Category:   c.Category,
+		Department: c.Department,
+		Tags:       c.Tags,
+		UpdatedAt:  c.UpdatedAt,
+		Section:    c.SectionPath,
+		Content:    c.Content,
+	})
+	if err != nil {
+		return "", fmt.Errorf("executing embed template: %w", err)
+	}
+	return b.String(), nil
+}
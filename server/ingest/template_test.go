@@ -0,0 +1,90 @@
+package ingest
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/imaikosuke/iput-tokyo-ai/server/pkg/vectorstore"
+)
+
+func TestParseEmbedTemplateErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		want   string
+	}{
+		{name: "syntax", source: "{{.Title", want: "parsing embed template"},
+		{name: "unknown field", source: "{{.Body}}", want: "executing embed template"},
+		{name: "unknown function", source: "{{upper .Title}}", want: "parsing embed template"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseEmbedTemplate(tt.source)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("ParseEmbedTemplate(%q) = %v, want an error containing %q", tt.source, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestEmbedTemplateText(t *testing.T) {
+	chunk := vectorstore.Chunk{
+		Title:       "オフィスアワー",
+		Category:    "授業",
+		Department:  "情報学部",
+		Tags:        []string{"教員", "時間割"},
+		UpdatedAt:   "2024-04-01",
+		SectionPath: "授業時間等 › オフィスアワー",
+		Content:     "毎週水曜日の午後です。",
+	}
+	noSection := chunk
+	noSection.SectionPath = ""
+
+	tests := []struct {
+		name   string
+		source string
+		chunk  vectorstore.Chunk
+		want   string
+	}{
+		{
+			// 見出しのないチャンクは以前の固定の形式と同じテキストになる
+			name:  "default without section",
+			chunk: noSection,
+			want: fmt.Sprintf("Title: %s\nCategory: %s\nDepartment: %s\nContent: %s",
+				noSection.Title, noSection.Category, noSection.Department, noSection.Content),
+		},
+		{
+			name:  "default with section",
+			chunk: chunk,
+			want:  "Title: オフィスアワー\nCategory: 授業\nDepartment: 情報学部\nSection: 授業時間等 › オフィスアワー\nContent: 毎週水曜日の午後です。",
+		},
+		{
+			name:   "escaped newline",
+			source: `{{.Section}}\n{{.Content}}`,
+			chunk:  chunk,
+			want:   "授業時間等 › オフィスアワー\n毎週水曜日の午後です。",
+		},
+		{
+			name:   "tags and updated at",
+			source: `{{range .Tags}}#{{.}} {{end}}({{.UpdatedAt}})`,
+			chunk:  chunk,
+			want:   "#教員 #時間割 (2024-04-01)",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpl, err := ParseEmbedTemplate(tt.source)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := tmpl.Text(tt.chunk)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("Text =\n%q\nwant\n%q", got, tt.want)
+			}
+		})
+	}
+}
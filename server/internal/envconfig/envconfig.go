@@ -0,0 +1,120 @@
+// Package envconfig はサーバーとコマンド（cmd/ingest, cmd/migrate, cmd/snapshot）で共通の環境変数の読み込みを提供する。
+// 同じ環境変数を同じデフォルト値で読み込むため、サーバーとコマンドの設定が食い違わない
+package envconfig
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/imaikosuke/iput-tokyo-ai/server/pkg/llm"
+	"github.com/imaikosuke/iput-tokyo-ai/server/pkg/vectorstore"
+)
+
+// Int は環境変数を整数として読み込む。未設定や不正な値の場合はdefを返す
+func Int(key string, def int) int {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		log.Printf("Warning: invalid %s=%q, using default %d", key, v, def)
+		return def
+	}
+	return n
+}
+
+// Float は環境変数を小数として読み込む。未設定や不正な値の場合はdefを返す
+func Float(key string, def float64) float64 {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		log.Printf("Warning: invalid %s=%q, using default %g", key, v, def)
+		return def
+	}
+	return f
+}
+
+// Duration は環境変数を時間（例: 10s）として読み込む。未設定や不正な値の場合はdefを返す
+func Duration(key string, def time.Duration) time.Duration {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		log.Printf("Warning: invalid %s=%q, using default %s", key, v, def)
+		return def
+	}
+	return d
+}
+
+// Weaviate はWVHOST、WVPORT、WV_TOKENIZATIONからWeaviateの設定を返す。
+// WVHOSTが未設定の場合はdefaultHostを使う（コンテナ内のサーバーは "weaviate"、ホストのコマンドは "localhost"）
+func Weaviate(defaultHost string) vectorstore.WeaviateConfig {
+	host := cmp.Or(os.Getenv("WVHOST"), defaultHost)
+	port := cmp.Or(os.Getenv("WVPORT"), "8080")
+	return vectorstore.WeaviateConfig{
+		Host:         fmt.Sprintf("%s:%s", host, port),
+		Tokenization: cmp.Or(os.Getenv("WV_TOKENIZATION"), "trigram"),
+	}
+}
+
+// OpenWeaviate はコマンドからWeaviateに接続し、スキーマのマイグレーションを適用する。
+// コマンドはサーバーの外からWeaviateに書き込むため、VECTOR_STOREがweaviate以外の場合はエラーにする
+func OpenWeaviate(ctx context.Context, command string) (*vectorstore.WeaviateStore, error) {
+	if err := checkVectorStore(command); err != nil {
+		return nil, err
+	}
+	return vectorstore.NewWeaviateStore(ctx, Weaviate("localhost"))
+}
+
+// OpenWeaviateReadOnly はコマンドからWeaviateにスキーマを変更せずに接続する（ドライラン用）。
+// マイグレーションが適用されていない場合は vectorstore.ErrSchemaNotMigrated を返す
+func OpenWeaviateReadOnly(ctx context.Context, command string) (*vectorstore.WeaviateStore, error) {
+	if err := checkVectorStore(command); err != nil {
+		return nil, err
+	}
+	return vectorstore.OpenWeaviateStore(ctx, Weaviate("localhost"))
+}
+
+func checkVectorStore(command string) error {
+	if kind := cmp.Or(os.Getenv("VECTOR_STORE"), "weaviate"); kind != "weaviate" {
+		return fmt.Errorf("VECTOR_STORE=%s is not supported by %s, only weaviate can be used from outside the server", kind, command)
+	}
+	return nil
+}
+
+// Provider はLLM_PROVIDERなどから埋め込みと生成のプロバイダーの設定を返す
+func Provider() llm.ProviderConfig {
+	return llm.ProviderConfig{
+		Provider:          cmp.Or(os.Getenv("LLM_PROVIDER"), llm.ProviderGemini),
+		GenerativeModel:   os.Getenv("GENERATIVE_MODEL"),
+		EmbeddingModel:    os.Getenv("EMBEDDING_MODEL"),
+		GeminiAPIKey:      os.Getenv("GEMINI_API_KEY"),
+		OpenAIBaseURL:     cmp.Or(os.Getenv("OPENAI_BASE_URL"), "https://api.openai.com/v1"),
+		OpenAIAPIKey:      os.Getenv("OPENAI_API_KEY"),
+		LocalEmbeddingDim: Int("LOCAL_EMBEDDING_DIM", 768),
+		LocalAnswer:       os.Getenv("LOCAL_ANSWER"),
+	}
+}
+
+// EmbedBatch はドキュメントの登録時に埋め込むバッチの大きさと再試行の設定を返す
+func EmbedBatch() llm.BatchOptions {
+	return llm.BatchOptions{
+		Size: max(Int("EMBED_BATCH_SIZE", 100), 1),
+		Retry: llm.RetryPolicy{
+			MaxAttempts: Int("EMBED_MAX_ATTEMPTS", 5),
+			BaseDelay:   Duration("EMBED_RETRY_BASE_DELAY", 500*time.Millisecond),
+			MaxDelay:    Duration("EMBED_RETRY_MAX_DELAY", 10*time.Second),
+		},
+	}
+}
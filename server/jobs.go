@@ -0,0 +1,541 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/imaikosuke/iput-tokyo-ai/server/ingest"
+	"github.com/imaikosuke/iput-tokyo-ai/server/universitydocs"
+)
+
+// ジョブの状態
+const (
+	jobQueued    = "queued"    // 実行を待っている
+	jobRunning   = "running"   // 実行中
+	jobSucceeded = "succeeded" // すべてのドキュメントを登録した
+	jobFailed    = "failed"    // 1つ以上のドキュメントの登録に失敗した
+	jobCanceled  = "canceled"  // キャンセルされた
+)
+
+var (
+	errJobCanceled   = errors.New("job canceled")
+	errJobFailed     = errors.New("document failed")
+	errManagerClosed = errors.New("job manager is shutting down")
+)
+
+// ingestJobはドキュメントの非同期登録ジョブの状態。
+// ドキュメント本体は別のファイルに保存し、状態のファイルには含めない
+type ingestJob struct {
+	ID              string                  `json:"id"`
+	Status          string                  `json:"status"`
+	ContinueOnError bool                    `json:"continueOnError"`
+	CreatedAt       time.Time               `json:"createdAt"`
+	StartedAt       *time.Time              `json:"startedAt,omitempty"`
+	FinishedAt      *time.Time              `json:"finishedAt,omitempty"`
+	Error           string                  `json:"error,omitempty"` // ジョブ全体のエラー
+	Results         []ingest.DocumentResult `json:"documents"`       // 登録するドキュメントと同じ順序
+}
+
+// JobProgressはジョブの進捗
+type JobProgress struct {
+	Total     int `json:"total"`
+	Done      int `json:"done"`
+	Succeeded int `json:"succeeded"`
+	Failed    int `json:"failed"`
+	Skipped   int `json:"skipped"`
+	Pending   int `json:"pending"`
+}
+
+type JobResponse struct {
+	*ingestJob
+	Progress JobProgress `json:"progress"`
+}
+
+// ジョブの進捗を集計する
+func (j *ingestJob) progress() JobProgress {
+	p := JobProgress{Total: len(j.Results)}
+	for _, r := range j.Results {
+		switch r.Status {
+		case ingest.StatusStored:
+			p.Succeeded++
+		case ingest.StatusFailed:
+			p.Failed++
+		case ingest.StatusSkipped:
+			p.Skipped++
+		default:
+			p.Pending++
+		}
+	}
+	p.Done = p.Total - p.Pending
+	return p
+}
+
+// ジョブが終了しているかを返す
+func (j *ingestJob) finished() bool {
+	return j.Status != jobQueued && j.Status != jobRunning
+}
+
+// jobManagerは登録ジョブを1つずつ順に実行し、ジョブ内のドキュメントをworkers個のワーカーで並列に処理する。
+// ジョブの状態はdir以下のファイルに保存し、再起動後は未完了のジョブを続きから実行する。
+// 終了したジョブのドキュメントはすぐに、状態はretentionが経過したら削除する
+type jobManager struct {
+	dir       string
+	workers   int
+	retention time.Duration
+	process   func(ctx context.Context, doc universitydocs.Document) (ingest.DocumentResult, error)
+
+	mu      sync.Mutex
+	jobs    map[string]*ingestJob
+	queue   []string                           // 実行を待っているジョブのID
+	cancels map[string]context.CancelCauseFunc // 実行中のジョブのキャンセル関数
+	waiters map[string]chan struct{}           // ジョブの終了を待っているwaitに通知するチャネル
+	wake    chan struct{}
+
+	ctx  context.Context
+	stop context.CancelCauseFunc
+	done chan struct{}
+}
+
+// ジョブの保存先を読み込み、未完了のジョブを再開するjobManagerを作成する。retentionが0の場合は終了したジョブの状態を削除しない
+func newJobManager(dir string, workers int, retention time.Duration, process func(ctx context.Context, doc universitydocs.Document) (ingest.DocumentResult, error)) (*jobManager, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("creating job directory: %w", err)
+	}
+
+	ctx, stop := context.WithCancelCause(context.Background())
+	m := &jobManager{
+		dir:       dir,
+		workers:   max(workers, 1),
+		retention: retention,
+		process:   process,
+		jobs:      make(map[string]*ingestJob),
+		cancels:   make(map[string]context.CancelCauseFunc),
+		waiters:   make(map[string]chan struct{}),
+		wake:      make(chan struct{}, 1),
+		ctx:       ctx,
+		stop:      stop,
+		done:      make(chan struct{}),
+	}
+	if err := m.load(); err != nil {
+		return nil, err
+	}
+	go m.dispatch()
+	return m, nil
+}
+
+// 保存されたジョブを読み込む。実行中だったジョブは待機中に戻して作成順に再開する
+func (m *jobManager) load() error {
+	paths, err := filepath.Glob(filepath.Join(m.dir, "*.json"))
+	if err != nil {
+		return err
+	}
+	var resumed []*ingestJob
+	for _, path := range paths {
+		if strings.HasSuffix(path, ".docs.json") {
+			continue
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("reading job %s: %w", path, err)
+		}
+		job := &ingestJob{}
+		if err := json.Unmarshal(data, job); err != nil {
+			log.Printf("Warning: skipping corrupt job file %s: %v", path, err)
+			continue
+		}
+		m.jobs[job.ID] = job
+		if !job.finished() {
+			job.Status = jobQueued
+			resumed = append(resumed, job)
+		} else {
+			// 以前の版では終了したジョブのドキュメントを残していた
+			m.removeDocs(job.ID)
+		}
+	}
+	m.pruneFinished()
+
+	slices.SortFunc(resumed, func(a, b *ingestJob) int {
+		return a.CreatedAt.Compare(b.CreatedAt)
+	})
+	for _, job := range resumed {
+		m.queue = append(m.queue, job.ID)
+	}
+	if len(resumed) > 0 {
+		log.Printf("resuming %d ingestion jobs", len(resumed))
+	}
+	return nil
+}
+
+// ドキュメントを登録するジョブを作成してキューに追加する
+func (m *jobManager) submit(docs []universitydocs.Document, continueOnError bool) (*ingestJob, error) {
+	if err := universitydocs.ValidateIDs(docs); err != nil {
+		return nil, err
+	}
+	job := &ingestJob{
+		ID:              newJobID(),
+		Status:          jobQueued,
+		ContinueOnError: continueOnError,
+		CreatedAt:       time.Now(),
+		Results:         make([]ingest.DocumentResult, len(docs)),
+	}
+	for i, doc := range docs {
+		job.Results[i] = ingest.DocumentResult{ID: doc.DocumentID(), Title: doc.Title, Status: ingest.StatusPending}
+	}
+
+	if m.ctx.Err() != nil {
+		return nil, errManagerClosed
+	}
+	if err := writeFileAtomic(m.docsPath(job.ID), docs); err != nil {
+		return nil, fmt.Errorf("saving job documents: %w", err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	// ドキュメントの保存中に終了した場合や状態を保存できなかった場合は、再開されないドキュメントを残さない
+	if m.ctx.Err() != nil {
+		m.removeDocs(job.ID)
+		return nil, errManagerClosed
+	}
+	if err := m.save(job); err != nil {
+		m.removeDocs(job.ID)
+		return nil, err
+	}
+	m.jobs[job.ID] = job
+	m.queue = append(m.queue, job.ID)
+	m.notify()
+	m.pruneFinished()
+	return job, nil
+}
+
+// ジョブのIDを作成する。作成した日時から始まるため、ファイル名の順がジョブの作成順になる
+func newJobID() string {
+	b := make([]byte, 6)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return time.Now().UTC().Format("20060102T150405") + "-" + hex.EncodeToString(b)
+}
+
+// ジョブの現在の状態のコピーを返す
+func (m *jobManager) get(id string) (JobResponse, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	job, ok := m.jobs[id]
+	if !ok {
+		return JobResponse{}, false
+	}
+	snapshot := *job
+	snapshot.Results = slices.Clone(job.Results)
+	return JobResponse{ingestJob: &snapshot, Progress: snapshot.progress()}, true
+}
+
+// ジョブをキャンセルする。待機中のジョブはすぐに、実行中のジョブは処理中のドキュメントを中断して終了する
+func (m *jobManager) cancel(id string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	job, ok := m.jobs[id]
+	if !ok {
+		return false, nil
+	}
+	switch job.Status {
+	case jobQueued:
+		m.queue = slices.DeleteFunc(m.queue, func(queued string) bool { return queued == id })
+		m.finish(job, jobCanceled)
+		return true, m.save(job)
+	case jobRunning:
+		m.cancels[id](errJobCanceled)
+	}
+	return true, nil
+}
+
+// ジョブが終了するまで待ち、終了した状態を返す
+func (m *jobManager) wait(ctx context.Context, id string) (JobResponse, error) {
+	m.mu.Lock()
+	job, ok := m.jobs[id]
+	if !ok {
+		m.mu.Unlock()
+		return JobResponse{}, fmt.Errorf("job %q not found", id)
+	}
+	ch := m.waiters[id]
+	if ch == nil && !job.finished() {
+		ch = make(chan struct{})
+		m.waiters[id] = ch
+	}
+	m.mu.Unlock()
+
+	if ch != nil {
+		select {
+		case <-ch:
+		case <-ctx.Done():
+			return JobResponse{}, ctx.Err()
+		}
+	}
+	resp, _ := m.get(id)
+	return resp, nil
+}
+
+// 実行中のジョブを中断して終了する。中断したジョブは待機中として保存し、次の起動時に再開する
+func (m *jobManager) shutdown(ctx context.Context) error {
+	m.stop(errManagerClosed)
+	select {
+	case <-m.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (m *jobManager) notify() {
+	select {
+	case m.wake <- struct{}{}:
+	default:
+	}
+}
+
+// キューからジョブを取り出して順に実行する
+func (m *jobManager) dispatch() {
+	defer close(m.done)
+	for {
+		m.mu.Lock()
+		var id string
+		if len(m.queue) > 0 {
+			id, m.queue = m.queue[0], m.queue[1:]
+		}
+		m.mu.Unlock()
+
+		if id == "" {
+			select {
+			case <-m.wake:
+				continue
+			case <-m.ctx.Done():
+				return
+			}
+		}
+		if m.ctx.Err() != nil {
+			return
+		}
+		m.run(id)
+	}
+}
+
+// ジョブの未処理のドキュメントをワーカーで並列に登録する
+func (m *jobManager) run(id string) {
+	var docs []universitydocs.Document
+	docsErr := readJSONFile(m.docsPath(id), &docs)
+
+	ctx, cancel := context.WithCancelCause(m.ctx)
+	defer cancel(nil)
+
+	m.mu.Lock()
+	job := m.jobs[id]
+	if job == nil || job.Status != jobQueued {
+		m.mu.Unlock()
+		return
+	}
+	if docsErr != nil {
+		log.Printf("job %s: reading documents: %v", id, docsErr)
+		job.Error = fmt.Sprintf("reading documents: %v", docsErr)
+		m.finish(job, jobFailed)
+		m.saveLogged(job)
+		m.mu.Unlock()
+		return
+	}
+	m.cancels[id] = cancel
+	job.Status = jobRunning
+	now := time.Now()
+	job.StartedAt = &now
+	m.saveLogged(job)
+	var pending []int
+	for i, r := range job.Results {
+		if r.Status == ingest.StatusPending {
+			pending = append(pending, i)
+		}
+	}
+	m.mu.Unlock()
+	log.Printf("job %s: processing %d documents with %d workers", id, len(pending), m.workers)
+
+	work := make(chan int)
+	var wg sync.WaitGroup
+	for range min(m.workers, max(len(pending), 1)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range work {
+				result, err := m.process(ctx, docs[i])
+				if err != nil && ctx.Err() != nil {
+					// 中断によって失敗したドキュメントは未処理のままにする
+					continue
+				}
+
+				m.mu.Lock()
+				job.Results[i] = result
+				if err != nil && !job.ContinueOnError {
+					cancel(errJobFailed)
+				}
+				m.saveLogged(job)
+				m.mu.Unlock()
+			}
+		}()
+	}
+feed:
+	for _, i := range pending {
+		select {
+		case work <- i:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(work)
+	wg.Wait()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.cancels, id)
+	cause := context.Cause(ctx)
+	switch {
+	case errors.Is(cause, errManagerClosed):
+		// 終了時に中断したジョブは次の起動時に続きから実行する
+		job.Status = jobQueued
+		log.Printf("job %s: interrupted by shutdown, will resume on restart", id)
+	case errors.Is(cause, errJobCanceled):
+		m.finish(job, jobCanceled)
+	default:
+		status := jobSucceeded
+		if job.progress().Failed > 0 {
+			status = jobFailed
+		}
+		m.finish(job, status)
+	}
+	m.saveLogged(job)
+	log.Printf("job %s: %s", id, job.Status)
+}
+
+// ジョブを終了し、未処理のドキュメントをskippedにする。再開しないためドキュメントのファイルは削除する。
+// ロックを保持して呼び出す
+func (m *jobManager) finish(job *ingestJob, status string) {
+	for i := range job.Results {
+		if job.Results[i].Status == ingest.StatusPending {
+			job.Results[i].Status = ingest.StatusSkipped
+		}
+	}
+	job.Status = status
+	now := time.Now()
+	job.FinishedAt = &now
+	if ch, ok := m.waiters[job.ID]; ok {
+		close(ch)
+		delete(m.waiters, job.ID)
+	}
+	m.removeDocs(job.ID)
+}
+
+// 終了してからretentionが経過したジョブを削除する。ロックを保持して呼び出す（loadでは不要）
+func (m *jobManager) pruneFinished() {
+	if m.retention <= 0 {
+		return
+	}
+	cutoff := time.Now().Add(-m.retention)
+	for id, job := range m.jobs {
+		if !job.finished() || job.FinishedAt == nil || job.FinishedAt.After(cutoff) {
+			continue
+		}
+		if err := os.Remove(m.jobPath(id)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			log.Printf("removing job %s: %v", id, err)
+			continue
+		}
+		m.removeDocs(id)
+		delete(m.jobs, id)
+	}
+}
+
+func (m *jobManager) removeDocs(id string) {
+	if err := os.Remove(m.docsPath(id)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Printf("removing documents of job %s: %v", id, err)
+	}
+}
+
+func (m *jobManager) jobPath(id string) string {
+	return filepath.Join(m.dir, id+".json")
+}
+
+func (m *jobManager) docsPath(id string) string {
+	return filepath.Join(m.dir, id+".docs.json")
+}
+
+// ジョブの状態を保存する。ロックを保持して呼び出す
+func (m *jobManager) save(job *ingestJob) error {
+	if err := writeFileAtomic(m.jobPath(job.ID), job); err != nil {
+		return fmt.Errorf("saving job %s: %w", job.ID, err)
+	}
+	return nil
+}
+
+func (m *jobManager) saveLogged(job *ingestJob) {
+	if err := m.save(job); err != nil {
+		log.Print(err)
+	}
+}
+
+// vをJSONとして一時ファイルに書き込み、リネームで置き換える。書き込み途中のファイルが読まれることはない
+func writeFileAtomic(path string, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func readJSONFile(path string, v any) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// getJobHandlerはジョブの状態と進捗、ドキュメントごとの結果を返す
+func (rs *ragServer) getJobHandler(w http.ResponseWriter, req *http.Request) {
+	id := req.PathValue("id")
+	job, ok := rs.jobs.get(id)
+	if !ok {
+		http.Error(w, fmt.Sprintf("job %q not found", id), http.StatusNotFound)
+		return
+	}
+	renderJSON(w, job)
+}
+
+// cancelJobHandlerはジョブをキャンセルする。終了したジョブに対しては何もしない
+func (rs *ragServer) cancelJobHandler(w http.ResponseWriter, req *http.Request) {
+	id := req.PathValue("id")
+	ok, err := rs.jobs.cancel(id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		http.Error(w, fmt.Sprintf("job %q not found", id), http.StatusNotFound)
+		return
+	}
+	// 実行中のジョブは処理中のドキュメントの中断を待たずに202を返す
+	job, _ := rs.jobs.get(id)
+	status := http.StatusOK
+	if !job.finished() {
+		status = http.StatusAccepted
+	}
+	renderJSONStatus(w, status, job)
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/imaikosuke/iput-tokyo-ai/server/ingest"
+	"github.com/imaikosuke/iput-tokyo-ai/server/universitydocs"
+)
+
+// テスト用のパイプライン。タイトルが"fail"のドキュメントは失敗し、
+// blockがtrueの場合はタイトルが"block"のドキュメントをctxがキャンセルされるまで戻さない
+type fakePipeline struct {
+	block   bool
+	started chan string // 処理を始めたドキュメントのID
+
+	mu        sync.Mutex
+	processed []string
+}
+
+func newFakePipeline(block bool) *fakePipeline {
+	return &fakePipeline{block: block, started: make(chan string, 10)}
+}
+
+func (p *fakePipeline) process(ctx context.Context, doc universitydocs.Document) (ingest.DocumentResult, error) {
+	if err := ctx.Err(); err != nil {
+		return ingest.DocumentResult{}, err
+	}
+	p.started <- doc.ID
+	result := ingest.DocumentResult{ID: doc.ID, Title: doc.Title, Status: ingest.StatusStored}
+	switch doc.Title {
+	case "fail":
+		result.Status = ingest.StatusFailed
+		return result, errors.New("embedding failed")
+	case "block":
+		if p.block {
+			<-ctx.Done()
+			return ingest.DocumentResult{}, context.Cause(ctx)
+		}
+	}
+	p.mu.Lock()
+	p.processed = append(p.processed, doc.ID)
+	p.mu.Unlock()
+	return result, nil
+}
+
+func testJobDocs(titles ...string) []universitydocs.Document {
+	docs := make([]universitydocs.Document, len(titles))
+	for i, title := range titles {
+		docs[i] = universitydocs.Document{ID: "doc" + string(rune('a'+i)), Title: title, Content: "本文"}
+	}
+	return docs
+}
+
+func newTestJobManager(t *testing.T, dir string, retention time.Duration, p *fakePipeline) *jobManager {
+	t.Helper()
+	m, err := newJobManager(dir, 1, retention, p.process)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { m.shutdown(context.Background()) })
+	return m
+}
+
+func waitJob(t *testing.T, m *jobManager, id string) JobResponse {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	job, err := m.wait(ctx, id)
+	if err != nil {
+		t.Fatalf("waiting for job %s: %v", id, err)
+	}
+	return job
+}
+
+func TestJobManagerRun(t *testing.T) {
+	tests := []struct {
+		name            string
+		titles          []string
+		continueOnError bool
+		wantStatus      string
+		want            JobProgress
+	}{
+		{
+			name:       "all stored",
+			titles:     []string{"a", "b"},
+			wantStatus: jobSucceeded,
+			want:       JobProgress{Total: 2, Done: 2, Succeeded: 2},
+		},
+		{
+			name:       "stop on error",
+			titles:     []string{"fail", "a", "b"},
+			wantStatus: jobFailed,
+			want:       JobProgress{Total: 3, Done: 3, Failed: 1, Skipped: 2},
+		},
+		{
+			name:            "continue on error",
+			titles:          []string{"fail", "a", "b"},
+			continueOnError: true,
+			wantStatus:      jobFailed,
+			want:            JobProgress{Total: 3, Done: 3, Succeeded: 2, Failed: 1},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			m := newTestJobManager(t, dir, 0, newFakePipeline(false))
+			job, err := m.submit(testJobDocs(tt.titles...), tt.continueOnError)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := waitJob(t, m, job.ID)
+			if got.Status != tt.wantStatus || got.Progress != tt.want {
+				t.Errorf("job = %s %+v, want %s %+v", got.Status, got.Progress, tt.wantStatus, tt.want)
+			}
+			// 終了したジョブのドキュメントは削除し、状態は残す
+			if _, err := os.Stat(m.docsPath(job.ID)); !errors.Is(err, os.ErrNotExist) {
+				t.Errorf("documents of a finished job were kept: %v", err)
+			}
+			if _, err := os.Stat(m.jobPath(job.ID)); err != nil {
+				t.Errorf("job state was not saved: %v", err)
+			}
+		})
+	}
+}
+
+func TestJobManagerQueueAndCancel(t *testing.T) {
+	p := newFakePipeline(true)
+	m := newTestJobManager(t, t.TempDir(), 0, p)
+
+	running, err := m.submit(testJobDocs("block", "a"), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-p.started
+	queued, err := m.submit(testJobDocs("b"), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	next, err := m.submit(testJobDocs("c"), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// ジョブは1つずつ実行し、後のジョブは待機する
+	if job, _ := m.get(queued.ID); job.Status != jobQueued {
+		t.Errorf("second job is %s, want queued", job.Status)
+	}
+
+	// 待機中のジョブはすぐにキャンセルされる
+	if ok, err := m.cancel(queued.ID); !ok || err != nil {
+		t.Fatalf("cancel = %v, %v", ok, err)
+	}
+	if job, _ := m.get(queued.ID); job.Status != jobCanceled || job.Progress.Skipped != 1 {
+		t.Errorf("queued job after cancel = %s %+v", job.Status, job.Progress)
+	}
+
+	// 実行中のジョブは処理中のドキュメントを中断して終了する
+	m.cancel(running.ID)
+	if job := waitJob(t, m, running.ID); job.Status != jobCanceled || job.Progress.Skipped != 2 {
+		t.Errorf("running job after cancel = %s %+v", job.Status, job.Progress)
+	}
+
+	// キャンセルしたジョブを飛ばして次のジョブを実行する
+	if job := waitJob(t, m, next.ID); job.Status != jobSucceeded {
+		t.Errorf("next job = %s, want succeeded", job.Status)
+	}
+	if len(p.processed) != 1 || p.processed[0] != next.Results[0].ID {
+		t.Errorf("processed = %v, want only the last job", p.processed)
+	}
+
+	if ok, _ := m.cancel("missing"); ok {
+		t.Error("cancel of an unknown job returned true")
+	}
+}
+
+func TestJobManagerResumeAfterRestart(t *testing.T) {
+	dir := t.TempDir()
+	p := newFakePipeline(true)
+	first := newTestJobManager(t, dir, 0, p)
+	job, err := first.submit(testJobDocs("block", "a"), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-p.started
+	if err := first.shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// 終了した後はジョブを受け付けず、ドキュメントのファイルも残さない
+	if _, err := first.submit(testJobDocs("b"), false); !errors.Is(err, errManagerClosed) {
+		t.Errorf("submit after shutdown = %v, want errManagerClosed", err)
+	}
+	docs, _ := filepath.Glob(filepath.Join(dir, "*.docs.json"))
+	if len(docs) != 1 {
+		t.Errorf("document files = %v, want only the interrupted job", docs)
+	}
+
+	// 中断したジョブは次の起動時に未処理のドキュメントから再開する
+	resumed := newFakePipeline(false)
+	second := newTestJobManager(t, dir, 0, resumed)
+	got := waitJob(t, second, job.ID)
+	if got.Status != jobSucceeded || got.Progress.Succeeded != 2 {
+		t.Errorf("resumed job = %s %+v, want succeeded", got.Status, got.Progress)
+	}
+	if len(resumed.processed) != 2 {
+		t.Errorf("processed after restart = %v, want both documents", resumed.processed)
+	}
+}
+
+func TestJobManagerRetention(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name      string
+		retention time.Duration
+		finished  time.Time
+		status    string
+		wantKept  bool
+	}{
+		{name: "expired", retention: time.Hour, finished: now.Add(-2 * time.Hour), status: jobSucceeded},
+		{name: "recent", retention: time.Hour, finished: now.Add(-time.Minute), status: jobSucceeded, wantKept: true},
+		{name: "no retention", retention: 0, finished: now.Add(-48 * time.Hour), status: jobFailed, wantKept: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			job := ingestJob{ID: "20240401T090000-000000000000", Status: tt.status, FinishedAt: &tt.finished}
+			data, _ := json.Marshal(job)
+			path := filepath.Join(dir, job.ID+".json")
+			if err := os.WriteFile(path, data, 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			m := newTestJobManager(t, dir, tt.retention, newFakePipeline(false))
+			_, ok := m.get(job.ID)
+			_, err := os.Stat(path)
+			if ok != tt.wantKept || (err == nil) != tt.wantKept {
+				t.Errorf("job kept = %v, file exists = %v, want %v", ok, err == nil, tt.wantKept)
+			}
+		})
+	}
+}
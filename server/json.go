@@ -25,11 +25,17 @@ func readRequestJSON(req *http.Request, target any) error {
 
 // JSONをレスポンスとして返す
 func renderJSON(w http.ResponseWriter, v any) {
+	renderJSONStatus(w, http.StatusOK, v)
+}
+
+// JSONを指定したステータスコードのレスポンスとして返す
+func renderJSONStatus(w http.ResponseWriter, status int, v any) {
 	js, err := json.Marshal(v)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
 	w.Write(js)
 }
@@ -6,29 +6,36 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/imaikosuke/iput-tokyo-ai/server/ingest"
+	"github.com/imaikosuke/iput-tokyo-ai/server/pkg/llm"
+	"github.com/imaikosuke/iput-tokyo-ai/server/pkg/vectorstore"
 
-	"github.com/google/generative-ai-go/genai"
 	"github.com/joho/godotenv"
-	"github.com/weaviate/weaviate-go-client/v4/weaviate"
-	"google.golang.org/api/option"
 )
 
-const GENERATIVE_MODEL = "gemini-1.5-flash"
-const EMBEDDING_MODEL = "text-embedding-004"
-
 type ragServer struct {
-	ctx      context.Context        // コンテキスト
-	wvClient *weaviate.Client       // Weaviateクライアント
-	genModel *genai.GenerativeModel // GenerativeAIモデル
-	embModel *genai.EmbeddingModel  // EmbeddingAIモデル
+	cfg       *serverConfig           // サーバーの設定
+	sessions  SessionStore            // 会話履歴のストア
+	apiKeys   []apiKey                // 管理用エンドポイントのAPIキー
+	audit     *auditLogger            // 管理用エンドポイントの監査ログ
+	store     vectorstore.VectorStore // チャンクを保存するベクトルストア
+	ingest    *ingest.Pipeline        // ドキュメントの登録処理
+	jobs      *jobManager             // ドキュメントの非同期登録ジョブ
+	bootstrap *bootstrapper           // 起動時のドキュメントの自動登録
+	generator llm.Generator           // 生成モデル
+	embedder  llm.Embedder            // 埋め込みモデル
 }
 
 // CORSミドルウェアの設定
 func corsMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Access-Control-Allow-Origin", "http://localhost:3000")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, DELETE, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Accept, Authorization, X-API-Key")
 
 		if r.Method == "OPTIONS" {
 			w.WriteHeader(http.StatusOK)
@@ -39,6 +46,27 @@ func corsMiddleware(next http.Handler) http.Handler {
 	})
 }
 
+// APIエンドポイントのルーティングを設定する
+func (rs *ragServer) routes() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /health", rs.healthHandler)
+	mux.HandleFunc("GET /ready", rs.readyHandler)
+	mux.HandleFunc("POST /add/", rs.requireAPIKey(rs.addDocumentsHandler))
+	mux.HandleFunc("POST /query/", rs.queryHandler)
+	mux.HandleFunc("POST /query/stream/", rs.queryStreamHandler)
+	mux.HandleFunc("POST /search/", rs.searchHandler)
+	mux.HandleFunc("GET /documents/{$}", rs.requireAPIKey(rs.listDocumentsHandler))
+	mux.HandleFunc("GET /documents/{id...}", rs.requireAPIKey(rs.getDocumentHandler))
+	mux.HandleFunc("DELETE /documents/{id...}", rs.requireAPIKey(rs.deleteDocumentHandler))
+	mux.HandleFunc("GET /jobs/{id}", rs.requireAPIKey(rs.getJobHandler))
+	mux.HandleFunc("POST /jobs/{id}/cancel", rs.requireAPIKey(rs.cancelJobHandler))
+	mux.HandleFunc("GET /index/{$}", rs.requireAPIKey(rs.indexHandler))
+	mux.HandleFunc("POST /index/activate", rs.requireAPIKey(rs.activateIndexHandler))
+	mux.HandleFunc("POST /index/rollback", rs.requireAPIKey(rs.rollbackIndexHandler))
+	mux.HandleFunc("DELETE /index/{class}", rs.requireAPIKey(rs.dropIndexHandler))
+	return mux
+}
+
 func main() {
 	// 環境変数の読み込み
 	if err := godotenv.Load("/app/.env"); err != nil {
@@ -46,40 +74,115 @@ func main() {
 	}
 	log.Print("env: ", os.Getenv("GEMINI_API_KEY"))
 
-	// Weaviateクライアントの初期化
+	// ベクトルストアの初期化
 	ctx := context.Background()
-	wvClient, err := initWeaviate(ctx)
+	cfg := loadConfig()
+	store, err := newVectorStore(ctx, cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// 埋め込みと生成のプロバイダーの初期化
+	embedder, generator, closer, err := newProviders(ctx, cfg)
 	if err != nil {
 		log.Fatal(err)
 	}
+	defer closer.Close()
+	log.Printf("LLM provider: %s (generation: %s, embedding: %s)", cfg.LLMProvider, generator.Model(), embedder.Model())
 
-	// GenerativeAIクライアントの初期化
-	apiKey := os.Getenv("GEMINI_API_KEY")
-	genaiClient, err := genai.NewClient(ctx, option.WithAPIKey(apiKey))
+	// 管理用エンドポイントの認証設定
+	apiKeys, err := parseAPIKeys(cfg.AdminAPIKeys)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(apiKeys) == 0 {
+		log.Printf("Warning: ADMIN_API_KEYS is not set, admin endpoints will reject all requests")
+	}
+	audit, err := newAuditLogger(cfg.AuditLogPath)
 	if err != nil {
 		log.Fatal(err)
 	}
-	defer genaiClient.Close()
 
 	// サーバーの初期化
 	server := &ragServer{
-		ctx:      ctx,
-		wvClient: wvClient,
-		genModel: genaiClient.GenerativeModel(GENERATIVE_MODEL),
-		embModel: genaiClient.EmbeddingModel(EMBEDDING_MODEL),
+		cfg:       cfg,
+		sessions:  newMemorySessionStore(cfg.SessionTTL, cfg.SessionMaxTurns),
+		apiKeys:   apiKeys,
+		audit:     audit,
+		store:     store,
+		generator: generator,
+		embedder:  embedder,
 	}
 
-	// APIエンドポイントの設定
-	mux := http.NewServeMux()
-	mux.HandleFunc("POST /add/", server.addDocumentsHandler)
-	mux.HandleFunc("POST /query/", server.queryHandler)
+	// ドキュメントの登録処理の初期化
+	chunker, err := ingest.NewChunker()
+	if err != nil {
+		log.Fatal(err)
+	}
+	embedTemplate, err := ingest.ParseEmbedTemplate(cfg.EmbedTemplate)
+	if err != nil {
+		log.Fatal(err)
+	}
+	server.ingest = &ingest.Pipeline{
+		Chunker:  chunker,
+		Embedder: embedder,
+		Store:    store,
+		Batch:    server.embedBatchOptions(),
+		Template: embedTemplate,
+		RunStage: server.runStage,
+	}
+
+	// インデックスの埋め込みモデルが設定と一致するかの確認
+	if err := server.checkIndexProfile(ctx); err != nil {
+		log.Fatal(err)
+	}
+
+	// 非同期登録ジョブの初期化（未完了のジョブは再開する）
+	server.jobs, err = newJobManager(cfg.JobsDir, cfg.IngestWorkers, cfg.JobRetention, server.ingest.Upsert)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// 起動時の自動登録（リクエストの受け付けは待たずに開始する）
+	sigCtx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	server.bootstrap = newBootstrapper(cfg.BootstrapSource, server.jobs)
+	go server.bootstrap.run(sigCtx, server)
+	go server.refreshIndex(sigCtx, cfg.IndexRefreshInterval)
 
-	// CORSミドルウェアの適用
-	handler := corsMiddleware(mux)
+	// APIエンドポイントの設定とCORSミドルウェアの適用
+	handler := corsMiddleware(server.routes())
 
 	// サーバーの起動
 	port := cmp.Or(os.Getenv("SERVERPORT"), "9020")
 	address := ":" + port
+	httpServer := &http.Server{
+		Addr:              address,
+		Handler:           handler,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	// SIGINTとSIGTERMを受け取ったら、処理中のリクエストの完了を待ってから終了する
+	shutdownDone := make(chan struct{})
+	go func() {
+		defer close(shutdownDone)
+		<-sigCtx.Done()
+		log.Printf("shutting down, waiting up to %s for in-flight requests", cfg.ShutdownTimeout)
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+		defer cancel()
+		if err := httpServer.Shutdown(shutdownCtx); err != nil {
+			log.Printf("shutdown: %v", err)
+		}
+		// 実行中のジョブは中断し、次の起動時に再開する
+		if err := server.jobs.shutdown(shutdownCtx); err != nil {
+			log.Printf("stopping jobs: %v", err)
+		}
+	}()
+
 	log.Println("listening on", address)
-	log.Fatal(http.ListenAndServe(address, handler))
+	if err := httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		log.Fatal(err)
+	}
+	<-shutdownDone
+	log.Println("server stopped")
 }
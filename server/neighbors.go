@@ -0,0 +1,168 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"slices"
+	"strings"
+
+	"github.com/imaikosuke/iput-tokyo-ai/server/pkg/vectorstore"
+)
+
+// リクエストで指定できる前後のチャンク数の上限
+const maxNeighborWindow = 5
+
+// 検索でヒットしたチャンク（ドキュメント内の位置と検索順位）
+type neighborHit struct {
+	rank  int // 検索結果での順位（0始まり）
+	index int // チャンク番号
+}
+
+// 連続するチャンクをまとめたSourceと、含まれるヒットの最も高い順位
+type neighborSpan struct {
+	rank   int
+	source Source
+}
+
+// expandNeighborsは検索でヒットしたチャンクに、同じドキュメントの前後window個以内のチャンクを追加する。
+// 追加するチャンクは順位の高いヒットから、近いものから順に、トークン数の合計がbudgetを超えない範囲で選ぶ。
+// 同じドキュメントで連続するチャンクは元の順序で1つのSourceにまとめ、重複するチャンクは1回だけ含める。
+// まとめたSourceは含まれるヒットの最も高い順位の順に並べ、引用番号を振り直す
+func (rs *ragServer) expandNeighbors(ctx context.Context, sources []Source, window, budget int) ([]Source, error) {
+	if window <= 0 || len(sources) == 0 {
+		return sources, nil
+	}
+
+	// ヒットしたドキュメントのチャンクをチャンク番号で引けるようにする
+	chunks := make(map[string]map[int]vectorstore.Chunk)
+	for _, src := range sources {
+		if src.DocumentID == "" {
+			continue
+		}
+		if _, ok := chunks[src.DocumentID]; ok {
+			continue
+		}
+		doc, err := rs.store.GetDocument(ctx, src.DocumentID)
+		if err != nil {
+			return nil, err
+		}
+		byIndex := make(map[int]vectorstore.Chunk, len(doc))
+		for _, c := range doc {
+			byIndex[c.ChunkIndex] = c
+		}
+		chunks[src.DocumentID] = byIndex
+	}
+
+	// ドキュメントのチャンクを取得できたヒットのみ前後のチャンクを追加する
+	expandable := func(src Source) bool {
+		_, ok := chunks[src.DocumentID][src.ChunkIndex]
+		return ok
+	}
+
+	// ヒットしたチャンクを選び、順位の高いヒットから距離1, 2, ...のチャンクを追加する。
+	// 途中のチャンクを追加できなかった方向にはそれ以上広げない（まとめるチャンクを連続させるため）
+	selected := make(map[string]map[int]bool, len(chunks))
+	hits := make(map[string][]neighborHit, len(chunks))
+	for rank, src := range sources {
+		if !expandable(src) {
+			continue
+		}
+		if selected[src.DocumentID] == nil {
+			selected[src.DocumentID] = make(map[int]bool)
+		}
+		selected[src.DocumentID][src.ChunkIndex] = true
+		hits[src.DocumentID] = append(hits[src.DocumentID], neighborHit{rank: rank, index: src.ChunkIndex})
+	}
+	type side struct {
+		rank, index, step int
+		docID             string
+	}
+	var open []side
+	for rank, src := range sources {
+		if expandable(src) {
+			open = append(open,
+				side{rank: rank, index: src.ChunkIndex, step: -1, docID: src.DocumentID},
+				side{rank: rank, index: src.ChunkIndex, step: 1, docID: src.DocumentID})
+		}
+	}
+	remaining := budget
+	for distance := 1; distance <= window; distance++ {
+		next := open[:0]
+		for _, s := range open {
+			index := s.index + s.step*distance
+			c, ok := chunks[s.docID][index]
+			if !ok {
+				continue
+			}
+			if !selected[s.docID][index] {
+				if c.TokenCount > remaining {
+					continue
+				}
+				selected[s.docID][index] = true
+				remaining -= c.TokenCount
+			}
+			next = append(next, s)
+		}
+		open = next
+	}
+
+	// 連続するチャンクを1つのSourceにまとめる
+	var spans []neighborSpan
+	for rank, src := range sources {
+		if !expandable(src) {
+			// ドキュメントのチャンクを取得できなかったヒットはそのまま残す
+			spans = append(spans, neighborSpan{rank: rank, source: src})
+			continue
+		}
+		if hits[src.DocumentID] == nil {
+			continue // 同じドキュメントのヒットはまとめて処理済み
+		}
+		indexes := make([]int, 0, len(selected[src.DocumentID]))
+		for index := range selected[src.DocumentID] {
+			indexes = append(indexes, index)
+		}
+		slices.Sort(indexes)
+		for start := 0; start < len(indexes); {
+			end := start + 1
+			for end < len(indexes) && indexes[end] == indexes[end-1]+1 {
+				end++
+			}
+			spans = append(spans, mergeSpan(sources, hits[src.DocumentID], chunks[src.DocumentID], indexes[start:end]))
+			start = end
+		}
+		delete(hits, src.DocumentID)
+	}
+	slices.SortStableFunc(spans, func(a, b neighborSpan) int {
+		return cmp.Compare(a.rank, b.rank)
+	})
+
+	out := make([]Source, len(spans))
+	for i, s := range spans {
+		out[i] = s.source
+		out[i].Index = i + 1
+	}
+	return out, nil
+}
+
+// 連続するチャンク番号indexesのチャンクを1つのSourceにまとめる。
+// 見出しや類似度はまとめたチャンクに含まれる最も順位の高いヒットのものを使う
+func mergeSpan(sources []Source, hits []neighborHit, byIndex map[int]vectorstore.Chunk, indexes []int) neighborSpan {
+	best := -1
+	for _, h := range hits {
+		if h.index >= indexes[0] && h.index <= indexes[len(indexes)-1] && (best < 0 || h.rank < best) {
+			best = h.rank
+		}
+	}
+	merged := sources[best]
+	merged.ChunkIndex = indexes[0]
+	merged.EndChunkIndex = indexes[len(indexes)-1]
+
+	// チャンクは重ならないため、チャンク分割で段落を結合するときと同じく空行で区切って連結する
+	contents := make([]string, len(indexes))
+	for i, index := range indexes {
+		contents[i] = byIndex[index].Content
+	}
+	merged.Content = strings.Join(contents, "\n\n")
+	merged.Excerpt = excerpt(merged.Content, excerptLength)
+	return neighborSpan{rank: best, source: merged}
+}
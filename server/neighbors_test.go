@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/imaikosuke/iput-tokyo-ai/server/pkg/vectorstore"
+)
+
+// n個のチャンク（本文は "<id>-<番号>"、トークン数は10）を持つドキュメントを保存したサーバーを作成する
+func newNeighborServer(t *testing.T, docs map[string]int) *ragServer {
+	t.Helper()
+	store := vectorstore.NewMemoryStore()
+	for id, n := range docs {
+		chunks := make([]vectorstore.Chunk, n)
+		for i := range n {
+			chunks[i] = vectorstore.Chunk{
+				Title:       id,
+				Content:     fmt.Sprintf("%s-%d", id, i),
+				ChunkIndex:  i,
+				TotalChunks: n,
+				TokenCount:  10,
+			}
+		}
+		if err := store.UpsertDocument(context.Background(), id, chunks); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return &ragServer{store: store}
+}
+
+func hit(docID string, index int) Source {
+	return Source{DocumentID: docID, Title: docID, ChunkIndex: index, EndChunkIndex: index, Content: fmt.Sprintf("%s-%d", docID, index)}
+}
+
+// Sourceの範囲を "id:開始-終了" の形式にする
+func spans(sources []Source) []string {
+	out := make([]string, len(sources))
+	for i, s := range sources {
+		out[i] = fmt.Sprintf("%s:%d-%d", s.DocumentID, s.ChunkIndex, s.EndChunkIndex)
+	}
+	return out
+}
+
+func TestExpandNeighbors(t *testing.T) {
+	tests := []struct {
+		name    string
+		sources []Source
+		window  int
+		budget  int
+		want    []string
+	}{
+		{
+			name:    "disabled",
+			sources: []Source{hit("a", 2)},
+			window:  0,
+			budget:  100,
+			want:    []string{"a:2-2"},
+		},
+		{
+			name:    "window",
+			sources: []Source{hit("a", 2)},
+			window:  1,
+			budget:  100,
+			want:    []string{"a:1-3"},
+		},
+		{
+			name:    "clipped at document edges",
+			sources: []Source{hit("a", 0), hit("b", 2)},
+			window:  2,
+			budget:  100,
+			want:    []string{"a:0-2", "b:0-2"},
+		},
+		{
+			name:    "overlapping hits are merged",
+			sources: []Source{hit("a", 3), hit("b", 1), hit("a", 1)},
+			window:  1,
+			budget:  100,
+			want:    []string{"a:0-4", "b:0-2"},
+		},
+		{
+			name:    "separate spans keep the rank order",
+			sources: []Source{hit("c", 7), hit("c", 1)},
+			window:  1,
+			budget:  100,
+			want:    []string{"c:6-8", "c:0-2"},
+		},
+		{
+			name:    "budget",
+			sources: []Source{hit("a", 2), hit("b", 1)},
+			window:  2,
+			budget:  30,
+			want:    []string{"a:1-3", "b:0-1"},
+		},
+		{
+			name:    "unknown document is kept",
+			sources: []Source{hit("missing", 0), hit("a", 4)},
+			window:  1,
+			budget:  100,
+			want:    []string{"missing:0-0", "a:3-4"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rs := newNeighborServer(t, map[string]int{"a": 5, "b": 3, "c": 10})
+			got, err := rs.expandNeighbors(context.Background(), tt.sources, tt.window, tt.budget)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if fmt.Sprint(spans(got)) != fmt.Sprint(tt.want) {
+				t.Errorf("spans = %v, want %v", spans(got), tt.want)
+			}
+			if tt.window > 0 {
+				for i, s := range got {
+					if s.Index != i+1 {
+						t.Errorf("source %d has index %d", i, s.Index)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestExpandNeighborsContent(t *testing.T) {
+	rs := newNeighborServer(t, map[string]int{"a": 3})
+	got, err := rs.expandNeighbors(context.Background(), []Source{hit("a", 1)}, 1, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0].Content != "a-0\n\na-1\n\na-2" {
+		t.Fatalf("got = %+v", got)
+	}
+}
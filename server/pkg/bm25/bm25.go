@@ -0,0 +1,109 @@
+// pkg/bm25/bm25.go
+package bm25
+
+import "math"
+
+// Params はBM25のパラメータ
+type Params struct {
+	K1 float64 // 単語頻度の飽和の強さ
+	B  float64 // 文書長による正規化の強さ
+}
+
+// DefaultParams は一般的なBM25のパラメータを返す
+func DefaultParams() Params {
+	return Params{K1: 1.2, B: 0.75}
+}
+
+// Index はメモリ上の文書集合に対するBM25の索引
+type Index struct {
+	params    Params
+	termFreqs []map[string]int
+	docLens   []int
+	docFreqs  map[string]int
+	avgDocLen float64
+}
+
+// NewIndex は文書集合からIndexを作成する。文書の順序がスコアの順序になる
+func NewIndex(docs []string, params Params) *Index {
+	idx := &Index{
+		params:    params,
+		termFreqs: make([]map[string]int, len(docs)),
+		docLens:   make([]int, len(docs)),
+		docFreqs:  make(map[string]int),
+	}
+
+	total := 0
+	for i, doc := range docs {
+		tokens := Tokenize(doc)
+		tf := make(map[string]int)
+		for _, t := range tokens {
+			tf[t]++
+		}
+		for t := range tf {
+			idx.docFreqs[t]++
+		}
+		idx.termFreqs[i] = tf
+		idx.docLens[i] = len(tokens)
+		total += len(tokens)
+	}
+	if len(docs) > 0 {
+		idx.avgDocLen = float64(total) / float64(len(docs))
+	}
+
+	return idx
+}
+
+// Score はクエリに対する各文書のBM25スコアを返す
+func (idx *Index) Score(query string) []float64 {
+	scores := make([]float64, len(idx.termFreqs))
+	if idx.avgDocLen == 0 {
+		return scores
+	}
+
+	n := float64(len(idx.termFreqs))
+	seen := make(map[string]bool)
+	for _, term := range Tokenize(query) {
+		if seen[term] {
+			continue
+		}
+		seen[term] = true
+
+		df := float64(idx.docFreqs[term])
+		if df == 0 {
+			continue
+		}
+		idf := math.Log(1 + (n-df+0.5)/(df+0.5))
+
+		for i, tf := range idx.termFreqs {
+			f := float64(tf[term])
+			if f == 0 {
+				continue
+			}
+			norm := 1 - idx.params.B + idx.params.B*float64(idx.docLens[i])/idx.avgDocLen
+			scores[i] += idf * f * (idx.params.K1 + 1) / (f + idx.params.K1*norm)
+		}
+	}
+
+	return scores
+}
+
+// Normalize はスコアを最小値0、最大値1の範囲に正規化する。
+// すべてのスコアが等しい場合はすべて0を返す
+func Normalize(scores []float64) []float64 {
+	out := make([]float64, len(scores))
+	if len(scores) == 0 {
+		return out
+	}
+	lo, hi := scores[0], scores[0]
+	for _, s := range scores {
+		lo = math.Min(lo, s)
+		hi = math.Max(hi, s)
+	}
+	if hi == lo {
+		return out
+	}
+	for i, s := range scores {
+		out[i] = (s - lo) / (hi - lo)
+	}
+	return out
+}
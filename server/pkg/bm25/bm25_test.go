@@ -0,0 +1,77 @@
+package bm25
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestTokenize(t *testing.T) {
+	tests := []struct {
+		text string
+		want []string
+	}{
+		{"", nil},
+		{"Go言語", []string{"go", "言語"}},
+		{"図書館の利用", []string{"図書", "書館", "館の", "の利", "利用"}},
+		{"ＡＢＣ１２３", []string{"abc123"}},
+		{"学 生", []string{"学", "生"}},
+		{"オフィスアワー、水曜日", []string{"オフ", "フィ", "ィス", "スア", "アワ", "ワー", "水曜", "曜日"}},
+		{"version 2.0!", []string{"version", "2", "0"}},
+	}
+	for _, tt := range tests {
+		if got := Tokenize(tt.text); fmt.Sprint(got) != fmt.Sprint(tt.want) {
+			t.Errorf("Tokenize(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestIndexScore(t *testing.T) {
+	docs := []string{
+		"図書館は平日に開館しています",
+		"オフィスアワーは水曜日です",
+		"図書館の図書館による図書館のための案内",
+	}
+	idx := NewIndex(docs, DefaultParams())
+
+	scores := idx.Score("図書館")
+	if scores[1] != 0 {
+		t.Errorf("unrelated document scored %g", scores[1])
+	}
+	if !(scores[2] > scores[0] && scores[0] > 0) {
+		t.Errorf("scores = %v, want the document with more matches first", scores)
+	}
+
+	// クエリ中で重複する語は1回だけ数える
+	if twice := idx.Score("図書館 図書館"); fmt.Sprint(twice) != fmt.Sprint(scores) {
+		t.Errorf("scores for a repeated query = %v, want %v", twice, scores)
+	}
+	if none := idx.Score("存在しない"); fmt.Sprint(none) != "[0 0 0]" {
+		t.Errorf("scores for an unknown term = %v", none)
+	}
+}
+
+func TestIndexScoreEmpty(t *testing.T) {
+	if scores := NewIndex(nil, DefaultParams()).Score("図書館"); len(scores) != 0 {
+		t.Errorf("scores = %v, want empty", scores)
+	}
+	if scores := NewIndex([]string{"", "!!"}, DefaultParams()).Score("図書館"); fmt.Sprint(scores) != "[0 0]" {
+		t.Errorf("scores = %v, want zeros", scores)
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		scores []float64
+		want   []float64
+	}{
+		{nil, []float64{}},
+		{[]float64{2, 4, 3}, []float64{0, 1, 0.5}},
+		{[]float64{5, 5}, []float64{0, 0}},
+		{[]float64{-1, 1}, []float64{0, 1}},
+	}
+	for _, tt := range tests {
+		if got := Normalize(tt.scores); fmt.Sprint(got) != fmt.Sprint(tt.want) {
+			t.Errorf("Normalize(%v) = %v, want %v", tt.scores, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+// pkg/bm25/tokenizer.go
+package bm25
+
+import (
+	"strings"
+	"unicode"
+)
+
+// 文字種の分類
+type runeClass int
+
+const (
+	classOther runeClass = iota
+	classWord            // 英数字
+	classCJK             // 漢字・ひらがな・カタカナ
+)
+
+// Tokenize は日本語を含むテキストを検索用のトークンに分割する。
+// 英数字の連続は小文字化した1語として扱い、漢字・かなの連続は文字bigramに分割する。
+// 1文字だけの漢字・かなはそのまま1トークンとする
+func Tokenize(text string) []string {
+	var tokens []string
+	var run []rune
+	class := classOther
+
+	flush := func() {
+		switch class {
+		case classWord:
+			tokens = append(tokens, strings.ToLower(string(run)))
+		case classCJK:
+			tokens = append(tokens, bigrams(run)...)
+		}
+		run = run[:0]
+	}
+
+	for _, r := range text {
+		r = foldWidth(r)
+		c := classify(r)
+		if c != class {
+			flush()
+			class = c
+		}
+		if c != classOther {
+			run = append(run, r)
+		}
+	}
+	flush()
+
+	return tokens
+}
+
+// 漢字・かなの連続を文字bigramに分割する
+func bigrams(run []rune) []string {
+	if len(run) == 1 {
+		return []string{string(run)}
+	}
+	out := make([]string, 0, len(run)-1)
+	for i := 0; i+1 < len(run); i++ {
+		out = append(out, string(run[i:i+2]))
+	}
+	return out
+}
+
+func classify(r rune) runeClass {
+	switch {
+	case unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana) || r == 'ー' || r == '々':
+		return classCJK
+	case r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)):
+		return classWord
+	default:
+		return classOther
+	}
+}
+
+// 全角英数字を半角に変換する
+func foldWidth(r rune) rune {
+	if r >= '！' && r <= '～' {
+		return r - '！' + '!'
+	}
+	return r
+}
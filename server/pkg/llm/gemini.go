@@ -0,0 +1,157 @@
+// pkg/llm/gemini.go
+package llm
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/google/generative-ai-go/genai"
+	"google.golang.org/api/iterator"
+)
+
+// GeminiEmbedder はGemini APIの埋め込みモデルを使った Embedder の実装
+type GeminiEmbedder struct {
+	name  string
+	model *genai.EmbeddingModel
+}
+
+// NewGeminiEmbedder は新しいGeminiEmbedderを作成する
+func NewGeminiEmbedder(client *genai.Client, model string) *GeminiEmbedder {
+	return &GeminiEmbedder{name: model, model: client.EmbeddingModel(model)}
+}
+
+// EmbedQuery は検索クエリを埋め込む
+func (e *GeminiEmbedder) EmbedQuery(ctx context.Context, text string) ([]float32, error) {
+	rsp, err := e.model.EmbedContent(ctx, genai.Text(text))
+	if err != nil {
+		return nil, err
+	}
+	if rsp.Embedding == nil {
+		return nil, fmt.Errorf("empty embedding in response")
+	}
+	return rsp.Embedding.Values, nil
+}
+
+// EmbedDocuments は複数の文書を1回のバッチで埋め込む
+func (e *GeminiEmbedder) EmbedDocuments(ctx context.Context, texts []string) ([][]float32, error) {
+	batch := e.model.NewBatch()
+	for _, text := range texts {
+		batch.AddContent(genai.Text(text))
+	}
+	rsp, err := e.model.BatchEmbedContents(ctx, batch)
+	if err != nil {
+		return nil, err
+	}
+	if len(rsp.Embeddings) != len(texts) {
+		return nil, fmt.Errorf("got %d embeddings for %d texts", len(rsp.Embeddings), len(texts))
+	}
+
+	vectors := make([][]float32, len(rsp.Embeddings))
+	for i, emb := range rsp.Embeddings {
+		vectors[i] = emb.Values
+	}
+	return vectors, nil
+}
+
+// Model は埋め込みモデルの名前を返す
+func (e *GeminiEmbedder) Model() string {
+	return e.name
+}
+
+// GeminiGenerator はGemini APIの生成モデルを使った Generator の実装
+type GeminiGenerator struct {
+	name  string
+	model *genai.GenerativeModel
+}
+
+// NewGeminiGenerator は新しいGeminiGeneratorを作成する
+func NewGeminiGenerator(client *genai.Client, model string) *GeminiGenerator {
+	return &GeminiGenerator{name: model, model: client.GenerativeModel(model)}
+}
+
+// Generate はプロンプトに対する応答を生成する
+func (g *GeminiGenerator) Generate(ctx context.Context, prompt string) (*Generation, error) {
+	resp, err := g.model.GenerateContent(ctx, genai.Text(prompt))
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.Candidates) != 1 {
+		return nil, fmt.Errorf("got %v candidates, expected 1", len(resp.Candidates))
+	}
+
+	texts, err := candidateTexts(resp.Candidates[0])
+	if err != nil {
+		return nil, err
+	}
+	gen := &Generation{Text: strings.Join(texts, "\n")}
+	applyResponseMetadata(gen, resp)
+	return gen, nil
+}
+
+// GenerateStream は応答をストリーミングで生成する
+func (g *GeminiGenerator) GenerateStream(ctx context.Context, prompt string, onDelta func(text string) error) (*Generation, error) {
+	iter := g.model.GenerateContentStream(ctx, genai.Text(prompt))
+	gen := &Generation{}
+	var answer strings.Builder
+	for {
+		resp, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		applyResponseMetadata(gen, resp)
+		if len(resp.Candidates) == 0 {
+			continue
+		}
+		texts, err := candidateTexts(resp.Candidates[0])
+		if err != nil {
+			return nil, err
+		}
+		for _, text := range texts {
+			answer.WriteString(text)
+			if err := onDelta(text); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	gen.Text = answer.String()
+	return gen, nil
+}
+
+// Model は生成モデルの名前を返す
+func (g *GeminiGenerator) Model() string {
+	return g.name
+}
+
+// 応答の終了理由とトークン数を記録する
+func applyResponseMetadata(gen *Generation, resp *genai.GenerateContentResponse) {
+	if resp.UsageMetadata != nil {
+		gen.PromptTokens = int(resp.UsageMetadata.PromptTokenCount)
+		gen.CandidatesTokens = int(resp.UsageMetadata.CandidatesTokenCount)
+		gen.TotalTokens = int(resp.UsageMetadata.TotalTokenCount)
+	}
+	if len(resp.Candidates) > 0 && resp.Candidates[0].FinishReason != genai.FinishReasonUnspecified {
+		gen.FinishReason = resp.Candidates[0].FinishReason.String()
+	}
+}
+
+// 候補に含まれるテキストパートを取り出す
+func candidateTexts(candidate *genai.Candidate) ([]string, error) {
+	if candidate.Content == nil {
+		return nil, nil
+	}
+	var texts []string
+	for _, part := range candidate.Content.Parts {
+		pt, ok := part.(genai.Text)
+		if !ok {
+			return nil, fmt.Errorf("bad type of part: %T", part)
+		}
+		texts = append(texts, string(pt))
+	}
+	return texts, nil
+}
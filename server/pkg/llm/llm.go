@@ -0,0 +1,34 @@
+// pkg/llm/llm.go
+package llm
+
+import "context"
+
+// Embedder はテキストを埋め込みベクトルに変換するインターフェース
+type Embedder interface {
+	// EmbedQuery は検索クエリを埋め込む
+	EmbedQuery(ctx context.Context, text string) ([]float32, error)
+	// EmbedDocuments は複数の文書を埋め込み、入力と同じ順序でベクトルを返す
+	EmbedDocuments(ctx context.Context, texts []string) ([][]float32, error)
+	// Model は埋め込みモデルの名前を返す
+	Model() string
+}
+
+// Generator はプロンプトから文章を生成するインターフェース
+type Generator interface {
+	// Generate はプロンプトに対する応答を生成する
+	Generate(ctx context.Context, prompt string) (*Generation, error)
+	// GenerateStream は応答を生成しながら、テキストの差分ごとに onDelta を呼び出す。
+	// onDelta がエラーを返した場合は生成を中断してそのエラーを返す
+	GenerateStream(ctx context.Context, prompt string, onDelta func(text string) error) (*Generation, error)
+	// Model は生成モデルの名前を返す
+	Model() string
+}
+
+// Generation は生成結果とメタデータ
+type Generation struct {
+	Text             string `json:"-"`
+	FinishReason     string `json:"finishReason"`
+	PromptTokens     int    `json:"promptTokens"`
+	CandidatesTokens int    `json:"candidatesTokens"`
+	TotalTokens      int    `json:"totalTokens"`
+}
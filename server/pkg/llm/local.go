@@ -0,0 +1,142 @@
+// pkg/llm/local.go
+package llm
+
+import (
+	"context"
+	"hash/fnv"
+	"math"
+	"strings"
+
+	"github.com/imaikosuke/iput-tokyo-ai/server/pkg/bm25"
+)
+
+// LocalEmbedder が返すモデル名
+const localEmbeddingModel = "local-hash"
+
+// LocalEmbedder はネットワークを使わない決定的な Embedder の実装。
+// トークンのハッシュで次元と符号を決めて加算し、L2正規化したベクトルを返す。
+// 同じトークンを含むテキストほどコサイン類似度が高くなる
+type LocalEmbedder struct {
+	dim int
+}
+
+// NewLocalEmbedder は dim 次元のベクトルを返すLocalEmbedderを作成する
+func NewLocalEmbedder(dim int) *LocalEmbedder {
+	return &LocalEmbedder{dim: max(dim, 1)}
+}
+
+// EmbedQuery は検索クエリを埋め込む
+func (e *LocalEmbedder) EmbedQuery(ctx context.Context, text string) ([]float32, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return e.embed(text), nil
+}
+
+// EmbedDocuments は複数の文書を埋め込む
+func (e *LocalEmbedder) EmbedDocuments(ctx context.Context, texts []string) ([][]float32, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	vectors := make([][]float32, len(texts))
+	for i, text := range texts {
+		vectors[i] = e.embed(text)
+	}
+	return vectors, nil
+}
+
+// Model は埋め込みモデルの名前を返す
+func (e *LocalEmbedder) Model() string {
+	return localEmbeddingModel
+}
+
+func (e *LocalEmbedder) embed(text string) []float32 {
+	vec := make([]float64, e.dim)
+	for _, token := range bm25.Tokenize(text) {
+		h := fnv.New64a()
+		h.Write([]byte(token))
+		sum := h.Sum64()
+		sign := 1.0
+		if sum&(1<<63) != 0 {
+			sign = -1.0
+		}
+		vec[sum%uint64(e.dim)] += sign
+	}
+
+	var norm float64
+	for _, v := range vec {
+		norm += v * v
+	}
+	norm = math.Sqrt(norm)
+
+	out := make([]float32, e.dim)
+	for i, v := range vec {
+		if norm > 0 {
+			out[i] = float32(v / norm)
+		}
+	}
+	return out
+}
+
+// LocalGenerator はネットワークを使わない決定的な Generator の実装。
+// Answer が設定されている場合はそれを返し、それ以外はプロンプトの最後の空でない行をそのまま返す。
+// 質問の書き換えではプロンプトの最後が質問文なので、質問をそのまま返すことになる
+type LocalGenerator struct {
+	Answer string
+}
+
+// NewLocalGenerator は新しいLocalGeneratorを作成する
+func NewLocalGenerator(answer string) *LocalGenerator {
+	return &LocalGenerator{Answer: answer}
+}
+
+// Generate はプロンプトに対する応答を生成する
+func (g *LocalGenerator) Generate(ctx context.Context, prompt string) (*Generation, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return g.generation(prompt), nil
+}
+
+// GenerateStream は応答を数文字ずつの差分に分けて onDelta に渡す
+func (g *LocalGenerator) GenerateStream(ctx context.Context, prompt string, onDelta func(text string) error) (*Generation, error) {
+	gen := g.generation(prompt)
+	runes := []rune(gen.Text)
+	const deltaSize = 8
+	for i := 0; i < len(runes); i += deltaSize {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if err := onDelta(string(runes[i:min(i+deltaSize, len(runes))])); err != nil {
+			return nil, err
+		}
+	}
+	return gen, nil
+}
+
+// Model は生成モデルの名前を返す
+func (g *LocalGenerator) Model() string {
+	return "local-echo"
+}
+
+func (g *LocalGenerator) generation(prompt string) *Generation {
+	text := g.Answer
+	if text == "" {
+		text = lastLine(prompt)
+	}
+	promptTokens := len(bm25.Tokenize(prompt))
+	candidatesTokens := len(bm25.Tokenize(text))
+	return &Generation{
+		Text:             text,
+		FinishReason:     "STOP",
+		PromptTokens:     promptTokens,
+		CandidatesTokens: candidatesTokens,
+		TotalTokens:      promptTokens + candidatesTokens,
+	}
+}
+
+// 最後の空でない行を返す
+func lastLine(text string) string {
+	lines := strings.Split(strings.TrimSpace(text), "\n")
+	return strings.TrimSpace(lines[len(lines)-1])
+}
@@ -0,0 +1,279 @@
+// pkg/llm/openai.go
+package llm
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// OpenAIClient はOpenAI互換の /v1/embeddings と /v1/chat/completions を呼び出すクライアント。
+// Ollamaやllama.cppのサーバーも同じプロトコルに対応している
+type OpenAIClient struct {
+	baseURL    string
+	apiKey     string
+	httpClient *http.Client
+}
+
+// NewOpenAIClient は baseURL（例: "http://localhost:11434/v1"）に接続するクライアントを作成する。
+// apiKeyが空の場合はAuthorizationヘッダーを送らない。httpClientがnilの場合はhttp.DefaultClientを使う
+func NewOpenAIClient(baseURL, apiKey string, httpClient *http.Client) *OpenAIClient {
+	return &OpenAIClient{
+		baseURL:    strings.TrimSuffix(baseURL, "/"),
+		apiKey:     apiKey,
+		httpClient: cmp.Or(httpClient, http.DefaultClient),
+	}
+}
+
+// HTTPError はAPIが2xx以外のステータスを返したときのエラー
+type HTTPError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *HTTPError) Error() string {
+	return fmt.Sprintf("openai-compatible api returned %d: %s", e.StatusCode, e.Body)
+}
+
+// pathにJSONのリクエストを送信し、2xxのレスポンスを返す。呼び出し側でBodyを閉じる
+func (c *OpenAIClient) post(ctx context.Context, path string, body any) (*http.Response, error) {
+	js, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+path, bytes.NewReader(js))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if c.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+c.apiKey)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		defer resp.Body.Close()
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return nil, &HTTPError{StatusCode: resp.StatusCode, Body: strings.TrimSpace(string(msg))}
+	}
+	return resp, nil
+}
+
+// embeddingsのレスポンスの1件
+type openAIEmbedding struct {
+	Index     int       `json:"index"`
+	Embedding []float32 `json:"embedding"`
+}
+
+// OpenAIEmbedder は /v1/embeddings を使った Embedder の実装
+type OpenAIEmbedder struct {
+	client *OpenAIClient
+	model  string
+}
+
+// NewOpenAIEmbedder は新しいOpenAIEmbedderを作成する
+func NewOpenAIEmbedder(client *OpenAIClient, model string) *OpenAIEmbedder {
+	return &OpenAIEmbedder{client: client, model: model}
+}
+
+// EmbedQuery は検索クエリを埋め込む
+func (e *OpenAIEmbedder) EmbedQuery(ctx context.Context, text string) ([]float32, error) {
+	vectors, err := e.EmbedDocuments(ctx, []string{text})
+	if err != nil {
+		return nil, err
+	}
+	return vectors[0], nil
+}
+
+// EmbedDocuments は複数の文書を1回のリクエストで埋め込む
+func (e *OpenAIEmbedder) EmbedDocuments(ctx context.Context, texts []string) ([][]float32, error) {
+	if len(texts) == 0 {
+		return nil, nil
+	}
+	resp, err := e.client.post(ctx, "/embeddings", map[string]any{
+		"model": e.model,
+		"input": texts,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		Data []openAIEmbedding `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("decoding embeddings response: %w", err)
+	}
+	if len(body.Data) != len(texts) {
+		return nil, fmt.Errorf("got %d embeddings for %d inputs", len(body.Data), len(texts))
+	}
+
+	// レスポンスの順序は保証されないため、indexで並べ替える
+	slices.SortFunc(body.Data, func(a, b openAIEmbedding) int {
+		return cmp.Compare(a.Index, b.Index)
+	})
+	vectors := make([][]float32, len(body.Data))
+	for i, d := range body.Data {
+		vectors[i] = d.Embedding
+	}
+	return vectors, nil
+}
+
+// Model は埋め込みモデルの名前を返す
+func (e *OpenAIEmbedder) Model() string {
+	return e.model
+}
+
+// OpenAIGenerator は /v1/chat/completions を使った Generator の実装
+type OpenAIGenerator struct {
+	client *OpenAIClient
+	model  string
+}
+
+// NewOpenAIGenerator は新しいOpenAIGeneratorを作成する
+func NewOpenAIGenerator(client *OpenAIClient, model string) *OpenAIGenerator {
+	return &OpenAIGenerator{client: client, model: model}
+}
+
+// chat/completionsのトークン使用量
+type openAIUsage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+}
+
+// chat/completionsのレスポンス。ストリーミングではmessageの代わりにdeltaが返される。
+// ストリーミングの途中でエラーになった場合はerrorだけのイベントが返される
+type chatCompletion struct {
+	Choices []struct {
+		Message      chatMessage `json:"message"`
+		Delta        chatMessage `json:"delta"`
+		FinishReason string      `json:"finish_reason"`
+	} `json:"choices"`
+	Usage *openAIUsage `json:"usage"`
+	Error *struct {
+		Message string `json:"message"`
+		Type    string `json:"type"`
+	} `json:"error"`
+}
+
+type chatMessage struct {
+	Role    string `json:"role,omitempty"`
+	Content string `json:"content"`
+}
+
+// プロンプトを1つのユーザーメッセージとして送るリクエストを作成する
+func (g *OpenAIGenerator) request(prompt string, stream bool) map[string]any {
+	req := map[string]any{
+		"model":    g.model,
+		"messages": []chatMessage{{Role: "user", Content: prompt}},
+		"stream":   stream,
+	}
+	if stream {
+		// 最後のチャンクでトークン使用量を受け取る（対応していないサーバーは無視する）
+		req["stream_options"] = map[string]any{"include_usage": true}
+	}
+	return req
+}
+
+// Generate はプロンプトに対する応答を生成する
+func (g *OpenAIGenerator) Generate(ctx context.Context, prompt string) (*Generation, error) {
+	resp, err := g.client.post(ctx, "/chat/completions", g.request(prompt, false))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var body chatCompletion
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("decoding chat completion: %w", err)
+	}
+	if len(body.Choices) != 1 {
+		return nil, fmt.Errorf("got %v choices, expected 1", len(body.Choices))
+	}
+
+	gen := &Generation{Text: body.Choices[0].Message.Content}
+	applyCompletionMetadata(gen, &body)
+	return gen, nil
+}
+
+// GenerateStream はServer-Sent Eventsで返される応答を差分ごとに onDelta に渡す。
+// 途中でエラーのイベントが返された場合や、[DONE]と終了理由のどちらも受け取らずに接続が切れた場合はエラーを返す
+func (g *OpenAIGenerator) GenerateStream(ctx context.Context, prompt string, onDelta func(text string) error) (*Generation, error) {
+	resp, err := g.client.post(ctx, "/chat/completions", g.request(prompt, true))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	gen := &Generation{}
+	var answer strings.Builder
+	done := false
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data:")
+		if !ok {
+			continue
+		}
+		data = strings.TrimSpace(data)
+		if data == "[DONE]" {
+			done = true
+			break
+		}
+
+		var chunk chatCompletion
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			return nil, fmt.Errorf("decoding chat completion chunk: %w", err)
+		}
+		if chunk.Error != nil {
+			return nil, fmt.Errorf("chat completion stream failed: %s", chunk.Error.Message)
+		}
+		applyCompletionMetadata(gen, &chunk)
+		if len(chunk.Choices) == 0 || chunk.Choices[0].Delta.Content == "" {
+			continue
+		}
+		text := chunk.Choices[0].Delta.Content
+		answer.WriteString(text)
+		if err := onDelta(text); err != nil {
+			return nil, err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if !done && gen.FinishReason == "" {
+		return nil, fmt.Errorf("chat completion stream ended before [DONE]")
+	}
+
+	gen.Text = answer.String()
+	return gen, nil
+}
+
+// Model は生成モデルの名前を返す
+func (g *OpenAIGenerator) Model() string {
+	return g.model
+}
+
+// 応答の終了理由とトークン数を記録する。終了理由はGeminiに合わせて大文字にする
+func applyCompletionMetadata(gen *Generation, c *chatCompletion) {
+	if c.Usage != nil {
+		gen.PromptTokens = c.Usage.PromptTokens
+		gen.CandidatesTokens = c.Usage.CompletionTokens
+		gen.TotalTokens = c.Usage.TotalTokens
+	}
+	if len(c.Choices) > 0 && c.Choices[0].FinishReason != "" {
+		gen.FinishReason = strings.ToUpper(c.Choices[0].FinishReason)
+	}
+}
@@ -0,0 +1,277 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// handlerのテスト用サーバーに接続するクライアントを返す
+func newTestClient(t *testing.T, handler http.HandlerFunc) *OpenAIClient {
+	t.Helper()
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+	return NewOpenAIClient(srv.URL+"/", "test-key", srv.Client())
+}
+
+// リクエストボディをデコードする
+func decodeRequest(t *testing.T, r *http.Request) map[string]any {
+	t.Helper()
+	var body map[string]any
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		t.Errorf("decoding request: %v", err)
+	}
+	return body
+}
+
+// SSEのイベントを順に書き込む
+func writeEvents(w http.ResponseWriter, events ...string) {
+	w.Header().Set("Content-Type", "text/event-stream")
+	for _, e := range events {
+		fmt.Fprintf(w, "data: %s\n\n", e)
+	}
+}
+
+func TestOpenAIEmbedderSortsByIndex(t *testing.T) {
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/embeddings" {
+			t.Errorf("path = %s, want /embeddings", r.URL.Path)
+		}
+		if got := r.Header.Get("Authorization"); got != "Bearer test-key" {
+			t.Errorf("Authorization = %q", got)
+		}
+		body := decodeRequest(t, r)
+		if body["model"] != "embed-model" {
+			t.Errorf("model = %v", body["model"])
+		}
+		if input, _ := body["input"].([]any); len(input) != 3 {
+			t.Errorf("input = %v", body["input"])
+		}
+		// 入力と異なる順序で返す
+		fmt.Fprint(w, `{"data":[
+			{"index":2,"embedding":[2,2]},
+			{"index":0,"embedding":[0,0]},
+			{"index":1,"embedding":[1,1]}]}`)
+	})
+
+	vectors, err := NewOpenAIEmbedder(client, "embed-model").EmbedDocuments(context.Background(), []string{"a", "b", "c"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, v := range vectors {
+		if len(v) != 2 || v[0] != float32(i) {
+			t.Errorf("vectors[%d] = %v, want [%d %d]", i, v, i, i)
+		}
+	}
+}
+
+func TestOpenAIEmbedderCountMismatch(t *testing.T) {
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"data":[{"index":0,"embedding":[1]}]}`)
+	})
+
+	_, err := NewOpenAIEmbedder(client, "m").EmbedDocuments(context.Background(), []string{"a", "b"})
+	if err == nil || !strings.Contains(err.Error(), "got 1 embeddings for 2 inputs") {
+		t.Fatalf("err = %v, want count mismatch", err)
+	}
+}
+
+func TestOpenAIGenerate(t *testing.T) {
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/chat/completions" {
+			t.Errorf("path = %s, want /chat/completions", r.URL.Path)
+		}
+		body := decodeRequest(t, r)
+		if body["stream"] != false {
+			t.Errorf("stream = %v, want false", body["stream"])
+		}
+		messages, _ := body["messages"].([]any)
+		if len(messages) != 1 || messages[0].(map[string]any)["content"] != "質問" {
+			t.Errorf("messages = %v", body["messages"])
+		}
+		fmt.Fprint(w, `{"choices":[{"message":{"role":"assistant","content":"回答"},"finish_reason":"stop"}],
+			"usage":{"prompt_tokens":10,"completion_tokens":5,"total_tokens":15}}`)
+	})
+
+	gen, err := NewOpenAIGenerator(client, "chat-model").Generate(context.Background(), "質問")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Generation{Text: "回答", FinishReason: "STOP", PromptTokens: 10, CandidatesTokens: 5, TotalTokens: 15}
+	if *gen != want {
+		t.Errorf("gen = %+v, want %+v", *gen, want)
+	}
+}
+
+func TestOpenAIGenerateStream(t *testing.T) {
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		body := decodeRequest(t, r)
+		if body["stream"] != true {
+			t.Errorf("stream = %v, want true", body["stream"])
+		}
+		writeEvents(w,
+			`{"choices":[{"delta":{"content":"こん"}}]}`,
+			`{"choices":[{"delta":{"content":"にちは"}}]}`,
+			`{"choices":[{"delta":{},"finish_reason":"stop"}]}`,
+			`{"choices":[],"usage":{"prompt_tokens":3,"completion_tokens":2,"total_tokens":5}}`,
+			`[DONE]`,
+			// [DONE]の後のイベントは読まない
+			`{"choices":[{"delta":{"content":"無視"}}]}`,
+		)
+	})
+
+	var deltas []string
+	gen, err := NewOpenAIGenerator(client, "m").GenerateStream(context.Background(), "q", func(text string) error {
+		deltas = append(deltas, text)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(deltas, "|") != "こん|にちは" {
+		t.Errorf("deltas = %q", deltas)
+	}
+	want := Generation{Text: "こんにちは", FinishReason: "STOP", PromptTokens: 3, CandidatesTokens: 2, TotalTokens: 5}
+	if *gen != want {
+		t.Errorf("gen = %+v, want %+v", *gen, want)
+	}
+}
+
+func TestOpenAIGenerateStreamErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		events []string
+		want   string
+	}{
+		{
+			name:   "error event",
+			events: []string{`{"choices":[{"delta":{"content":"途中"}}]}`, `{"error":{"message":"model overloaded","type":"server_error"}}`},
+			want:   "model overloaded",
+		},
+		{
+			name:   "malformed chunk",
+			events: []string{`{"choices":[{"delta":{"content":"途中"}}]}`, `{"choices":`},
+			want:   "decoding chat completion chunk",
+		},
+		{
+			name:   "truncated",
+			events: []string{`{"choices":[{"delta":{"content":"途中"}}]}`},
+			want:   "ended before [DONE]",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+				writeEvents(w, tt.events...)
+			})
+			_, err := NewOpenAIGenerator(client, "m").GenerateStream(context.Background(), "q", func(string) error { return nil })
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("err = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestOpenAIGenerateStreamAbortsOnDeltaError(t *testing.T) {
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		writeEvents(w,
+			`{"choices":[{"delta":{"content":"a"}}]}`,
+			`{"choices":[{"delta":{"content":"b"}}]}`,
+			`[DONE]`,
+		)
+	})
+
+	errAbort := errors.New("client gone")
+	calls := 0
+	_, err := NewOpenAIGenerator(client, "m").GenerateStream(context.Background(), "q", func(string) error {
+		calls++
+		return errAbort
+	})
+	if !errors.Is(err, errAbort) {
+		t.Fatalf("err = %v, want %v", err, errAbort)
+	}
+	if calls != 1 {
+		t.Errorf("onDelta called %d times, want 1", calls)
+	}
+}
+
+func TestOpenAIHTTPErrorIsRetryable(t *testing.T) {
+	tests := []struct {
+		status    int
+		retryable bool
+	}{
+		{http.StatusBadRequest, false},
+		{http.StatusUnauthorized, false},
+		{http.StatusTooManyRequests, true},
+		{http.StatusInternalServerError, true},
+		{http.StatusServiceUnavailable, true},
+	}
+	for _, tt := range tests {
+		client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "failure", tt.status)
+		})
+		_, err := NewOpenAIGenerator(client, "m").Generate(context.Background(), "q")
+
+		var httpErr *HTTPError
+		if !errors.As(err, &httpErr) {
+			t.Fatalf("status %d: err = %v, want *HTTPError", tt.status, err)
+		}
+		if httpErr.StatusCode != tt.status || httpErr.Body != "failure" {
+			t.Errorf("status %d: HTTPError = %+v", tt.status, httpErr)
+		}
+		if got := IsRetryable(err); got != tt.retryable {
+			t.Errorf("status %d: IsRetryable = %v, want %v", tt.status, got, tt.retryable)
+		}
+	}
+}
+
+func TestEmbedBatchesRetriesOpenAIErrors(t *testing.T) {
+	var attempts atomic.Int32
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		// 最初の2回は一時的なエラーを返す
+		if attempts.Add(1) <= 2 {
+			http.Error(w, "overloaded", http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprint(w, `{"data":[{"index":0,"embedding":[1]},{"index":1,"embedding":[2]}]}`)
+	})
+	embedder := NewOpenAIEmbedder(client, "m")
+	opts := BatchOptions{Size: 2, Retry: RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond}}
+
+	vectors, err := EmbedBatches(context.Background(), []string{"a", "b"}, opts, embedder.EmbedDocuments)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(vectors) != 2 || vectors[1][0] != 2 {
+		t.Errorf("vectors = %v", vectors)
+	}
+	if got := attempts.Load(); got != 3 {
+		t.Errorf("attempts = %d, want 3", got)
+	}
+}
+
+func TestEmbedBatchesDoesNotRetryClientErrors(t *testing.T) {
+	var attempts atomic.Int32
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		attempts.Add(1)
+		http.Error(w, "bad input", http.StatusBadRequest)
+	})
+	embedder := NewOpenAIEmbedder(client, "m")
+	opts := BatchOptions{Size: 2, Retry: RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond}}
+
+	_, err := EmbedBatches(context.Background(), []string{"a"}, opts, embedder.EmbedDocuments)
+	var httpErr *HTTPError
+	if !errors.As(err, &httpErr) || httpErr.StatusCode != http.StatusBadRequest {
+		t.Fatalf("err = %v, want HTTPError 400", err)
+	}
+	if got := attempts.Load(); got != 1 {
+		t.Errorf("attempts = %d, want 1", got)
+	}
+}
@@ -0,0 +1,77 @@
+// pkg/llm/provider.go
+package llm
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/google/generative-ai-go/genai"
+	"google.golang.org/api/option"
+)
+
+// プロバイダー
+const (
+	ProviderGemini = "gemini" // Gemini API
+	ProviderOpenAI = "openai" // OpenAI互換のAPI（OpenAI, Ollama, llama.cppなど）
+	ProviderLocal  = "local"  // ネットワークを使わない決定的な実装（開発・テスト用）
+)
+
+// プロバイダーごとのデフォルトのモデル
+const (
+	DefaultGeminiGenerativeModel = "gemini-1.5-flash"
+	DefaultGeminiEmbeddingModel  = "text-embedding-004"
+	DefaultOpenAIGenerativeModel = "gpt-4o-mini"
+	DefaultOpenAIEmbeddingModel  = "text-embedding-3-small"
+)
+
+// ProviderConfig は埋め込みと生成のプロバイダーの設定
+type ProviderConfig struct {
+	Provider          string // ProviderGemini, ProviderOpenAI, ProviderLocal
+	GenerativeModel   string // 空の場合はプロバイダーのデフォルト
+	EmbeddingModel    string // 空の場合はプロバイダーのデフォルト
+	GeminiAPIKey      string
+	OpenAIBaseURL     string
+	OpenAIAPIKey      string
+	LocalEmbeddingDim int
+	LocalAnswer       string
+}
+
+// EmbeddingModelName は設定で使われる埋め込みモデルの名前を返す
+func (cfg ProviderConfig) EmbeddingModelName() string {
+	switch cfg.Provider {
+	case ProviderGemini:
+		return cmp.Or(cfg.EmbeddingModel, DefaultGeminiEmbeddingModel)
+	case ProviderOpenAI:
+		return cmp.Or(cfg.EmbeddingModel, DefaultOpenAIEmbeddingModel)
+	case ProviderLocal:
+		return localEmbeddingModel
+	default:
+		return cfg.EmbeddingModel
+	}
+}
+
+// NewProviders は設定に応じて埋め込みと生成のプロバイダーを作成する。
+// 返される io.Closer は使い終わったときに閉じる
+func NewProviders(ctx context.Context, cfg ProviderConfig) (Embedder, Generator, io.Closer, error) {
+	switch cfg.Provider {
+	case ProviderGemini:
+		client, err := genai.NewClient(ctx, option.WithAPIKey(cfg.GeminiAPIKey))
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("initializing gemini client: %w", err)
+		}
+		embedder := NewGeminiEmbedder(client, cfg.EmbeddingModelName())
+		generator := NewGeminiGenerator(client, cmp.Or(cfg.GenerativeModel, DefaultGeminiGenerativeModel))
+		return embedder, generator, client, nil
+	case ProviderOpenAI:
+		client := NewOpenAIClient(cfg.OpenAIBaseURL, cfg.OpenAIAPIKey, nil)
+		embedder := NewOpenAIEmbedder(client, cfg.EmbeddingModelName())
+		generator := NewOpenAIGenerator(client, cmp.Or(cfg.GenerativeModel, DefaultOpenAIGenerativeModel))
+		return embedder, generator, io.NopCloser(nil), nil
+	case ProviderLocal:
+		return NewLocalEmbedder(cfg.LocalEmbeddingDim), NewLocalGenerator(cfg.LocalAnswer), io.NopCloser(nil), nil
+	default:
+		return nil, nil, nil, fmt.Errorf("unknown LLM_PROVIDER %q", cfg.Provider)
+	}
+}
@@ -0,0 +1,117 @@
+// pkg/llm/retry.go
+package llm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"time"
+
+	"google.golang.org/api/googleapi"
+)
+
+// RetryPolicy は一時的なエラーを再試行する方法
+type RetryPolicy struct {
+	MaxAttempts int           // 最初の呼び出しを含む最大試行回数（1以下の場合は再試行しない）
+	BaseDelay   time.Duration // 1回目の再試行までの待ち時間。再試行ごとに2倍にする
+	MaxDelay    time.Duration // 待ち時間の上限
+}
+
+// Retry は fn を呼び出し、再試行できるエラーの場合は指数バックオフとジッターを入れて再試行する。
+// ctxがキャンセルされた場合は待たずに最後のエラーを返す
+func Retry(ctx context.Context, p RetryPolicy, fn func(ctx context.Context) error) error {
+	attempts := max(p.MaxAttempts, 1)
+	for attempt := 1; ; attempt++ {
+		err := fn(ctx)
+		if err == nil || attempt >= attempts || !IsRetryable(err) || ctx.Err() != nil {
+			return err
+		}
+
+		delay := p.backoff(attempt)
+		log.Printf("retryable error (attempt %d/%d), retrying in %s: %v", attempt, attempts, delay, err)
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+	}
+}
+
+// attempt回目の失敗後の待ち時間を返す。待ち時間の半分から全体までの間でランダムに揺らす
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	delay := p.BaseDelay << (attempt - 1)
+	if p.MaxDelay > 0 && (delay > p.MaxDelay || delay <= 0) {
+		delay = p.MaxDelay
+	}
+	if delay <= 0 {
+		return 0
+	}
+	return delay/2 + rand.N(delay/2+1)
+}
+
+// IsRetryable はエラーが再試行で解決する可能性のある一時的なものかを判定する。
+// レート制限（429）とサーバーエラー（5xx）、ネットワークエラー、タイムアウトを再試行の対象とする
+func IsRetryable(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+
+	var httpErr *HTTPError
+	if errors.As(err, &httpErr) {
+		return retryableStatus(httpErr.StatusCode)
+	}
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) {
+		return retryableStatus(apiErr.Code)
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+func retryableStatus(code int) bool {
+	return code == http.StatusTooManyRequests || code >= 500
+}
+
+// BatchOptions は複数の文書をまとめて埋め込む方法
+type BatchOptions struct {
+	Size  int         // 1回のリクエストで埋め込む文書数の上限（0以下の場合は分割しない）
+	Retry RetryPolicy // バッチごとの再試行の方法
+}
+
+// EmbedBatches は texts を opts.Size ごとのバッチに分けて embed で埋め込み、入力と同じ順序でベクトルを返す。
+// バッチごとに一時的なエラーを再試行し、返されたベクトルの数が入力と一致するかを検証する
+func EmbedBatches(ctx context.Context, texts []string, opts BatchOptions, embed func(ctx context.Context, batch []string) ([][]float32, error)) ([][]float32, error) {
+	size := opts.Size
+	if size <= 0 {
+		size = max(len(texts), 1)
+	}
+
+	vectors := make([][]float32, 0, len(texts))
+	for start := 0; start < len(texts); start += size {
+		batch := texts[start:min(start+size, len(texts))]
+		var batchVectors [][]float32
+		err := Retry(ctx, opts.Retry, func(ctx context.Context) error {
+			var err error
+			batchVectors, err = embed(ctx, batch)
+			return err
+		})
+		if err != nil {
+			return nil, fmt.Errorf("embedding batch %d-%d of %d: %w", start, start+len(batch)-1, len(texts), err)
+		}
+		if len(batchVectors) != len(batch) {
+			return nil, fmt.Errorf("embedding batch %d-%d of %d: got %d embeddings for %d texts",
+				start, start+len(batch)-1, len(texts), len(batchVectors), len(batch))
+		}
+		vectors = append(vectors, batchVectors...)
+	}
+	return vectors, nil
+}
@@ -0,0 +1,210 @@
+// pkg/vectorstore/memory.go
+package vectorstore
+
+import (
+	"cmp"
+	"context"
+	"math"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/imaikosuke/iput-tokyo-ai/server/pkg/bm25"
+)
+
+// MemoryStore はプロセス内にチャンクを保持する VectorStore の実装。
+// 検索はすべてのチャンクとのコサイン類似度を総当たりで計算する
+type MemoryStore struct {
+	mu     sync.RWMutex
+	chunks map[string][]Chunk // ドキュメントIDごとのチャンク（チャンク番号の順）
+}
+
+// NewMemoryStore は空のMemoryStoreを作成する
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{chunks: make(map[string][]Chunk)}
+}
+
+// UpsertDocument はドキュメントのチャンクを置き換える
+func (s *MemoryStore) UpsertDocument(ctx context.Context, documentID string, chunks []Chunk) error {
+	if documentID == "" {
+		return ErrEmptyDocumentID
+	}
+	stored := make([]Chunk, len(chunks))
+	for i, c := range chunks {
+		c.DocumentID = documentID
+		c.Tags = slices.Clone(c.Tags)
+		c.Headings = slices.Clone(c.Headings)
+		c.Vector = slices.Clone(c.Vector)
+		stored[i] = c
+	}
+	slices.SortFunc(stored, func(a, b Chunk) int {
+		return cmp.Compare(a.ChunkIndex, b.ChunkIndex)
+	})
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(stored) == 0 {
+		delete(s.chunks, documentID)
+		return nil
+	}
+	s.chunks[documentID] = stored
+	return nil
+}
+
+// DeleteDocument はドキュメントのすべてのチャンクを削除する
+func (s *MemoryStore) DeleteDocument(ctx context.Context, documentID string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := len(s.chunks[documentID])
+	delete(s.chunks, documentID)
+	return n, nil
+}
+
+// Search は条件に一致するチャンクを関連度の高い順に返す
+func (s *MemoryStore) Search(ctx context.Context, q SearchQuery) ([]Result, error) {
+	s.mu.RLock()
+	var results []Result
+	for _, chunks := range s.chunks {
+		for _, c := range chunks {
+			if q.Filter.Match(c) {
+				results = append(results, Result{Chunk: c, Certainty: certainty(q.Vector, c.Vector)})
+			}
+		}
+	}
+	s.mu.RUnlock()
+
+	if q.Mode == ModeHybrid {
+		// Weaviateのrelative score fusionと同様に、両方のスコアを0〜1に正規化して重み付きで合算する
+		docs := make([]string, len(results))
+		vectorScores := make([]float64, len(results))
+		for i, r := range results {
+			docs[i] = r.Title + "\n" + r.Content
+			vectorScores[i] = r.Certainty
+		}
+		keywordScores := bm25.Normalize(bm25.NewIndex(docs, bm25.DefaultParams()).Score(q.Text))
+		vectorScores = bm25.Normalize(vectorScores)
+		alpha := float64(q.Alpha)
+		for i := range results {
+			results[i].Score = alpha*vectorScores[i] + (1-alpha)*keywordScores[i]
+		}
+		slices.SortStableFunc(results, func(a, b Result) int {
+			return cmp.Compare(b.Score, a.Score)
+		})
+	} else {
+		results = slices.DeleteFunc(results, func(r Result) bool {
+			return r.Certainty < float64(q.Certainty)
+		})
+		slices.SortStableFunc(results, func(a, b Result) int {
+			return cmp.Compare(b.Certainty, a.Certainty)
+		})
+	}
+
+	if q.Limit > 0 && len(results) > q.Limit {
+		results = results[:q.Limit]
+	}
+	return results, nil
+}
+
+// ListDocuments は保存されたドキュメントの一覧をIDの順で返す
+func (s *MemoryStore) ListDocuments(ctx context.Context) ([]DocumentInfo, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	docs := make([]DocumentInfo, 0, len(s.chunks))
+	for id, chunks := range s.chunks {
+		first := chunks[0]
+		docs = append(docs, DocumentInfo{
+			ID:          id,
+			Title:       first.Title,
+			Category:    first.Category,
+			Department:  first.Department,
+			UpdatedAt:   first.UpdatedAt,
+			ContentHash: first.ContentHash,
+			Chunks:      len(chunks),
+
+			EmbeddingModel: first.EmbeddingModel,
+			ChunkConfig:    first.ChunkConfig,
+		})
+	}
+	slices.SortFunc(docs, func(a, b DocumentInfo) int {
+		return cmp.Compare(a.ID, b.ID)
+	})
+	return docs, nil
+}
+
+// Profile は埋め込みモデルとチャンク分割の設定ごとのチャンク数を返す
+func (s *MemoryStore) Profile(ctx context.Context) (IndexProfile, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	profile := IndexProfile{
+		Models:       make(map[string]int),
+		Dimensions:   make(map[int]int),
+		ChunkConfigs: make(map[string]int),
+	}
+	for _, chunks := range s.chunks {
+		for _, c := range chunks {
+			profile.Objects++
+			profile.Models[c.EmbeddingModel]++
+			profile.Dimensions[c.EmbeddingDim]++
+			profile.ChunkConfigs[c.ChunkConfig]++
+		}
+	}
+	return profile, nil
+}
+
+// GetDocument はドキュメントのチャンクをチャンク番号の順に返す
+func (s *MemoryStore) GetDocument(ctx context.Context, documentID string) ([]Chunk, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return slices.Clone(s.chunks[documentID]), nil
+}
+
+// Match はチャンクが条件に一致するかを判定する。nilの条件はすべてのチャンクに一致する
+func (f *Filter) Match(c Chunk) bool {
+	if f == nil {
+		return true
+	}
+	if len(f.Categories) > 0 && !slices.Contains(f.Categories, c.Category) {
+		return false
+	}
+	if len(f.Departments) > 0 && !slices.Contains(f.Departments, c.Department) {
+		return false
+	}
+	if len(f.Tags) > 0 && !slices.ContainsFunc(f.Tags, func(t string) bool {
+		return slices.Contains(c.Tags, t)
+	}) {
+		return false
+	}
+	// updatedAtはYYYY-MM-DD形式なので、文字列の大小比較で日付を比較できる
+	if f.UpdatedAfter != "" && c.UpdatedAt < f.UpdatedAfter {
+		return false
+	}
+	if f.UpdatedBefore != "" && c.UpdatedAt > f.UpdatedBefore {
+		return false
+	}
+	if len(f.Sections) > 0 && !slices.ContainsFunc(f.Sections, func(s string) bool {
+		return c.SectionPath == s || strings.HasPrefix(c.SectionPath, s+SectionSeparator)
+	}) {
+		return false
+	}
+	return true
+}
+
+// Weaviateと同じく、コサイン類似度を0〜1の範囲に変換した類似度を返す
+func certainty(a, b []float32) float64 {
+	if len(a) != len(b) || len(a) == 0 {
+		return 0
+	}
+	var dot, na, nb float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		na += float64(a[i]) * float64(a[i])
+		nb += float64(b[i]) * float64(b[i])
+	}
+	if na == 0 || nb == 0 {
+		return 0
+	}
+	cos := dot / (math.Sqrt(na) * math.Sqrt(nb))
+	return (1 + cos) / 2
+}
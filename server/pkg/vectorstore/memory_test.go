@@ -0,0 +1,174 @@
+package vectorstore
+
+import (
+	"context"
+	"fmt"
+	"testing"
+)
+
+func TestFilterMatch(t *testing.T) {
+	chunk := Chunk{
+		Category:    "授業",
+		Department:  "情報学部",
+		Tags:        []string{"教員", "時間割"},
+		UpdatedAt:   "2024-04-01",
+		SectionPath: "授業時間等 › オフィスアワー",
+	}
+	tests := []struct {
+		name   string
+		filter *Filter
+		want   bool
+	}{
+		{"nil", nil, true},
+		{"empty", &Filter{}, true},
+		{"category", &Filter{Categories: []string{"施設", "授業"}}, true},
+		{"other category", &Filter{Categories: []string{"施設"}}, false},
+		{"department", &Filter{Departments: []string{"情報学部"}}, true},
+		{"other department", &Filter{Departments: []string{"事務局"}}, false},
+		{"any tag", &Filter{Tags: []string{"図書館", "教員"}}, true},
+		{"no tag", &Filter{Tags: []string{"図書館"}}, false},
+		{"updated after same day", &Filter{UpdatedAfter: "2024-04-01"}, true},
+		{"updated after later", &Filter{UpdatedAfter: "2024-04-02"}, false},
+		{"updated before same day", &Filter{UpdatedBefore: "2024-04-01"}, true},
+		{"updated before earlier", &Filter{UpdatedBefore: "2024-03-31"}, false},
+		{"section exact", &Filter{Sections: []string{"授業時間等 › オフィスアワー"}}, true},
+		{"section parent", &Filter{Sections: []string{"授業時間等"}}, true},
+		{"section name prefix", &Filter{Sections: []string{"授業"}}, false},
+		{"section child", &Filter{Sections: []string{"授業時間等 › オフィスアワー › 前期"}}, false},
+		{"all conditions", &Filter{Categories: []string{"授業"}, Tags: []string{"教員"}, UpdatedAfter: "2024-01-01"}, true},
+		{"one condition fails", &Filter{Categories: []string{"授業"}, Departments: []string{"事務局"}}, false},
+	}
+	for _, tt := range tests {
+		if got := tt.filter.Match(chunk); got != tt.want {
+			t.Errorf("%s: Match = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+// 3つのドキュメントを保存したMemoryStoreを作成する
+func newTestMemoryStore(t *testing.T) *MemoryStore {
+	t.Helper()
+	s := NewMemoryStore()
+	docs := []struct {
+		id       string
+		category string
+		content  string
+		vector   []float32
+	}{
+		{"a", "授業", "オフィスアワーは水曜日", []float32{1, 0}},
+		{"b", "施設", "図書館の開館時間", []float32{0.8, 0.6}},
+		{"c", "施設", "食堂の営業時間", []float32{0, 1}},
+	}
+	for _, d := range docs {
+		err := s.UpsertDocument(context.Background(), d.id, []Chunk{{Category: d.category, Content: d.content, Vector: d.vector}})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return s
+}
+
+func resultIDs(results []Result) string {
+	ids := make([]string, len(results))
+	for i, r := range results {
+		ids[i] = r.DocumentID
+	}
+	return fmt.Sprint(ids)
+}
+
+func TestMemoryStoreVectorSearch(t *testing.T) {
+	s := newTestMemoryStore(t)
+	ctx := context.Background()
+
+	results, err := s.Search(ctx, SearchQuery{Mode: ModeVector, Vector: []float32{1, 0}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := resultIDs(results); got != "[a b c]" {
+		t.Errorf("results = %s, want [a b c]", got)
+	}
+	if results[0].Certainty != 1 || results[2].Certainty != 0.5 {
+		t.Errorf("certainties = %g, %g, want 1 and 0.5", results[0].Certainty, results[2].Certainty)
+	}
+
+	results, _ = s.Search(ctx, SearchQuery{Mode: ModeVector, Vector: []float32{1, 0}, Certainty: 0.8})
+	if got := resultIDs(results); got != "[a b]" {
+		t.Errorf("results above certainty = %s, want [a b]", got)
+	}
+	results, _ = s.Search(ctx, SearchQuery{Mode: ModeVector, Vector: []float32{1, 0}, Limit: 1})
+	if got := resultIDs(results); got != "[a]" {
+		t.Errorf("limited results = %s, want [a]", got)
+	}
+	results, _ = s.Search(ctx, SearchQuery{Mode: ModeVector, Vector: []float32{1, 0}, Filter: &Filter{Categories: []string{"施設"}}})
+	if got := resultIDs(results); got != "[b c]" {
+		t.Errorf("filtered results = %s, want [b c]", got)
+	}
+}
+
+func TestMemoryStoreHybridSearch(t *testing.T) {
+	s := newTestMemoryStore(t)
+
+	// BM25のみの場合は、ベクトルが遠くてもキーワードに一致するチャンクが先頭になる
+	results, err := s.Search(context.Background(), SearchQuery{Mode: ModeHybrid, Vector: []float32{1, 0}, Text: "食堂", Alpha: 0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if results[0].DocumentID != "c" || results[0].Score != 1 {
+		t.Errorf("top result = %s (score %g), want c", results[0].DocumentID, results[0].Score)
+	}
+
+	// ベクトルのみの場合はベクトル検索と同じ順序になる
+	results, _ = s.Search(context.Background(), SearchQuery{Mode: ModeHybrid, Vector: []float32{1, 0}, Text: "食堂", Alpha: 1})
+	if got := resultIDs(results); got != "[a b c]" {
+		t.Errorf("results = %s, want [a b c]", got)
+	}
+}
+
+func TestMemoryStoreDocuments(t *testing.T) {
+	s := NewMemoryStore()
+	ctx := context.Background()
+
+	chunks := []Chunk{
+		{ChunkIndex: 1, Title: "T", Content: "second", EmbeddingModel: "m", EmbeddingDim: 2, ChunkConfig: "h"},
+		{ChunkIndex: 0, Title: "T", Content: "first", EmbeddingModel: "m", EmbeddingDim: 2, ChunkConfig: "h"},
+	}
+	if err := s.UpsertDocument(ctx, "doc", chunks); err != nil {
+		t.Fatal(err)
+	}
+
+	got, _ := s.GetDocument(ctx, "doc")
+	if len(got) != 2 || got[0].Content != "first" || got[0].DocumentID != "doc" {
+		t.Errorf("GetDocument = %+v, want chunks in index order", got)
+	}
+
+	docs, _ := s.ListDocuments(ctx)
+	if len(docs) != 1 || docs[0].ID != "doc" || docs[0].Chunks != 2 || docs[0].EmbeddingModel != "m" {
+		t.Errorf("ListDocuments = %+v", docs)
+	}
+
+	profile, _ := s.Profile(ctx)
+	if profile.Objects != 2 || profile.Models["m"] != 2 || profile.Dimensions[2] != 2 || profile.ChunkConfigs["h"] != 2 {
+		t.Errorf("Profile = %+v", profile)
+	}
+
+	// チャンクのない版で置き換えるとドキュメントが削除される
+	s.UpsertDocument(ctx, "doc", nil)
+	if n, _ := s.DeleteDocument(ctx, "doc"); n != 0 {
+		t.Errorf("DeleteDocument after empty upsert = %d, want 0", n)
+	}
+
+	s.UpsertDocument(ctx, "doc", chunks)
+	if n, _ := s.DeleteDocument(ctx, "doc"); n != 2 {
+		t.Errorf("DeleteDocument = %d, want 2", n)
+	}
+	if got, _ := s.GetDocument(ctx, "doc"); len(got) != 0 {
+		t.Errorf("GetDocument after delete = %+v", got)
+	}
+}
+
+func TestMemoryStoreRejectsEmptyDocumentID(t *testing.T) {
+	s := NewMemoryStore()
+	if err := s.UpsertDocument(context.Background(), "", []Chunk{{Content: "c"}}); err != ErrEmptyDocumentID {
+		t.Errorf("UpsertDocument = %v, want ErrEmptyDocumentID", err)
+	}
+}
@@ -0,0 +1,637 @@
+// pkg/vectorstore/schema.go
+package vectorstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"slices"
+	"strings"
+
+	"github.com/go-openapi/strfmt"
+	"github.com/google/uuid"
+	"github.com/weaviate/weaviate-go-client/v4/weaviate"
+	"github.com/weaviate/weaviate-go-client/v4/weaviate/fault"
+	"github.com/weaviate/weaviate/entities/models"
+)
+
+const (
+	metaClassName = "SchemaMeta" // スキーマの版とチャンクを保存しているクラス名を記録するクラス
+	reindexBatch  = 100          // 作り直したクラスへコピーする1回あたりのオブジェクト数
+)
+
+// スキーマの版を記録するオブジェクトのUUID
+var metaNamespace = uuid.MustParse("0b8f6c7e-2f55-4c4e-8d0a-6a1c3e9b7d21")
+
+// migrationはDocumentクラスのスキーマの変更。versionの順に適用する
+type migration struct {
+	version     int
+	description string
+	// 既存のクラスに追加するプロパティ（定義はdocumentClassのもの）。
+	// 同じ名前のプロパティが異なる型やトークナイズ方法で存在する場合は、新しいクラスを作成して全オブジェクトをコピーする
+	properties []string
+	// trueの場合はプロパティに関わらず新しいクラスを作成して全オブジェクトをコピーする
+	reindex bool
+	// スキーマの変更後にクラスのオブジェクトに適用する処理（nilの場合は何もしない）
+	cleanup func(ctx context.Context, m *Migrator, class string) error
+}
+
+// スキーマのマイグレーション。新しいマイグレーションは末尾に追加し、documentClassも同じように変更する。
+// 版の記録がない既存のクラスは版0として、すべてのマイグレーションを適用する
+var migrations = []migration{
+	{
+		version:     1,
+		description: "add documentId, headings and contentHash",
+		properties:  []string{"documentId", "headings", "contentHash"},
+		// documentIdより前のチャンクはランダムなUUIDで保存されていて、再登録しても置き換えられずに重複するため削除する
+		cleanup: purgeLegacyChunks,
+	},
+	{
+		version:     2,
+		description: "add precedence as int (auto-schema created it as number)",
+		properties:  []string{"precedence"},
+	},
+	{
+		version:     3,
+		description: "add embeddingModel, embeddingDim, chunkConfig and ingestedAt",
+		properties:  []string{"embeddingModel", "embeddingDim", "chunkConfig", "ingestedAt"},
+	},
+	{
+		version:     4,
+		description: "add sectionPath",
+		properties:  []string{"sectionPath"},
+	},
+	{
+		// 以前のクラスはtitleとcontentをwordでトークナイズしていた。WV_TOKENIZATIONと同じ場合はコピーしない
+		version:     5,
+		description: "reindex title and content with WV_TOKENIZATION",
+		properties:  []string{"title", "content"},
+	},
+}
+
+// LatestSchemaVersion は最新のスキーマの版を返す
+func LatestSchemaVersion() int {
+	return migrations[len(migrations)-1].version
+}
+
+// 最新の版のDocumentクラスの定義
+func documentClass(name, tokenization string) *models.Class {
+	return &models.Class{
+		Class:      name,
+		Vectorizer: "none",
+		Properties: []*models.Property{
+			{
+				Name:         "documentId",
+				DataType:     []string{"text"},
+				Tokenization: models.PropertyTokenizationField,
+			},
+			{
+				Name:         "title",
+				DataType:     []string{"text"},
+				Tokenization: tokenization,
+			},
+			{
+				Name:         "content",
+				DataType:     []string{"text"},
+				Tokenization: tokenization,
+			},
+			{
+				Name:     "category",
+				DataType: []string{"string"},
+			},
+			{
+				Name:     "tags",
+				DataType: []string{"string[]"},
+			},
+			{
+				Name:     "department",
+				DataType: []string{"string"},
+			},
+			{
+				Name:     "updatedAt",
+				DataType: []string{"string"},
+			},
+			// チャンク関連の新しいプロパティ
+			{
+				Name:     "chunkIndex",
+				DataType: []string{"int"},
+			},
+			{
+				Name:     "totalChunks",
+				DataType: []string{"int"},
+			},
+			{
+				Name:     "startChar",
+				DataType: []string{"int"},
+			},
+			{
+				Name:     "endChar",
+				DataType: []string{"int"},
+			},
+			{
+				Name:     "tokenCount",
+				DataType: []string{"int"},
+			},
+			{
+				Name:     "precedence",
+				DataType: []string{"int"},
+			},
+			{
+				Name:     "headings",
+				DataType: []string{"text[]"},
+			},
+			{
+				Name:         "sectionPath",
+				DataType:     []string{"text"},
+				Tokenization: models.PropertyTokenizationField,
+			},
+			{
+				Name:         "contentHash",
+				DataType:     []string{"text"},
+				Tokenization: models.PropertyTokenizationField,
+			},
+			// 登録時の埋め込みとチャンク分割の設定
+			{
+				Name:         "embeddingModel",
+				DataType:     []string{"text"},
+				Tokenization: models.PropertyTokenizationField,
+			},
+			{
+				Name:     "embeddingDim",
+				DataType: []string{"int"},
+			},
+			{
+				Name:         "chunkConfig",
+				DataType:     []string{"text"},
+				Tokenization: models.PropertyTokenizationField,
+			},
+			{
+				Name:         "ingestedAt",
+				DataType:     []string{"text"},
+				Tokenization: models.PropertyTokenizationField,
+			},
+		},
+	}
+}
+
+// スキーマの版を記録するクラスの定義
+func metaClass() *models.Class {
+	return &models.Class{
+		Class:      metaClassName,
+		Vectorizer: "none",
+		Properties: []*models.Property{
+			{Name: "index", DataType: []string{"text"}, Tokenization: models.PropertyTokenizationField},
+			{Name: "version", DataType: []string{"int"}},
+			{Name: "className", DataType: []string{"text"}, Tokenization: models.PropertyTokenizationField},
+			{Name: "previousVersion", DataType: []string{"int"}},
+			{Name: "previousClass", DataType: []string{"text"}, Tokenization: models.PropertyTokenizationField},
+		},
+	}
+}
+
+// SchemaStatus はスキーマの版と、コードのスキーマとWeaviateのスキーマとの差分
+type SchemaStatus struct {
+	Class   string   // チャンクを保存しているクラス名
+	Exists  bool     // クラスが存在するか
+	Version int      // 記録されている版（記録がない既存のクラスは0）
+	Latest  int      // コードの最新の版
+	Pending []string // 未適用のマイグレーション
+	Drift   []string // コードのスキーマとの差分
+}
+
+// UpToDate はマイグレーションが適用済みで、スキーマに差分がないかを返す
+func (s SchemaStatus) UpToDate() bool {
+	return s.Exists && len(s.Pending) == 0 && len(s.Drift) == 0
+}
+
+// Migrator はDocumentクラスのスキーマの版を管理し、マイグレーションを適用する
+type Migrator struct {
+	client       *weaviate.Client
+	tokenization string
+}
+
+// NewMigrator はWeaviateに接続し、マイグレーションを適用するMigratorを作成する
+func NewMigrator(ctx context.Context, cfg WeaviateConfig) (*Migrator, error) {
+	client, err := connectWeaviate(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{client: client, tokenization: cfg.Tokenization}, nil
+}
+
+// Status は記録されているスキーマの版と、未適用のマイグレーション、スキーマの差分を返す。スキーマは変更しない
+func (m *Migrator) Status(ctx context.Context) (SchemaStatus, error) {
+	meta, err := m.readMeta(ctx)
+	if err != nil {
+		return SchemaStatus{}, err
+	}
+	status := SchemaStatus{Class: meta.className, Version: meta.version, Latest: LatestSchemaVersion()}
+	for _, mig := range migrations {
+		if mig.version > meta.version {
+			status.Pending = append(status.Pending, fmt.Sprintf("%d: %s", mig.version, mig.description))
+		}
+	}
+
+	live, err := m.getClass(ctx, meta.className)
+	if err != nil {
+		return SchemaStatus{}, err
+	}
+	if live == nil {
+		status.Drift = append(status.Drift, fmt.Sprintf("class %s does not exist", meta.className))
+		return status, nil
+	}
+	status.Exists = true
+	status.Drift = schemaDrift(documentClass(meta.className, m.tokenization), live)
+	return status, nil
+}
+
+// Migrate は未適用のマイグレーションを順に適用し、チャンクを保存しているクラス名を返す。
+// クラスが存在しない場合は最新の版のクラスを作成する
+func (m *Migrator) Migrate(ctx context.Context) (string, error) {
+	if err := m.ensureMetaClass(ctx); err != nil {
+		return "", err
+	}
+	meta, err := m.readMeta(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	live, err := m.getClass(ctx, meta.className)
+	if err != nil {
+		return "", err
+	}
+	if live == nil {
+		cls := documentClass(meta.className, m.tokenization)
+		if err := m.client.Schema().ClassCreator().WithClass(cls).Do(ctx); err != nil {
+			return "", fmt.Errorf("creating weaviate class: %w", err)
+		}
+		meta.version = LatestSchemaVersion()
+		if err := m.writeMeta(ctx, meta); err != nil {
+			return "", err
+		}
+		log.Printf("created weaviate class %s (schema version %d)", meta.className, meta.version)
+		return meta.className, nil
+	}
+
+	for _, mig := range migrations {
+		if mig.version <= meta.version {
+			continue
+		}
+		log.Printf("applying schema migration %d to %s: %s", mig.version, meta.className, mig.description)
+		if err := m.apply(ctx, mig, &meta); err != nil {
+			return "", fmt.Errorf("schema migration %d: %w", mig.version, err)
+		}
+		if mig.cleanup != nil {
+			if err := mig.cleanup(ctx, m, meta.className); err != nil {
+				return "", fmt.Errorf("schema migration %d: %w", mig.version, err)
+			}
+		}
+		meta.version = mig.version
+		if err := m.writeMeta(ctx, meta); err != nil {
+			return "", err
+		}
+	}
+	return meta.className, nil
+}
+
+// 使用中のクラスにマイグレーションを適用する。クラスを作り直した場合はmetaのクラス名を更新する
+func (m *Migrator) apply(ctx context.Context, mig migration, meta *schemaMeta) error {
+	class := meta.className
+	live, err := m.getClass(ctx, class)
+	if err != nil {
+		return err
+	}
+	if live == nil {
+		return fmt.Errorf("class %s does not exist", class)
+	}
+	want := documentClass(class, m.tokenization)
+
+	reindex := mig.reindex
+	var missing []*models.Property
+	for _, name := range mig.properties {
+		prop := findProperty(want.Properties, name)
+		if prop == nil {
+			return fmt.Errorf("property %q is not in the document class", name)
+		}
+		existing := findProperty(live.Properties, name)
+		switch {
+		case existing == nil:
+			missing = append(missing, prop)
+		case !sameDataType(prop, existing):
+			log.Printf("property %q of %s is %v, expected %v", name, class, existing.DataType, prop.DataType)
+			reindex = true
+		case !sameTokenization(prop, existing):
+			log.Printf("property %q of %s uses %s tokenization, expected %s", name, class, existing.Tokenization, prop.Tokenization)
+			reindex = true
+		}
+	}
+	if reindex {
+		return m.reindex(ctx, meta, mig.version)
+	}
+
+	for _, prop := range missing {
+		log.Printf("adding property %q to weaviate class %s", prop.Name, class)
+		err := m.client.Schema().PropertyCreator().WithClassName(class).WithProperty(prop).Do(ctx)
+		if err != nil {
+			return fmt.Errorf("adding property %q: %w", prop.Name, err)
+		}
+	}
+	return nil
+}
+
+// 最新の版のスキーマで新しい版のクラスを作成し、全オブジェクトをベクトルごとコピーしてから古いクラスを削除する。
+// コピーの途中で中断した場合は、記録が古いクラスのままなので次の実行で別の版のクラスに作り直す
+func (m *Migrator) reindex(ctx context.Context, meta *schemaMeta, version int) error {
+	from := meta.className
+	cls, err := m.CreateVersion(ctx)
+	if err != nil {
+		return err
+	}
+	copied, err := m.copyObjects(ctx, from, cls)
+	if err != nil {
+		return fmt.Errorf("copying %s to %s: %w", from, cls.Class, err)
+	}
+	log.Printf("reindexed %d objects from %s to %s", copied, from, cls.Class)
+
+	// 新しいクラスを記録してから古いクラスを削除する
+	meta.className = cls.Class
+	meta.version = version
+	if err := m.writeMeta(ctx, *meta); err != nil {
+		return err
+	}
+	if err := m.client.Schema().ClassDeleter().WithClassName(from).Do(ctx); err != nil {
+		log.Printf("Warning: deleting old weaviate class %s: %v", from, err)
+	}
+	return nil
+}
+
+// fromの全オブジェクトをclsのスキーマに合わせて変換し、同じUUIDとベクトルでコピーする
+func (m *Migrator) copyObjects(ctx context.Context, from string, cls *models.Class) (int, error) {
+	copied := 0
+	after := ""
+	for {
+		getter := m.client.Data().ObjectsGetter().
+			WithClassName(from).
+			WithVector().
+			WithLimit(reindexBatch)
+		if after != "" {
+			getter = getter.WithAfter(after)
+		}
+		objects, err := getter.Do(ctx)
+		if err != nil {
+			return copied, err
+		}
+		if len(objects) == 0 {
+			return copied, nil
+		}
+
+		batch := make([]*models.Object, len(objects))
+		for i, obj := range objects {
+			batch[i] = &models.Object{
+				Class:      cls.Class,
+				ID:         obj.ID,
+				Properties: convertProperties(obj.Properties, cls),
+				Vector:     obj.Vector,
+			}
+		}
+		resp, err := m.client.Batch().ObjectsBatcher().WithObjects(batch...).Do(ctx)
+		if err != nil {
+			return copied, err
+		}
+		if err := objectResultErrors(resp); err != nil {
+			return copied, err
+		}
+		copied += len(objects)
+		after = string(objects[len(objects)-1].ID)
+	}
+}
+
+// documentIdのないチャンクを削除する。削除したチャンクは起動時の自動登録や make ingest で登録し直される
+func purgeLegacyChunks(ctx context.Context, m *Migrator, class string) error {
+	var legacy []strfmt.UUID
+	after := ""
+	for {
+		getter := m.client.Data().ObjectsGetter().
+			WithClassName(class).
+			WithLimit(reindexBatch)
+		if after != "" {
+			getter = getter.WithAfter(after)
+		}
+		objects, err := getter.Do(ctx)
+		if err != nil {
+			return fmt.Errorf("scanning %s: %w", class, err)
+		}
+		if len(objects) == 0 {
+			break
+		}
+		legacy = append(legacy, legacyChunkIDs(objects)...)
+		after = string(objects[len(objects)-1].ID)
+	}
+
+	for _, id := range legacy {
+		err := m.client.Data().Deleter().WithClassName(class).WithID(string(id)).Do(ctx)
+		if err != nil {
+			return fmt.Errorf("deleting chunk %s without documentId: %w", id, err)
+		}
+	}
+	if len(legacy) > 0 {
+		log.Printf("deleted %d chunks without documentId from %s; re-ingest the documents to restore them", len(legacy), class)
+	}
+	return nil
+}
+
+// documentIdが記録されていないオブジェクトのUUIDを返す
+func legacyChunkIDs(objects []*models.Object) []strfmt.UUID {
+	var ids []strfmt.UUID
+	for _, obj := range objects {
+		props, _ := obj.Properties.(map[string]any)
+		if id, _ := props["documentId"].(string); id == "" {
+			ids = append(ids, obj.ID)
+		}
+	}
+	return ids
+}
+
+// プロパティをクラスの定義の型に変換する。定義にないプロパティは除く
+func convertProperties(properties any, cls *models.Class) map[string]any {
+	props, _ := properties.(map[string]any)
+	out := make(map[string]any, len(props))
+	for name, value := range props {
+		prop := findProperty(cls.Properties, name)
+		if prop == nil {
+			continue
+		}
+		// 自動スキーマでnumber型として作成されたプロパティの値はfloat64でデコードされる
+		if f, ok := value.(float64); ok && slices.Equal(prop.DataType, []string{"int"}) {
+			value = int64(math.Round(f))
+		}
+		out[name] = value
+	}
+	return out
+}
+
+// コードのスキーマと比べたWeaviateのスキーマの差分を返す
+func schemaDrift(want, live *models.Class) []string {
+	var drift []string
+	for _, prop := range want.Properties {
+		existing := findProperty(live.Properties, prop.Name)
+		switch {
+		case existing == nil:
+			drift = append(drift, fmt.Sprintf("property %q is missing", prop.Name))
+		case !sameDataType(prop, existing):
+			drift = append(drift, fmt.Sprintf("property %q is %v, expected %v (requires reindex)", prop.Name, existing.DataType, prop.DataType))
+		case !sameTokenization(prop, existing):
+			drift = append(drift, fmt.Sprintf("property %q uses %s tokenization, expected %s (requires reindex)", prop.Name, existing.Tokenization, prop.Tokenization))
+		}
+	}
+	for _, prop := range live.Properties {
+		if findProperty(want.Properties, prop.Name) == nil {
+			drift = append(drift, fmt.Sprintf("property %q is not defined in code", prop.Name))
+		}
+	}
+	return drift
+}
+
+// データ型が同じかを返す。非推奨のstring型はWeaviateではtext型として作成される
+func sameDataType(want, live *models.Property) bool {
+	normalize := func(dataType []string) []string {
+		out := make([]string, len(dataType))
+		for i, t := range dataType {
+			out[i] = strings.Replace(t, "string", "text", 1)
+		}
+		return out
+	}
+	return slices.Equal(normalize(want.DataType), normalize(live.DataType))
+}
+
+// トークナイズ方法が同じかを返す。コードで指定していないプロパティはWeaviateのデフォルトのままとする
+func sameTokenization(want, live *models.Property) bool {
+	return want.Tokenization == "" || want.Tokenization == live.Tokenization
+}
+
+func findProperty(props []*models.Property, name string) *models.Property {
+	for _, prop := range props {
+		if prop.Name == name {
+			return prop
+		}
+	}
+	return nil
+}
+
+// クラスの定義を返す。クラスが存在しない場合はnilを返す
+func (m *Migrator) getClass(ctx context.Context, name string) (*models.Class, error) {
+	exists, err := m.client.Schema().ClassExistenceChecker().WithClassName(name).Do(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("checking weaviate class %s: %w", name, err)
+	}
+	if !exists {
+		return nil, nil
+	}
+	cls, err := m.client.Schema().ClassGetter().WithClassName(name).Do(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("reading weaviate class %s: %w", name, err)
+	}
+	return cls, nil
+}
+
+// schemaMetaは検索に使うクラス（エイリアス）とスキーマの版。
+// 切り替える前のクラスを切り戻しのために記録する
+type schemaMeta struct {
+	version         int
+	className       string
+	previousVersion int
+	previousClass   string
+}
+
+func (m *Migrator) ensureMetaClass(ctx context.Context) error {
+	want := metaClass()
+	cls, err := m.getClass(ctx, metaClassName)
+	if err != nil {
+		return err
+	}
+	if cls == nil {
+		if err := m.client.Schema().ClassCreator().WithClass(want).Do(ctx); err != nil {
+			return fmt.Errorf("creating weaviate class %s: %w", metaClassName, err)
+		}
+		return nil
+	}
+	for _, prop := range want.Properties {
+		if findProperty(cls.Properties, prop.Name) != nil {
+			continue
+		}
+		err := m.client.Schema().PropertyCreator().WithClassName(metaClassName).WithProperty(prop).Do(ctx)
+		if err != nil {
+			return fmt.Errorf("adding property %q to %s: %w", prop.Name, metaClassName, err)
+		}
+	}
+	return nil
+}
+
+func metaID() strfmt.UUID {
+	return strfmt.UUID(uuid.NewSHA1(metaNamespace, []byte(className)).String())
+}
+
+// 記録されているスキーマの版を読み込む。記録がない場合は版0のDocumentクラスとみなす
+func (m *Migrator) readMeta(ctx context.Context) (schemaMeta, error) {
+	meta := schemaMeta{className: className}
+	cls, err := m.getClass(ctx, metaClassName)
+	if err != nil || cls == nil {
+		return meta, err
+	}
+	objects, err := m.client.Data().ObjectsGetter().
+		WithClassName(metaClassName).
+		WithID(string(metaID())).
+		Do(ctx)
+	var werr *fault.WeaviateClientError
+	if errors.As(err, &werr) && werr.StatusCode == 404 {
+		return meta, nil
+	}
+	if err != nil {
+		return meta, fmt.Errorf("reading schema version: %w", err)
+	}
+	if len(objects) == 0 {
+		return meta, nil
+	}
+	props, _ := objects[0].Properties.(map[string]any)
+	meta.version = intProperty(props, "version")
+	if name, _ := props["className"].(string); name != "" {
+		meta.className = name
+	}
+	meta.previousVersion = intProperty(props, "previousVersion")
+	meta.previousClass, _ = props["previousClass"].(string)
+	return meta, nil
+}
+
+func (m *Migrator) writeMeta(ctx context.Context, meta schemaMeta) error {
+	obj := &models.Object{
+		Class: metaClassName,
+		ID:    metaID(),
+		Properties: map[string]any{
+			"index":           className,
+			"version":         meta.version,
+			"className":       meta.className,
+			"previousVersion": meta.previousVersion,
+			"previousClass":   meta.previousClass,
+		},
+	}
+	resp, err := m.client.Batch().ObjectsBatcher().WithObjects(obj).Do(ctx)
+	if err == nil {
+		err = objectResultErrors(resp)
+	}
+	if err != nil {
+		return fmt.Errorf("saving schema version: %w", err)
+	}
+	return nil
+}
+
+// バッチの結果にエラーがあれば最初のエラーを返す
+func objectResultErrors(resp []models.ObjectsGetResponse) error {
+	for _, obj := range resp {
+		if obj.Result != nil && obj.Result.Errors != nil && len(obj.Result.Errors.Error) > 0 {
+			return fmt.Errorf("object %s: %s", obj.ID, obj.Result.Errors.Error[0].Message)
+		}
+	}
+	return nil
+}
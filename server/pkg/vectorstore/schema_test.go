@@ -0,0 +1,139 @@
+package vectorstore
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/go-openapi/strfmt"
+	"github.com/weaviate/weaviate/entities/models"
+)
+
+func TestLegacyChunkIDs(t *testing.T) {
+	objects := []*models.Object{
+		{ID: "1", Properties: map[string]any{"documentId": "a", "title": "A"}},
+		{ID: "2", Properties: map[string]any{"title": "legacy"}},
+		{ID: "3", Properties: map[string]any{"documentId": "", "title": "empty"}},
+		{ID: "4"},
+	}
+	got := legacyChunkIDs(objects)
+	if want := []strfmt.UUID{"2", "3", "4"}; fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("legacyChunkIDs = %v, want %v", got, want)
+	}
+}
+
+// Weaviateのスキーマのレスポンスをクラスの定義にデコードする
+func decodeClass(t *testing.T, payload string) *models.Class {
+	t.Helper()
+	var cls models.Class
+	if err := json.Unmarshal([]byte(payload), &cls); err != nil {
+		t.Fatal(err)
+	}
+	return &cls
+}
+
+func TestSchemaDrift(t *testing.T) {
+	want := &models.Class{
+		Class: "Document",
+		Properties: []*models.Property{
+			{Name: "documentId", DataType: []string{"text"}, Tokenization: models.PropertyTokenizationField},
+			{Name: "title", DataType: []string{"text"}, Tokenization: "gse"},
+			{Name: "category", DataType: []string{"string"}},
+			{Name: "chunkIndex", DataType: []string{"int"}},
+		},
+	}
+	tests := []struct {
+		name string
+		live string
+		want []string
+	}{
+		{
+			name: "up to date",
+			// string型はtext型として作成され、指定していないトークナイズ方法はデフォルトのwordになる
+			live: `{"class":"Document","properties":[
+				{"name":"documentId","dataType":["text"],"tokenization":"field"},
+				{"name":"title","dataType":["text"],"tokenization":"gse"},
+				{"name":"category","dataType":["text"],"tokenization":"word"},
+				{"name":"chunkIndex","dataType":["int"]}]}`,
+		},
+		{
+			name: "auto schema",
+			// 自動スキーマで作成されたクラスは数値がnumber型になり、定義にないプロパティも作成される
+			live: `{"class":"Document","properties":[
+				{"name":"documentId","dataType":["text"],"tokenization":"word"},
+				{"name":"title","dataType":["text"],"tokenization":"gse"},
+				{"name":"category","dataType":["text"],"tokenization":"word"},
+				{"name":"chunkIndex","dataType":["number"]},
+				{"name":"source","dataType":["text"],"tokenization":"word"}]}`,
+			want: []string{
+				`property "documentId" uses word tokenization, expected field (requires reindex)`,
+				`property "chunkIndex" is [number], expected [int] (requires reindex)`,
+				`property "source" is not defined in code`,
+			},
+		},
+		{
+			name: "missing properties",
+			live: `{"class":"Document","properties":[
+				{"name":"title","dataType":["text"],"tokenization":"word"}]}`,
+			want: []string{
+				`property "documentId" is missing`,
+				`property "title" uses word tokenization, expected gse (requires reindex)`,
+				`property "category" is missing`,
+				`property "chunkIndex" is missing`,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := schemaDrift(want, decodeClass(t, tt.live))
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("schemaDrift =\n%q\nwant\n%q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConvertProperties(t *testing.T) {
+	cls := documentClass("Document", "gse")
+	tests := []struct {
+		name    string
+		payload string
+		want    map[string]any
+	}{
+		{
+			name: "auto schema numbers",
+			// JSONの数値はfloat64でデコードされるため、int型のプロパティは整数に変換する
+			payload: `{"documentId":"a","title":"図書館","tags":["施設"],"chunkIndex":2,"tokenCount":128.4,"embeddingDim":768}`,
+			want: map[string]any{
+				"documentId":   "a",
+				"title":        "図書館",
+				"tags":         []any{"施設"},
+				"chunkIndex":   int64(2),
+				"tokenCount":   int64(128),
+				"embeddingDim": int64(768),
+			},
+		},
+		{
+			name:    "unknown properties",
+			payload: `{"title":"図書館","source":"facilities/library.md","score":0.5}`,
+			want:    map[string]any{"title": "図書館"},
+		},
+		{
+			name:    "no properties",
+			payload: `null`,
+			want:    map[string]any{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var properties any
+			if err := json.Unmarshal([]byte(tt.payload), &properties); err != nil {
+				t.Fatal(err)
+			}
+			if got := convertProperties(properties, cls); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("convertProperties = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,146 @@
+// pkg/vectorstore/store.go
+package vectorstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// 検索モード
+const (
+	ModeVector = "vector" // ベクトル検索のみ
+	ModeHybrid = "hybrid" // BM25とベクトル検索を組み合わせる
+)
+
+// Chunk はベクトルストアに保存するドキュメントのチャンク
+type Chunk struct {
+	UUID        string
+	DocumentID  string
+	Title       string
+	Content     string
+	Category    string
+	Tags        []string
+	Department  string
+	UpdatedAt   string
+	ChunkIndex  int
+	TotalChunks int
+	StartChar   int
+	EndChar     int
+	TokenCount  int
+	Precedence  int
+	Headings    []string
+	SectionPath string // 見出しのパス（例: 授業時間等 › オフィスアワー）
+	ContentHash string // 登録時のドキュメントの内容のハッシュ（変更の検出に使う）
+
+	// 登録時の埋め込みとチャンク分割の設定（設定の変更で古くなったチャンクの検出に使う）
+	EmbeddingModel string // 埋め込みモデル
+	EmbeddingDim   int    // ベクトルの次元数
+	ChunkConfig    string // チャンク分割の設定のハッシュ
+	IngestedAt     string // 登録した日時（RFC3339）
+
+	Vector []float32
+}
+
+// SectionSeparator は見出しのパスの区切り
+const SectionSeparator = " › "
+
+// SectionPath は見出しを区切りで連結した見出しのパスを返す
+func SectionPath(headings []string) string {
+	return strings.Join(headings, SectionSeparator)
+}
+
+// Filter はチャンクをメタデータで絞り込む条件。空のフィールドは条件に含めない
+type Filter struct {
+	Categories    []string // いずれかのカテゴリに一致
+	Departments   []string // いずれかの所属に一致
+	Tags          []string // いずれかのタグを含む
+	UpdatedAfter  string   // この日付以降に更新（YYYY-MM-DD）
+	UpdatedBefore string   // この日付以前に更新（YYYY-MM-DD）
+	Sections      []string // いずれかの見出しのパス、またはその下の見出しに一致
+}
+
+// SearchQuery は検索の条件
+type SearchQuery struct {
+	Mode      string    // ModeVector または ModeHybrid
+	Vector    []float32 // クエリの埋め込みベクトル
+	Text      string    // ハイブリッド検索でBM25に使うクエリ文字列
+	Alpha     float32   // ハイブリッド検索でのベクトル検索の重み（0はBM25のみ、1はベクトルのみ）
+	Limit     int       // 取得するチャンク数
+	Certainty float32   // ベクトル検索での類似度の閾値（0は閾値なし）
+	Filter    *Filter   // nilでない場合は条件に一致するチャンクのみを検索する
+}
+
+// Result は検索結果のチャンク
+type Result struct {
+	Chunk
+	Certainty float64 // ベクトル検索での類似度（0〜1）
+	Score     float64 // ハイブリッド検索でのスコア
+}
+
+// DocumentInfo は保存されたドキュメントの概要
+type DocumentInfo struct {
+	ID          string
+	Title       string
+	Category    string
+	Department  string
+	UpdatedAt   string
+	ContentHash string
+	Chunks      int
+
+	EmbeddingModel string // チャンクの埋め込みモデル（記録がない場合は空）
+	ChunkConfig    string // チャンク分割の設定のハッシュ（記録がない場合は空）
+}
+
+// IndexProfile は保存されたチャンクを作成した埋め込みモデルとチャンク分割の設定ごとのチャンク数。
+// 記録がない（記録する前に登録された）チャンクは空文字列と0に数える
+type IndexProfile struct {
+	Objects      int
+	Models       map[string]int // 埋め込みモデルごとのチャンク数
+	Dimensions   map[int]int    // ベクトルの次元数ごとのチャンク数
+	ChunkConfigs map[string]int // チャンク分割の設定のハッシュごとのチャンク数
+}
+
+// ChunkError はバッチ内の1つのチャンクの保存に失敗したことを表す
+type ChunkError struct {
+	ChunkIndex int
+	Message    string
+}
+
+// BatchError はバッチで保存したチャンクのうち、一部の保存に失敗したことを表す。
+// 失敗しなかったチャンクは保存されている
+type BatchError struct {
+	Total  int          // バッチで保存しようとしたチャンク数
+	Failed []ChunkError // 保存に失敗したチャンク
+}
+
+func (e *BatchError) Error() string {
+	if len(e.Failed) == 0 {
+		return fmt.Sprintf("failed to store chunks of %d", e.Total)
+	}
+	return fmt.Sprintf("failed to store %d of %d chunks (chunk %d: %s)",
+		len(e.Failed), e.Total, e.Failed[0].ChunkIndex, e.Failed[0].Message)
+}
+
+// ErrEmptyDocumentID はドキュメントIDが空であることを表す。
+// 空のIDで保存すると、IDの記録がない他のチャンクと区別できなくなる
+var ErrEmptyDocumentID = errors.New("document id is empty")
+
+// VectorStore はチャンクと埋め込みベクトルを保存・検索するストアのインターフェース
+type VectorStore interface {
+	// UpsertDocument はドキュメントのチャンクを chunks で置き換える。
+	// 新しい版のチャンク数が少ない場合、残った古いチャンクも削除する。
+	// 一部のチャンクの保存に失敗した場合は *BatchError を、documentIDが空の場合は ErrEmptyDocumentID を返す
+	UpsertDocument(ctx context.Context, documentID string, chunks []Chunk) error
+	// DeleteDocument はドキュメントのすべてのチャンクを削除し、削除したチャンク数を返す
+	DeleteDocument(ctx context.Context, documentID string) (int, error)
+	// Search は条件に一致するチャンクを関連度の高い順に返す
+	Search(ctx context.Context, q SearchQuery) ([]Result, error)
+	// ListDocuments は保存されたドキュメントの一覧をIDの順で返す。すべてを返せない場合は一部だけを返さずにエラーを返す
+	ListDocuments(ctx context.Context) ([]DocumentInfo, error)
+	// GetDocument はドキュメントのチャンクをチャンク番号の順に返す。存在しない場合は空を返す
+	GetDocument(ctx context.Context, documentID string) ([]Chunk, error)
+	// Profile は埋め込みモデルとチャンク分割の設定ごとのチャンク数を返す
+	Profile(ctx context.Context) (IndexProfile, error)
+}
@@ -0,0 +1,245 @@
+// pkg/vectorstore/versions.go
+package vectorstore
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/weaviate/weaviate-go-client/v4/weaviate/graphql"
+	"github.com/weaviate/weaviate/entities/models"
+)
+
+// IndexVersion はチャンクを保存するクラスの版。
+// 新しい版のクラスに登録してから検索に使うクラスを切り替え、切り替える前のクラスは切り戻しのために残す
+type IndexVersion struct {
+	Class    string `json:"class"`
+	Objects  int    `json:"objects"`  // 保存されているチャンク数
+	Active   bool   `json:"active"`   // 検索に使われている
+	Previous bool   `json:"previous"` // 切り戻し先
+}
+
+var (
+	// ErrVersionNotFound は指定した版のクラスが存在しないことを表す
+	ErrVersionNotFound = errors.New("index version not found")
+	// ErrVersionConflict は現在の版の状態では操作できないことを表す（使用中の版の削除や切り戻し先がない場合など）
+	ErrVersionConflict = errors.New("index version conflict")
+)
+
+// VersionedStore は版ごとのクラスにチャンクを保存し、検索に使うクラスを切り替えられる VectorStore
+type VersionedStore interface {
+	VectorStore
+	// Versions はすべての版のクラスを返す
+	Versions(ctx context.Context) ([]IndexVersion, error)
+	// Activate は検索に使うクラスを切り替え、切り替える前のクラスを切り戻し先として記録する。
+	// クラスが存在しない場合は ErrVersionNotFound、現在のスキーマと異なる場合は ErrVersionConflict を返す
+	Activate(ctx context.Context, class string) error
+	// Rollback は切り戻し先のクラスに切り替え、切り替えたクラス名を返す。切り戻し先がない場合は ErrVersionConflict を返す
+	Rollback(ctx context.Context) (string, error)
+	// DropVersion は使われていない版のクラスを削除する。
+	// クラスが存在しない場合は ErrVersionNotFound、使われている場合は ErrVersionConflict を返す
+	DropVersion(ctx context.Context, class string) error
+	// Refresh は記録されている検索に使うクラスを読み込み直す（他のプロセスによる切り替えを反映する）
+	Refresh(ctx context.Context) error
+}
+
+// 版のクラス名の番号を返す。Documentは0、Document_v7は7。版のクラス名でない場合はfalseを返す
+func classVersionNumber(name string) (int, bool) {
+	if name == className {
+		return 0, true
+	}
+	suffix, ok := strings.CutPrefix(name, className+"_v")
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.Atoi(suffix)
+	if err != nil || n <= 0 {
+		return 0, false
+	}
+	return n, true
+}
+
+// 版のクラス名を番号の順に返す
+func (m *Migrator) versionClasses(ctx context.Context) ([]string, error) {
+	schema, err := m.client.Schema().Getter().Do(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("reading weaviate schema: %w", err)
+	}
+	var names []string
+	for _, cls := range schema.Classes {
+		if _, ok := classVersionNumber(cls.Class); ok {
+			names = append(names, cls.Class)
+		}
+	}
+	slices.SortFunc(names, func(a, b string) int {
+		na, _ := classVersionNumber(a)
+		nb, _ := classVersionNumber(b)
+		return cmp.Compare(na, nb)
+	})
+	return names, nil
+}
+
+// CreateVersion は最新のスキーマで新しい版のクラス（例: Document_v7）を作成する。検索に使うクラスは切り替えない
+func (m *Migrator) CreateVersion(ctx context.Context) (*models.Class, error) {
+	names, err := m.versionClasses(ctx)
+	if err != nil {
+		return nil, err
+	}
+	next := 1
+	if len(names) > 0 {
+		last, _ := classVersionNumber(names[len(names)-1])
+		next = last + 1
+	}
+	cls := documentClass(fmt.Sprintf("%s_v%d", className, next), m.tokenization)
+	if err := m.client.Schema().ClassCreator().WithClass(cls).Do(ctx); err != nil {
+		return nil, fmt.Errorf("creating weaviate class %s: %w", cls.Class, err)
+	}
+	log.Printf("created weaviate class %s", cls.Class)
+	return cls, nil
+}
+
+// Versions はすべての版のクラスと保存されているチャンク数を返す
+func (m *Migrator) Versions(ctx context.Context) ([]IndexVersion, error) {
+	meta, err := m.readMeta(ctx)
+	if err != nil {
+		return nil, err
+	}
+	names, err := m.versionClasses(ctx)
+	if err != nil {
+		return nil, err
+	}
+	versions := make([]IndexVersion, len(names))
+	for i, name := range names {
+		count, err := m.countObjects(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		versions[i] = IndexVersion{
+			Class:    name,
+			Objects:  count,
+			Active:   name == meta.className,
+			Previous: name == meta.previousClass,
+		}
+	}
+	return versions, nil
+}
+
+// Activate は検索に使うクラスをclassに切り替える。
+// 記録は1つのオブジェクトの書き込みで更新するため、切り替えの途中の状態は発生しない
+func (m *Migrator) Activate(ctx context.Context, class string) error {
+	if _, ok := classVersionNumber(class); !ok {
+		return fmt.Errorf("%w: %s is not a %s class", ErrVersionNotFound, class, className)
+	}
+	live, err := m.getClass(ctx, class)
+	if err != nil {
+		return err
+	}
+	if live == nil {
+		return fmt.Errorf("%w: class %s does not exist", ErrVersionNotFound, class)
+	}
+	if drift := schemaDrift(documentClass(class, m.tokenization), live); len(drift) > 0 {
+		return fmt.Errorf("%w: class %s does not match the current schema: %s", ErrVersionConflict, class, strings.Join(drift, "; "))
+	}
+
+	if err := m.ensureMetaClass(ctx); err != nil {
+		return err
+	}
+	meta, err := m.readMeta(ctx)
+	if err != nil {
+		return err
+	}
+	if meta.className == class {
+		return nil
+	}
+	next := schemaMeta{
+		version:         LatestSchemaVersion(),
+		className:       class,
+		previousVersion: meta.version,
+		previousClass:   meta.className,
+	}
+	if err := m.writeMeta(ctx, next); err != nil {
+		return err
+	}
+	log.Printf("switched weaviate alias %s from %s to %s", className, meta.className, class)
+	return nil
+}
+
+// Rollback は切り戻し先のクラスに切り替え、切り替える前のクラスを新しい切り戻し先にする
+func (m *Migrator) Rollback(ctx context.Context) (string, error) {
+	meta, err := m.readMeta(ctx)
+	if err != nil {
+		return "", err
+	}
+	if meta.previousClass == "" {
+		return "", fmt.Errorf("%w: no previous class to roll back to", ErrVersionConflict)
+	}
+	live, err := m.getClass(ctx, meta.previousClass)
+	if err != nil {
+		return "", err
+	}
+	if live == nil {
+		return "", fmt.Errorf("%w: previous class %s no longer exists", ErrVersionConflict, meta.previousClass)
+	}
+	next := schemaMeta{
+		version:         meta.previousVersion,
+		className:       meta.previousClass,
+		previousVersion: meta.version,
+		previousClass:   meta.className,
+	}
+	if err := m.writeMeta(ctx, next); err != nil {
+		return "", err
+	}
+	log.Printf("rolled back weaviate alias %s from %s to %s", className, meta.className, next.className)
+	return next.className, nil
+}
+
+// DropVersion は検索にも切り戻しにも使われていない版のクラスを削除する
+func (m *Migrator) DropVersion(ctx context.Context, class string) error {
+	if _, ok := classVersionNumber(class); !ok {
+		return fmt.Errorf("%w: %s is not a %s class", ErrVersionNotFound, class, className)
+	}
+	meta, err := m.readMeta(ctx)
+	if err != nil {
+		return err
+	}
+	if class == meta.className || class == meta.previousClass {
+		return fmt.Errorf("%w: class %s is in use (active: %s, previous: %s)", ErrVersionConflict, class, meta.className, meta.previousClass)
+	}
+	live, err := m.getClass(ctx, class)
+	if err != nil {
+		return err
+	}
+	if live == nil {
+		return fmt.Errorf("%w: class %s does not exist", ErrVersionNotFound, class)
+	}
+	if err := m.client.Schema().ClassDeleter().WithClassName(class).Do(ctx); err != nil {
+		return fmt.Errorf("deleting class %s: %w", class, err)
+	}
+	log.Printf("deleted weaviate class %s", class)
+	return nil
+}
+
+// クラスに保存されているオブジェクト数を返す
+func (m *Migrator) countObjects(ctx context.Context, class string) (int, error) {
+	result, err := m.client.GraphQL().Aggregate().
+		WithClassName(class).
+		WithFields(graphql.Field{Name: "meta", Fields: []graphql.Field{{Name: "count"}}}).
+		Do(ctx)
+	if werr := combinedWeaviateError(result, err); werr != nil {
+		return 0, werr
+	}
+	groups, err := graphQLResultList(result, "Aggregate", class)
+	if err != nil {
+		return 0, fmt.Errorf("reading weaviate response: %w", err)
+	}
+	if len(groups) == 0 {
+		return 0, nil
+	}
+	meta, _ := groups[0]["meta"].(map[string]any)
+	return intProperty(meta, "count"), nil
+}
@@ -0,0 +1,830 @@
+// pkg/vectorstore/weaviate.go
+package vectorstore
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-openapi/strfmt"
+	"github.com/weaviate/weaviate-go-client/v4/weaviate"
+	"github.com/weaviate/weaviate-go-client/v4/weaviate/filters"
+	"github.com/weaviate/weaviate-go-client/v4/weaviate/graphql"
+	"github.com/weaviate/weaviate/entities/models"
+)
+
+const (
+	className          = "Document" // チャンクを保存するWeaviateのクラス名
+	maxDocumentGroups  = 10000      // 一覧のために集計するドキュメント数の上限（超える場合はエラーにする）
+	documentChunksPage = 1000       // ドキュメントのチャンクを1回に取得する数
+)
+
+// WeaviateConfig はWeaviateへの接続とスキーマの設定
+type WeaviateConfig struct {
+	Host   string // ホスト名とポート（例: "weaviate:8080"）
+	Scheme string // 空の場合は "http"
+	// Tokenization はtitleとcontentに指定するトークナイズ方法。
+	// 日本語は空白で区切られないため、デフォルトのwordではなくtrigramやgseを指定する
+	Tokenization string
+}
+
+// WeaviateStore はWeaviateのDocumentクラスにチャンクを保存する VectorStore の実装
+type WeaviateStore struct {
+	client   *weaviate.Client
+	migrator *Migrator
+	class    atomic.Pointer[string] // 検索と書き込みに使うクラス名（切り替えた場合は版が付く）
+	fixed    bool                   // ForClassで作成した、クラスを切り替えないストア
+}
+
+// NewWeaviateStore はWeaviateに接続し、スキーマのマイグレーションを適用する。
+// Documentクラスが存在しない場合は最新の版で作成する
+func NewWeaviateStore(ctx context.Context, cfg WeaviateConfig) (*WeaviateStore, error) {
+	migrator, err := NewMigrator(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	class, err := migrator.Migrate(ctx)
+	if err != nil {
+		return nil, err
+	}
+	s := &WeaviateStore{client: migrator.client, migrator: migrator}
+	s.class.Store(&class)
+	return s, nil
+}
+
+// ErrSchemaNotMigrated は OpenWeaviateStore で接続したクラスにスキーマのマイグレーションが適用されていないことを表す
+var ErrSchemaNotMigrated = errors.New("weaviate schema is not migrated")
+
+// OpenWeaviateStore はスキーマを変更せずにWeaviateに接続する。ドライランなど書き込まない処理で読み取りに使う。
+// クラスが存在しない場合や未適用のマイグレーションがある場合は ErrSchemaNotMigrated を返す
+func OpenWeaviateStore(ctx context.Context, cfg WeaviateConfig) (*WeaviateStore, error) {
+	migrator, err := NewMigrator(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	status, err := migrator.Status(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if !status.Exists || len(status.Pending) > 0 {
+		return nil, fmt.Errorf("%w: class %s is at schema version %d of %d", ErrSchemaNotMigrated, status.Class, status.Version, status.Latest)
+	}
+	s := &WeaviateStore{client: migrator.client, migrator: migrator}
+	s.class.Store(&status.Class)
+	return s, nil
+}
+
+// Class は検索と書き込みに使っているクラス名を返す
+func (s *WeaviateStore) Class() string {
+	return *s.class.Load()
+}
+
+// ForClass は検索に使うクラスとは別の版のクラスに読み書きするストアを返す。
+// 新しい版のクラスに登録して確認してから Activate で切り替えるために使う
+func (s *WeaviateStore) ForClass(class string) *WeaviateStore {
+	fixed := &WeaviateStore{client: s.client, migrator: s.migrator, fixed: true}
+	fixed.class.Store(&class)
+	return fixed
+}
+
+// NewVersion は最新のスキーマで新しい版のクラスを作成し、そのクラスに読み書きするストアを返す
+func (s *WeaviateStore) NewVersion(ctx context.Context) (*WeaviateStore, error) {
+	cls, err := s.migrator.CreateVersion(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return s.ForClass(cls.Class), nil
+}
+
+// Versions はすべての版のクラスを返す
+func (s *WeaviateStore) Versions(ctx context.Context) ([]IndexVersion, error) {
+	return s.migrator.Versions(ctx)
+}
+
+// Activate は検索に使うクラスを切り替える。処理中のリクエストは切り替える前のクラスで完了する
+func (s *WeaviateStore) Activate(ctx context.Context, class string) error {
+	if err := s.migrator.Activate(ctx, class); err != nil {
+		return err
+	}
+	s.class.Store(&class)
+	return nil
+}
+
+// Rollback は切り戻し先のクラスに切り替える
+func (s *WeaviateStore) Rollback(ctx context.Context) (string, error) {
+	class, err := s.migrator.Rollback(ctx)
+	if err != nil {
+		return "", err
+	}
+	s.class.Store(&class)
+	return class, nil
+}
+
+// DropVersion は使われていない版のクラスを削除する
+func (s *WeaviateStore) DropVersion(ctx context.Context, class string) error {
+	return s.migrator.DropVersion(ctx, class)
+}
+
+// Refresh は記録されている検索に使うクラスを読み込み、他のプロセスが切り替えた場合は反映する
+func (s *WeaviateStore) Refresh(ctx context.Context) error {
+	if s.fixed {
+		return nil
+	}
+	meta, err := s.migrator.readMeta(ctx)
+	if err != nil {
+		return err
+	}
+	if old := s.Class(); old != meta.className {
+		log.Printf("weaviate alias %s switched from %s to %s", className, old, meta.className)
+		s.class.Store(&meta.className)
+	}
+	return nil
+}
+
+// Weaviateに接続する。起動直後のWeaviateに接続できない場合は再試行する
+func connectWeaviate(ctx context.Context, cfg WeaviateConfig) (*weaviate.Client, error) {
+	client, err := weaviate.NewClient(weaviate.Config{
+		Host:   cfg.Host,
+		Scheme: cmp.Or(cfg.Scheme, "http"),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("initializing weaviate: %w", err)
+	}
+
+	maxRetries := 5
+	for i := 0; i < maxRetries; i++ {
+		_, err := client.Schema().ClassExistenceChecker().WithClassName(className).Do(ctx)
+		if err == nil {
+			return client, nil
+		}
+
+		log.Printf("Failed to connect to Weaviate (attempt %d/%d): %v", i+1, maxRetries, err)
+		time.Sleep(time.Second * 2)
+	}
+
+	return nil, fmt.Errorf("failed to initialize Weaviate after %d attempts", maxRetries)
+}
+
+// UpsertDocument はドキュメントのチャンクを保存する。
+// チャンクのUUIDが決定的であれば既存のチャンクを上書きし、そのあとで残った古いチャンクを削除する。
+// 上書きしてから削除するため、再登録中にドキュメントのチャンクが1つもなくなる状態は発生しない。
+// 一部のチャンクの保存に失敗した場合も古いチャンクの削除は行い、*BatchError を返す
+func (s *WeaviateStore) UpsertDocument(ctx context.Context, documentID string, chunks []Chunk) error {
+	if documentID == "" {
+		return ErrEmptyDocumentID
+	}
+	class := s.Class()
+	var batchErr *BatchError
+	if len(chunks) > 0 {
+		objects := make([]*models.Object, len(chunks))
+		for i, c := range chunks {
+			c.DocumentID = documentID
+			objects[i] = chunkObject(class, c)
+		}
+
+		log.Printf("storing %v objects in weaviate", len(objects))
+		resp, err := s.client.Batch().ObjectsBatcher().WithObjects(objects...).Do(ctx)
+		if err != nil {
+			return fmt.Errorf("storing in weaviate: %w", err)
+		}
+		batchErr = batchObjectErrors(chunks, resp)
+	}
+
+	// 前の版から残ったチャンクを削除
+	if err := s.deleteStaleChunks(ctx, class, documentID, len(chunks)); err != nil {
+		return fmt.Errorf("deleting stale chunks of %q: %w", documentID, err)
+	}
+	if batchErr != nil {
+		return batchErr
+	}
+	return nil
+}
+
+// PutChunks はチャンクをそのまま保存する。UpsertDocument と異なり古いチャンクは削除しないため、
+// スナップショットを空のクラスに復元する場合などに使う
+func (s *WeaviateStore) PutChunks(ctx context.Context, chunks []Chunk) error {
+	if len(chunks) == 0 {
+		return nil
+	}
+	class := s.Class()
+	objects := make([]*models.Object, len(chunks))
+	for i, c := range chunks {
+		objects[i] = chunkObject(class, c)
+	}
+	resp, err := s.client.Batch().ObjectsBatcher().WithObjects(objects...).Do(ctx)
+	if err != nil {
+		return fmt.Errorf("storing in weaviate: %w", err)
+	}
+	if batchErr := batchObjectErrors(chunks, resp); batchErr != nil {
+		return batchErr
+	}
+	return nil
+}
+
+// ScanChunks はクラスのすべてのチャンクをベクトルを含めてUUIDの順に読み込み、fnに渡す
+func (s *WeaviateStore) ScanChunks(ctx context.Context, fn func(Chunk) error) error {
+	class := s.Class()
+	after := ""
+	for {
+		getter := s.client.Data().ObjectsGetter().
+			WithClassName(class).
+			WithVector().
+			WithLimit(reindexBatch)
+		if after != "" {
+			getter = getter.WithAfter(after)
+		}
+		objects, err := getter.Do(ctx)
+		if err != nil {
+			return fmt.Errorf("reading %s: %w", class, err)
+		}
+		if len(objects) == 0 {
+			return nil
+		}
+		for _, obj := range objects {
+			props, _ := obj.Properties.(map[string]any)
+			c := decodeChunk(props)
+			c.UUID = string(obj.ID)
+			c.Vector = obj.Vector
+			if err := fn(c); err != nil {
+				return err
+			}
+		}
+		after = string(objects[len(objects)-1].ID)
+	}
+}
+
+// Count はクラスに保存されているチャンク数を返す
+func (s *WeaviateStore) Count(ctx context.Context) (int, error) {
+	return s.migrator.countObjects(ctx, s.Class())
+}
+
+// SchemaVersion は検索に使っているクラスのスキーマの版を返す
+func (s *WeaviateStore) SchemaVersion(ctx context.Context) (int, error) {
+	meta, err := s.migrator.readMeta(ctx)
+	if err != nil {
+		return 0, err
+	}
+	return meta.version, nil
+}
+
+// チャンクをWeaviateのオブジェクトに変換する
+func chunkObject(class string, c Chunk) *models.Object {
+	return &models.Object{
+		Class: class,
+		ID:    strfmt.UUID(c.UUID),
+		Properties: map[string]any{
+			"documentId":  c.DocumentID,
+			"title":       c.Title,
+			"content":     c.Content,
+			"category":    c.Category,
+			"tags":        c.Tags,
+			"department":  c.Department,
+			"updatedAt":   c.UpdatedAt,
+			"chunkIndex":  c.ChunkIndex,
+			"totalChunks": c.TotalChunks,
+			"startChar":   c.StartChar,
+			"endChar":     c.EndChar,
+			"tokenCount":  c.TokenCount,
+			"precedence":  c.Precedence,
+			"headings":    c.Headings,
+			"sectionPath": c.SectionPath,
+			"contentHash": c.ContentHash,
+
+			"embeddingModel": c.EmbeddingModel,
+			"embeddingDim":   c.EmbeddingDim,
+			"chunkConfig":    c.ChunkConfig,
+			"ingestedAt":     c.IngestedAt,
+		},
+		Vector: c.Vector,
+	}
+}
+
+// バッチのオブジェクトごとの結果を確認し、保存に失敗したチャンクがあれば *BatchError を返す
+func batchObjectErrors(chunks []Chunk, resp []models.ObjectsGetResponse) *BatchError {
+	indexByUUID := make(map[strfmt.UUID]int, len(chunks))
+	for _, c := range chunks {
+		indexByUUID[strfmt.UUID(c.UUID)] = c.ChunkIndex
+	}
+
+	var failed []ChunkError
+	for _, obj := range resp {
+		if obj.Result == nil || obj.Result.Errors == nil || len(obj.Result.Errors.Error) == 0 {
+			continue
+		}
+		var messages []string
+		for _, e := range obj.Result.Errors.Error {
+			messages = append(messages, e.Message)
+		}
+		index, ok := indexByUUID[obj.ID]
+		if !ok {
+			index = -1
+		}
+		failed = append(failed, ChunkError{ChunkIndex: index, Message: strings.Join(messages, "; ")})
+	}
+	// 結果の数が足りない場合は、結果のないチャンクを失敗として扱う
+	if len(resp) < len(chunks) {
+		returned := make(map[strfmt.UUID]bool, len(resp))
+		for _, obj := range resp {
+			returned[obj.ID] = true
+		}
+		for _, c := range chunks {
+			if !returned[strfmt.UUID(c.UUID)] {
+				failed = append(failed, ChunkError{ChunkIndex: c.ChunkIndex, Message: "no result returned for object"})
+			}
+		}
+	}
+	if len(failed) == 0 {
+		return nil
+	}
+	slices.SortFunc(failed, func(a, b ChunkError) int {
+		return cmp.Compare(a.ChunkIndex, b.ChunkIndex)
+	})
+	return &BatchError{Total: len(chunks), Failed: failed}
+}
+
+// documentIDのチャンクのうち、チャンク番号がkeep以上のものを削除する
+func (s *WeaviateStore) deleteStaleChunks(ctx context.Context, class, documentID string, keep int) error {
+	where := filters.Where().
+		WithOperator(filters.And).
+		WithOperands([]*filters.WhereBuilder{
+			documentWhere(documentID),
+			filters.Where().
+				WithPath([]string{"chunkIndex"}).
+				WithOperator(filters.GreaterThanEqual).
+				WithValueInt(int64(keep)),
+		})
+
+	resp, err := s.client.Batch().ObjectsBatchDeleter().
+		WithClassName(class).
+		WithWhere(where).
+		WithOutput("minimal").
+		Do(ctx)
+	if err != nil {
+		return err
+	}
+	if resp.Results != nil && resp.Results.Matches > 0 {
+		log.Printf("deleted %d stale chunks of %q (failed: %d)", resp.Results.Matches, documentID, resp.Results.Failed)
+	}
+	if resp.Results != nil && resp.Results.Failed > 0 {
+		return fmt.Errorf("failed to delete %d of %d stale chunks", resp.Results.Failed, resp.Results.Matches)
+	}
+	return nil
+}
+
+// DeleteDocument はドキュメントのすべてのチャンクを削除し、削除したチャンク数を返す
+func (s *WeaviateStore) DeleteDocument(ctx context.Context, documentID string) (int, error) {
+	resp, err := s.client.Batch().ObjectsBatchDeleter().
+		WithClassName(s.Class()).
+		WithWhere(documentWhere(documentID)).
+		WithOutput("minimal").
+		Do(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("deleting document %q: %w", documentID, err)
+	}
+	if resp.Results == nil {
+		return 0, nil
+	}
+	if resp.Results.Failed > 0 {
+		return 0, fmt.Errorf("failed to delete %d of %d chunks of %q", resp.Results.Failed, resp.Results.Matches, documentID)
+	}
+	log.Printf("deleted %d chunks of %q", resp.Results.Matches, documentID)
+	return int(resp.Results.Matches), nil
+}
+
+// Search はベクトル検索またはWeaviateのハイブリッドBM25+ベクトル検索を行う
+func (s *WeaviateStore) Search(ctx context.Context, q SearchQuery) ([]Result, error) {
+	class := s.Class()
+	gql := s.client.GraphQL()
+	get := gql.Get().
+		WithClassName(class).
+		WithLimit(q.Limit)
+
+	switch q.Mode {
+	case ModeHybrid:
+		get = get.
+			WithFields(chunkFields("score")...).
+			WithHybrid(gql.HybridArgumentBuilder().
+				WithQuery(q.Text).
+				WithVector(q.Vector).
+				WithAlpha(q.Alpha).
+				WithProperties([]string{"title", "content"}).
+				WithFusionType(graphql.RelativeScore))
+	default:
+		nearVector := gql.NearVectorArgBuilder().WithVector(q.Vector)
+		if q.Certainty > 0 {
+			nearVector = nearVector.WithCertainty(q.Certainty)
+		}
+		get = get.
+			WithFields(chunkFields("certainty")...).
+			WithNearVector(nearVector)
+	}
+	if where := q.Filter.where(); where != nil {
+		get = get.WithWhere(where)
+	}
+
+	result, err := get.Do(ctx)
+	if werr := combinedWeaviateError(result, err); werr != nil {
+		return nil, werr
+	}
+	log.Printf("Query response: %+v", result.Data)
+
+	objects, err := graphQLResultList(result, "Get", class)
+	if err != nil {
+		return nil, fmt.Errorf("reading weaviate response: %w", err)
+	}
+	results := make([]Result, len(objects))
+	for i, obj := range objects {
+		results[i].Chunk = decodeChunk(obj)
+		additional, _ := obj["_additional"].(map[string]any)
+		if cert, ok := additional["certainty"].(float64); ok {
+			results[i].Certainty = cert
+		}
+		// ハイブリッド検索のスコアは文字列で返される
+		if score, ok := additional["score"].(string); ok {
+			results[i].Score, _ = strconv.ParseFloat(score, 64)
+		}
+	}
+	return results, nil
+}
+
+// ListDocuments はdocumentIdでグループ化して集計し、ドキュメントの一覧をIDの順で返す。
+// 集計はページングできないため、ドキュメント数が maxDocumentGroups を超える場合は一部だけを返さずにエラーにする
+func (s *WeaviateStore) ListDocuments(ctx context.Context) ([]DocumentInfo, error) {
+	topOccurrence := func(name string) graphql.Field {
+		return graphql.Field{Name: name, Fields: []graphql.Field{
+			{Name: "topOccurrences(limit: 1)", Fields: []graphql.Field{{Name: "value"}}},
+		}}
+	}
+
+	class := s.Class()
+	result, err := s.client.GraphQL().Aggregate().
+		WithClassName(class).
+		WithGroupBy("documentId").
+		WithFields(
+			graphql.Field{Name: "groupedBy", Fields: []graphql.Field{{Name: "value"}}},
+			graphql.Field{Name: "meta", Fields: []graphql.Field{{Name: "count"}}},
+			topOccurrence("title"),
+			topOccurrence("category"),
+			topOccurrence("department"),
+			topOccurrence("updatedAt"),
+			topOccurrence("contentHash"),
+			topOccurrence("embeddingModel"),
+			topOccurrence("chunkConfig"),
+		).
+		WithLimit(maxDocumentGroups + 1).
+		Do(ctx)
+	if werr := combinedWeaviateError(result, err); werr != nil {
+		return nil, werr
+	}
+
+	groups, err := graphQLResultList(result, "Aggregate", class)
+	if err != nil {
+		return nil, fmt.Errorf("reading weaviate response: %w", err)
+	}
+	if len(groups) > maxDocumentGroups {
+		return nil, fmt.Errorf("%s has more than %d documents, the document list would be incomplete", class, maxDocumentGroups)
+	}
+
+	docs := make([]DocumentInfo, 0, len(groups))
+	for _, group := range groups {
+		groupedBy, _ := group["groupedBy"].(map[string]any)
+		meta, _ := group["meta"].(map[string]any)
+		id, _ := groupedBy["value"].(string)
+		docs = append(docs, DocumentInfo{
+			ID:          id,
+			Title:       topOccurrenceValue(group, "title"),
+			Category:    topOccurrenceValue(group, "category"),
+			Department:  topOccurrenceValue(group, "department"),
+			UpdatedAt:   topOccurrenceValue(group, "updatedAt"),
+			ContentHash: topOccurrenceValue(group, "contentHash"),
+			Chunks:      intProperty(meta, "count"),
+
+			EmbeddingModel: topOccurrenceValue(group, "embeddingModel"),
+			ChunkConfig:    topOccurrenceValue(group, "chunkConfig"),
+		})
+	}
+	slices.SortFunc(docs, func(a, b DocumentInfo) int {
+		return cmp.Compare(a.ID, b.ID)
+	})
+	return docs, nil
+}
+
+// Profile は埋め込みモデル、次元数、チャンク分割の設定のプロパティごとに集計したチャンク数を返す
+func (s *WeaviateStore) Profile(ctx context.Context) (IndexProfile, error) {
+	class := s.Class()
+	total, err := s.migrator.countObjects(ctx, class)
+	if err != nil {
+		return IndexProfile{}, err
+	}
+	profile := IndexProfile{Objects: total}
+	if profile.Models, err = s.groupCounts(ctx, class, "embeddingModel", total); err != nil {
+		return IndexProfile{}, err
+	}
+	if profile.ChunkConfigs, err = s.groupCounts(ctx, class, "chunkConfig", total); err != nil {
+		return IndexProfile{}, err
+	}
+	dims, err := s.groupCounts(ctx, class, "embeddingDim", total)
+	if err != nil {
+		return IndexProfile{}, err
+	}
+	profile.Dimensions = make(map[int]int, len(dims))
+	for value, count := range dims {
+		dim, _ := strconv.Atoi(value)
+		profile.Dimensions[dim] += count
+	}
+	return profile, nil
+}
+
+// プロパティの値ごとのチャンク数を返す。値がないチャンクは空文字列に数える
+func (s *WeaviateStore) groupCounts(ctx context.Context, class, property string, total int) (map[string]int, error) {
+	result, err := s.client.GraphQL().Aggregate().
+		WithClassName(class).
+		WithGroupBy(property).
+		WithFields(
+			graphql.Field{Name: "groupedBy", Fields: []graphql.Field{{Name: "value"}}},
+			graphql.Field{Name: "meta", Fields: []graphql.Field{{Name: "count"}}},
+		).
+		Do(ctx)
+	if werr := combinedWeaviateError(result, err); werr != nil {
+		return nil, werr
+	}
+	groups, err := graphQLResultList(result, "Aggregate", class)
+	if err != nil {
+		return nil, fmt.Errorf("reading weaviate response: %w", err)
+	}
+	counts := make(map[string]int, len(groups)+1)
+	grouped := 0
+	for _, group := range groups {
+		groupedBy, _ := group["groupedBy"].(map[string]any)
+		meta, _ := group["meta"].(map[string]any)
+		value, _ := groupedBy["value"].(string)
+		count := intProperty(meta, "count")
+		counts[value] += count
+		grouped += count
+	}
+	if missing := total - grouped; missing > 0 {
+		counts[""] += missing
+	}
+	return counts, nil
+}
+
+// GetDocument はドキュメントのチャンクをチャンク番号の順に取得する。
+// チャンクが多いドキュメントは documentChunksPage 個ずつ取得し、途中で切り捨てない
+func (s *WeaviateStore) GetDocument(ctx context.Context, documentID string) ([]Chunk, error) {
+	class := s.Class()
+	var chunks []Chunk
+	for {
+		result, err := s.client.GraphQL().Get().
+			WithClassName(class).
+			WithFields(chunkFields("id")...).
+			WithWhere(documentWhere(documentID)).
+			WithSort(graphql.Sort{Path: []string{"chunkIndex"}, Order: graphql.Asc}).
+			WithLimit(documentChunksPage).
+			WithOffset(len(chunks)).
+			Do(ctx)
+		if werr := combinedWeaviateError(result, err); werr != nil {
+			return nil, werr
+		}
+
+		objects, err := graphQLResultList(result, "Get", class)
+		if err != nil {
+			return nil, fmt.Errorf("reading weaviate response: %w", err)
+		}
+		for _, obj := range objects {
+			chunks = append(chunks, decodeChunk(obj))
+		}
+		if len(objects) < documentChunksPage {
+			return chunks, nil
+		}
+	}
+}
+
+// 取得するDocumentクラスのフィールド
+func chunkFields(additional ...string) []graphql.Field {
+	fields := []graphql.Field{
+		{Name: "documentId"},
+		{Name: "title"},
+		{Name: "content"},
+		{Name: "category"},
+		{Name: "tags"},
+		{Name: "department"},
+		{Name: "updatedAt"},
+		{Name: "chunkIndex"},
+		{Name: "totalChunks"},
+		{Name: "startChar"},
+		{Name: "endChar"},
+		{Name: "tokenCount"},
+		{Name: "precedence"},
+		{Name: "headings"},
+		{Name: "sectionPath"},
+		{Name: "contentHash"},
+		{Name: "embeddingModel"},
+		{Name: "embeddingDim"},
+		{Name: "chunkConfig"},
+		{Name: "ingestedAt"},
+	}
+	var extra []graphql.Field
+	for _, name := range additional {
+		extra = append(extra, graphql.Field{Name: name})
+	}
+	return append(fields, graphql.Field{Name: "_additional", Fields: extra})
+}
+
+// GraphQLの結果のオブジェクトをチャンクに変換する
+func decodeChunk(obj map[string]any) Chunk {
+	c := Chunk{
+		Tags:        stringsProperty(obj, "tags"),
+		ChunkIndex:  intProperty(obj, "chunkIndex"),
+		TotalChunks: intProperty(obj, "totalChunks"),
+		StartChar:   intProperty(obj, "startChar"),
+		EndChar:     intProperty(obj, "endChar"),
+		TokenCount:  intProperty(obj, "tokenCount"),
+		Precedence:  intProperty(obj, "precedence"),
+		Headings:    stringsProperty(obj, "headings"),
+
+		EmbeddingDim: intProperty(obj, "embeddingDim"),
+	}
+	c.DocumentID, _ = obj["documentId"].(string)
+	c.Title, _ = obj["title"].(string)
+	c.Content, _ = obj["content"].(string)
+	c.Category, _ = obj["category"].(string)
+	c.Department, _ = obj["department"].(string)
+	c.UpdatedAt, _ = obj["updatedAt"].(string)
+	c.SectionPath, _ = obj["sectionPath"].(string)
+	c.ContentHash, _ = obj["contentHash"].(string)
+	c.EmbeddingModel, _ = obj["embeddingModel"].(string)
+	c.ChunkConfig, _ = obj["chunkConfig"].(string)
+	c.IngestedAt, _ = obj["ingestedAt"].(string)
+	if additional, ok := obj["_additional"].(map[string]any); ok {
+		c.UUID, _ = additional["id"].(string)
+	}
+	return c
+}
+
+// 条件をWeaviateのwhere句に変換する。条件がない場合はnilを返す
+func (f *Filter) where() *filters.WhereBuilder {
+	if f == nil {
+		return nil
+	}
+
+	var operands []*filters.WhereBuilder
+	if w := anyOf("category", f.Categories); w != nil {
+		operands = append(operands, w)
+	}
+	if w := anyOf("department", f.Departments); w != nil {
+		operands = append(operands, w)
+	}
+	if len(f.Tags) > 0 {
+		operands = append(operands, filters.Where().
+			WithPath([]string{"tags"}).
+			WithOperator(filters.ContainsAny).
+			WithValueText(f.Tags...))
+	}
+	// updatedAtはYYYY-MM-DD形式の文字列なので、文字列の大小比較で日付を比較できる
+	if f.UpdatedAfter != "" {
+		operands = append(operands, filters.Where().
+			WithPath([]string{"updatedAt"}).
+			WithOperator(filters.GreaterThanEqual).
+			WithValueText(f.UpdatedAfter))
+	}
+	if f.UpdatedBefore != "" {
+		operands = append(operands, filters.Where().
+			WithPath([]string{"updatedAt"}).
+			WithOperator(filters.LessThanEqual).
+			WithValueText(f.UpdatedBefore))
+	}
+	// 見出しのパスに一致するか、見出しのパスの下の見出しに前方一致する
+	var sections []*filters.WhereBuilder
+	for _, s := range f.Sections {
+		sections = append(sections,
+			filters.Where().
+				WithPath([]string{"sectionPath"}).
+				WithOperator(filters.Equal).
+				WithValueText(s),
+			filters.Where().
+				WithPath([]string{"sectionPath"}).
+				WithOperator(filters.Like).
+				WithValueText(s+SectionSeparator+"*"))
+	}
+	if len(sections) > 0 {
+		operands = append(operands, filters.Where().WithOperator(filters.Or).WithOperands(sections))
+	}
+
+	return allOf(operands)
+}
+
+// プロパティがいずれかの値に一致する条件を作成する
+func anyOf(property string, values []string) *filters.WhereBuilder {
+	var operands []*filters.WhereBuilder
+	for _, v := range values {
+		operands = append(operands, filters.Where().
+			WithPath([]string{property}).
+			WithOperator(filters.Equal).
+			WithValueText(v))
+	}
+	switch len(operands) {
+	case 0:
+		return nil
+	case 1:
+		return operands[0]
+	default:
+		return filters.Where().WithOperator(filters.Or).WithOperands(operands)
+	}
+}
+
+// すべての条件を満たす条件を作成する
+func allOf(operands []*filters.WhereBuilder) *filters.WhereBuilder {
+	switch len(operands) {
+	case 0:
+		return nil
+	case 1:
+		return operands[0]
+	default:
+		return filters.Where().WithOperator(filters.And).WithOperands(operands)
+	}
+}
+
+// ドキュメントIDが一致するチャンクを選択する条件
+func documentWhere(id string) *filters.WhereBuilder {
+	return filters.Where().
+		WithPath([]string{"documentId"}).
+		WithOperator(filters.Equal).
+		WithValueText(id)
+}
+
+func combinedWeaviateError(result *models.GraphQLResponse, err error) error {
+	if err != nil {
+		return err
+	}
+	if len(result.Errors) != 0 {
+		var ss []string
+		for _, e := range result.Errors {
+			ss = append(ss, e.Message)
+		}
+		return fmt.Errorf("weaviate error: %v", ss)
+	}
+	return nil
+}
+
+// Weaviate GraphQLのレスポンスから、operation（GetやAggregate）のclassの結果のリストを取り出す
+func graphQLResultList(result *models.GraphQLResponse, operation, class string) ([]map[string]any, error) {
+	data, ok := result.Data[operation]
+	if !ok {
+		return nil, fmt.Errorf("don't have %s key in response", strings.ToLower(operation))
+	}
+	document, ok := data.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("invalid %s key in response", strings.ToLower(operation))
+	}
+	slices, ok := document[class].([]any)
+	if !ok {
+		return nil, fmt.Errorf("document is not a list of results")
+	}
+
+	out := make([]map[string]any, len(slices))
+	for i, slice := range slices {
+		slicedData, ok := slice.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("invalid element in list of documents")
+		}
+		out[i] = slicedData
+	}
+	return out, nil
+}
+
+// 集計結果から最も多く出現した値を取り出す
+func topOccurrenceValue(group map[string]any, name string) string {
+	field, _ := group[name].(map[string]any)
+	occurrences, _ := field["topOccurrences"].([]any)
+	if len(occurrences) == 0 {
+		return ""
+	}
+	top, _ := occurrences[0].(map[string]any)
+	value, _ := top["value"].(string)
+	return value
+}
+
+// GraphQLの数値はfloat64としてデコードされるため、intに変換して取り出す
+func intProperty(data map[string]any, name string) int {
+	v, _ := data[name].(float64)
+	return int(v)
+}
+
+// 文字列の配列のプロパティを取り出す
+func stringsProperty(data map[string]any, name string) []string {
+	values, _ := data[name].([]any)
+	var out []string
+	for _, v := range values {
+		if s, ok := v.(string); ok {
+			out = append(out, s)
+		}
+	}
+	return out
+}
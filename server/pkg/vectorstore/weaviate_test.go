@@ -0,0 +1,79 @@
+package vectorstore
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/weaviate/weaviate/entities/models"
+)
+
+func TestBatchObjectErrors(t *testing.T) {
+	chunks := []Chunk{
+		{UUID: "00000000-0000-0000-0000-000000000000", ChunkIndex: 0},
+		{UUID: "00000000-0000-0000-0000-000000000001", ChunkIndex: 1},
+		{UUID: "00000000-0000-0000-0000-000000000002", ChunkIndex: 2},
+	}
+	tests := []struct {
+		name string
+		resp string // Weaviateのバッチのレスポンス
+		want []ChunkError
+	}{
+		{
+			name: "all succeeded",
+			resp: `[
+				{"id":"00000000-0000-0000-0000-000000000000","class":"Document","result":{"status":"SUCCESS"}},
+				{"id":"00000000-0000-0000-0000-000000000001","class":"Document","result":{}},
+				{"id":"00000000-0000-0000-0000-000000000002","class":"Document"}]`,
+		},
+		{
+			name: "mixed",
+			resp: `[
+				{"id":"00000000-0000-0000-0000-000000000002","class":"Document","result":{"errors":{"error":[
+					{"message":"vector lengths don't match"},{"message":"retry later"}]}}},
+				{"id":"00000000-0000-0000-0000-000000000000","class":"Document","result":{"status":"SUCCESS"}},
+				{"id":"00000000-0000-0000-0000-000000000001","class":"Document","result":{"errors":{"error":[
+					{"message":"invalid text property 'title'"}]}}}]`,
+			want: []ChunkError{
+				{ChunkIndex: 1, Message: "invalid text property 'title'"},
+				{ChunkIndex: 2, Message: "vector lengths don't match; retry later"},
+			},
+		},
+		{
+			name: "missing and unknown results",
+			resp: `[
+				{"id":"00000000-0000-0000-0000-000000000000","class":"Document","result":{"status":"SUCCESS"}},
+				{"id":"ffffffff-0000-0000-0000-000000000000","class":"Document","result":{"errors":{"error":[{"message":"unknown"}]}}}]`,
+			want: []ChunkError{
+				{ChunkIndex: -1, Message: "unknown"},
+				{ChunkIndex: 1, Message: "no result returned for object"},
+				{ChunkIndex: 2, Message: "no result returned for object"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var resp []models.ObjectsGetResponse
+			if err := json.Unmarshal([]byte(tt.resp), &resp); err != nil {
+				t.Fatal(err)
+			}
+			got := batchObjectErrors(chunks, resp)
+			if tt.want == nil {
+				if got != nil {
+					t.Errorf("batchObjectErrors = %v, want nil", got)
+				}
+				return
+			}
+			if got == nil || got.Total != len(chunks) || fmt.Sprint(got.Failed) != fmt.Sprint(tt.want) {
+				t.Fatalf("batchObjectErrors = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBatchErrorMessage(t *testing.T) {
+	err := &BatchError{Total: 3, Failed: []ChunkError{{ChunkIndex: 1, Message: "invalid"}, {ChunkIndex: 2, Message: "timeout"}}}
+	if got, want := err.Error(), "failed to store 2 of 3 chunks (chunk 1: invalid)"; got != want {
+		t.Errorf("Error() = %q, want %q", got, want)
+	}
+}
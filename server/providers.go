@@ -0,0 +1,29 @@
+package main
+
+import (
+	"context"
+	"io"
+	"os"
+
+	"github.com/imaikosuke/iput-tokyo-ai/server/pkg/llm"
+)
+
+// 設定に応じて埋め込みと生成のプロバイダーを作成する。
+// 返されるio.Closerはサーバーの終了時に閉じる
+func newProviders(ctx context.Context, cfg *serverConfig) (llm.Embedder, llm.Generator, io.Closer, error) {
+	return llm.NewProviders(ctx, cfg.providerConfig())
+}
+
+// 設定からプロバイダーの設定を作成する
+func (cfg *serverConfig) providerConfig() llm.ProviderConfig {
+	return llm.ProviderConfig{
+		Provider:          cfg.LLMProvider,
+		GenerativeModel:   cfg.GenerativeModel,
+		EmbeddingModel:    cfg.EmbeddingModel,
+		GeminiAPIKey:      os.Getenv("GEMINI_API_KEY"),
+		OpenAIBaseURL:     cfg.OpenAIBaseURL,
+		OpenAIAPIKey:      cfg.OpenAIAPIKey,
+		LocalEmbeddingDim: cfg.LocalEmbeddingDim,
+		LocalAnswer:       cfg.LocalAnswer,
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"log"
+	"slices"
+
+	"github.com/imaikosuke/iput-tokyo-ai/server/pkg/bm25"
+	"github.com/imaikosuke/iput-tokyo-ai/server/pkg/vectorstore"
+)
+
+// 検索モード
+const (
+	searchModeHybrid = "hybrid" // ベクトルストアのBM25とベクトル検索を組み合わせる
+	searchModeVector = "vector" // ベクトル検索のみ
+	searchModeLocal  = "local"  // ベクトル検索の候補をプロセス内のBM25で再ランキングする
+)
+
+// searchOptionsは1回の検索に使う条件
+type searchOptions struct {
+	Filter    *vectorstore.Filter // nilでない場合は条件に一致するチャンクのみを検索する
+	TopK      int                 // 取得するチャンク数
+	Certainty float32             // ベクトル検索での類似度の閾値（vectorモードのみ）
+	Neighbors int                 // ヒットしたチャンクに追加する前後のチャンク数
+}
+
+// retrievalOptionsはリクエストごとに指定できる検索条件。
+// certaintyはベクトル検索の類似度の閾値で、hybridとlocalモードのスコアとは尺度が異なるため、vectorモード以外で指定すると400にする
+type retrievalOptions struct {
+	Filters   *queryFilters `json:"filters"`
+	TopK      *int          `json:"topK"`
+	Certainty *float32      `json:"certainty"`
+	Neighbors *int          `json:"neighbors"`
+}
+
+// リクエストの検索条件を検証し、未指定の値をサーバーの設定で補ってsearchOptionsを作成する
+func (o *retrievalOptions) resolve(cfg *serverConfig) (searchOptions, error) {
+	opts := searchOptions{TopK: cfg.TopK, Certainty: cfg.Certainty, Neighbors: cfg.NeighborWindow}
+	if err := o.Filters.validate(); err != nil {
+		return opts, err
+	}
+	opts.Filter = o.Filters.filter()
+
+	if o.TopK != nil {
+		if *o.TopK < 1 || *o.TopK > cfg.MaxTopK {
+			return opts, fmt.Errorf("topK must be between 1 and %d, got %d", cfg.MaxTopK, *o.TopK)
+		}
+		opts.TopK = *o.TopK
+	}
+	if o.Certainty != nil {
+		if cfg.SearchMode != searchModeVector {
+			return opts, fmt.Errorf("certainty is only supported in %s search mode (current mode: %s)", searchModeVector, cfg.SearchMode)
+		}
+		if *o.Certainty < 0 || *o.Certainty > 1 {
+			return opts, fmt.Errorf("certainty must be between 0 and 1, got %g", *o.Certainty)
+		}
+		opts.Certainty = *o.Certainty
+	}
+	if o.Neighbors != nil {
+		if *o.Neighbors < 0 || *o.Neighbors > maxNeighborWindow {
+			return opts, fmt.Errorf("neighbors must be between 0 and %d, got %d", maxNeighborWindow, *o.Neighbors)
+		}
+		opts.Neighbors = *o.Neighbors
+	}
+	return opts, nil
+}
+
+// 質問を埋め込み、ベクトルストアから関連するチャンクを取得する
+func (rs *ragServer) retrieveSources(ctx context.Context, query string, opts searchOptions) ([]Source, error) {
+	// クエリの埋め込み処理
+	var vector []float32
+	err := rs.runStage(ctx, stageEmbedding, func(ctx context.Context) error {
+		var err error
+		vector, err = rs.embedder.EmbedQuery(ctx, query)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var sources []Source
+	err = rs.runStage(ctx, stageRetrieval, func(ctx context.Context) error {
+		switch rs.cfg.SearchMode {
+		case searchModeVector:
+			sources, err = rs.vectorSearch(ctx, vector, opts.Filter, opts.TopK, opts.Certainty)
+		case searchModeLocal:
+			sources, err = rs.localHybridSearch(ctx, query, vector, opts)
+		default:
+			sources, err = rs.hybridSearch(ctx, query, vector, opts)
+			if err != nil && ctx.Err() == nil {
+				// ハイブリッド検索が使えない場合はプロセス内のBM25にフォールバックする
+				log.Printf("hybrid search failed, falling back to local BM25: %v", err)
+				sources, err = rs.localHybridSearch(ctx, query, vector, opts)
+			}
+		}
+		if err != nil {
+			return err
+		}
+		// ヒットしたチャンクに同じドキュメントの前後のチャンクを追加する
+		sources, err = rs.expandNeighbors(ctx, sources, opts.Neighbors, rs.cfg.NeighborTokenBudget)
+		return err
+	})
+	return sources, err
+}
+
+// ベクトルストアでの類似検索（上位limitチャンクを取得）
+func (rs *ragServer) vectorSearch(ctx context.Context, vector []float32, filter *vectorstore.Filter, limit int, certainty float32) ([]Source, error) {
+	results, err := rs.store.Search(ctx, vectorstore.SearchQuery{
+		Mode:      vectorstore.ModeVector,
+		Vector:    vector,
+		Limit:     limit,
+		Certainty: certainty,
+		Filter:    filter,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return sourcesFromResults(results), nil
+}
+
+// ベクトルストアのハイブリッドBM25+ベクトル検索
+func (rs *ragServer) hybridSearch(ctx context.Context, query string, vector []float32, opts searchOptions) ([]Source, error) {
+	results, err := rs.store.Search(ctx, vectorstore.SearchQuery{
+		Mode:   vectorstore.ModeHybrid,
+		Vector: vector,
+		Text:   query,
+		Alpha:  rs.cfg.HybridAlpha,
+		Limit:  opts.TopK,
+		Filter: opts.Filter,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return sourcesFromResults(results), nil
+}
+
+// ベクトル検索で取得した候補をプロセス内のBM25スコアと組み合わせて再ランキングする。
+// スコアはどちらも0〜1に正規化し、alphaの重みでベクトル側を、1-alphaでBM25側を合算する
+func (rs *ragServer) localHybridSearch(ctx context.Context, query string, vector []float32, opts searchOptions) ([]Source, error) {
+	candidates, err := rs.vectorSearch(ctx, vector, opts.Filter, max(rs.cfg.HybridCandidatePool, opts.TopK), 0)
+	if err != nil {
+		return nil, err
+	}
+
+	docs := make([]string, len(candidates))
+	vectorScores := make([]float64, len(candidates))
+	for i, c := range candidates {
+		docs[i] = c.Title + "\n" + c.Content
+		vectorScores[i] = c.Certainty
+	}
+	keywordScores := bm25.Normalize(bm25.NewIndex(docs, bm25.DefaultParams()).Score(query))
+	vectorScores = bm25.Normalize(vectorScores)
+
+	alpha := float64(rs.cfg.HybridAlpha)
+	for i := range candidates {
+		candidates[i].Score = alpha*vectorScores[i] + (1-alpha)*keywordScores[i]
+	}
+	slices.SortStableFunc(candidates, func(a, b Source) int {
+		return cmp.Compare(b.Score, a.Score)
+	})
+
+	if len(candidates) > opts.TopK {
+		candidates = candidates[:opts.TopK]
+	}
+	for i := range candidates {
+		candidates[i].Index = i + 1
+	}
+	return candidates, nil
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/imaikosuke/iput-tokyo-ai/server/pkg/chunking/utils"
+)
+
+// Turnは会話の1往復を表す
+type Turn struct {
+	Question  string    `json:"question"`
+	Answer    string    `json:"answer"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// SessionStoreは会話履歴を保存するストアのインターフェース
+type SessionStore interface {
+	// Historyはセッションの会話履歴を古い順に返す。存在しない場合は空を返す
+	History(ctx context.Context, sessionID string) ([]Turn, error)
+	// Appendはセッションに1ターンを追加する
+	Append(ctx context.Context, sessionID string, turn Turn) error
+}
+
+type memorySession struct {
+	turns     []Turn
+	expiresAt time.Time
+}
+
+// memorySessionStoreはTTL付きのインメモリなSessionStoreの実装
+type memorySessionStore struct {
+	mu        sync.Mutex
+	ttl       time.Duration
+	maxTurns  int
+	sessions  map[string]*memorySession
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+// newMemorySessionStoreは新しいmemorySessionStoreを作成する
+func newMemorySessionStore(ttl time.Duration, maxTurns int) *memorySessionStore {
+	return &memorySessionStore{
+		ttl:      ttl,
+		maxTurns: maxTurns,
+		sessions: make(map[string]*memorySession),
+		now:      time.Now,
+	}
+}
+
+func (s *memorySessionStore) History(ctx context.Context, sessionID string) ([]Turn, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sess, ok := s.sessions[sessionID]
+	if !ok || s.now().After(sess.expiresAt) {
+		delete(s.sessions, sessionID)
+		return nil, nil
+	}
+	turns := make([]Turn, len(sess.turns))
+	copy(turns, sess.turns)
+	return turns, nil
+}
+
+func (s *memorySessionStore) Append(ctx context.Context, sessionID string, turn Turn) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	s.sweep(now)
+
+	sess, ok := s.sessions[sessionID]
+	if !ok || now.After(sess.expiresAt) {
+		sess = &memorySession{}
+		s.sessions[sessionID] = sess
+	}
+	sess.turns = append(sess.turns, turn)
+	if s.maxTurns > 0 && len(sess.turns) > s.maxTurns {
+		sess.turns = sess.turns[len(sess.turns)-s.maxTurns:]
+	}
+	sess.expiresAt = now.Add(s.ttl)
+	return nil
+}
+
+// 期限切れのセッションを削除する。TTLごとに最大1回だけ実行する
+func (s *memorySessionStore) sweep(now time.Time) {
+	if now.Sub(s.lastSweep) < s.ttl {
+		return
+	}
+	for id, sess := range s.sessions {
+		if now.After(sess.expiresAt) {
+			delete(s.sessions, id)
+		}
+	}
+	s.lastSweep = now
+}
+
+// 新しいセッションIDを生成する
+func newSessionID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// 会話履歴をプロンプト用の文字列に変換する。
+// 新しいターンから順に、トークン数がbudgetを超えない範囲で含める
+func formatHistory(turns []Turn, budget int) string {
+	jp := utils.NewJapaneseProcessor()
+	var blocks []string
+	used := 0
+	for i := len(turns) - 1; i >= 0; i-- {
+		block := "ユーザー: " + turns[i].Question + "\nアシスタント: " + turns[i].Answer
+		tokens := jp.CountJapaneseTokens(block)
+		if used+tokens > budget {
+			break
+		}
+		used += tokens
+		blocks = append(blocks, block)
+	}
+
+	// 古い順に並べ直す
+	for i, j := 0, len(blocks)-1; i < j; i, j = i+1, j-1 {
+		blocks[i], blocks[j] = blocks[j], blocks[i]
+	}
+	return strings.Join(blocks, "\n\n")
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/imaikosuke/iput-tokyo-ai/server/pkg/chunking/utils"
+)
+
+func TestMemorySessionStore(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2024, 4, 1, 9, 0, 0, 0, time.UTC)
+	s := newMemorySessionStore(time.Minute, 2)
+	s.now = func() time.Time { return now }
+
+	for _, q := range []string{"q1", "q2", "q3"} {
+		if err := s.Append(ctx, "s1", Turn{Question: q}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 最大ターン数を超えた古いターンは削除される
+	history, err := s.History(ctx, "s1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 2 || history[0].Question != "q2" || history[1].Question != "q3" {
+		t.Errorf("history = %+v, want q2, q3", history)
+	}
+
+	// 返された履歴を変更してもストアには影響しない
+	history[0].Question = "changed"
+	if history, _ := s.History(ctx, "s1"); history[0].Question != "q2" {
+		t.Errorf("history was modified through the returned slice")
+	}
+
+	// 追加のたびに有効期限が延長される
+	now = now.Add(50 * time.Second)
+	s.Append(ctx, "s1", Turn{Question: "q4"})
+	now = now.Add(50 * time.Second)
+	if history, _ := s.History(ctx, "s1"); len(history) != 2 {
+		t.Errorf("history = %+v, want the session to be alive", history)
+	}
+
+	// 有効期限が切れたセッションは空になり、新しい会話として始まる
+	now = now.Add(2 * time.Minute)
+	if history, _ := s.History(ctx, "s1"); len(history) != 0 {
+		t.Errorf("expired history = %+v, want empty", history)
+	}
+	s.Append(ctx, "s1", Turn{Question: "q5"})
+	if history, _ := s.History(ctx, "s1"); len(history) != 1 || history[0].Question != "q5" {
+		t.Errorf("history = %+v, want only q5", history)
+	}
+}
+
+func TestMemorySessionStoreSweep(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2024, 4, 1, 9, 0, 0, 0, time.UTC)
+	s := newMemorySessionStore(time.Minute, 10)
+	s.now = func() time.Time { return now }
+
+	s.Append(ctx, "old", Turn{Question: "q"})
+	now = now.Add(2 * time.Minute)
+	s.Append(ctx, "new", Turn{Question: "q"})
+
+	if _, ok := s.sessions["old"]; ok {
+		t.Error("expired session was not swept")
+	}
+	if _, ok := s.sessions["new"]; !ok {
+		t.Error("new session is missing")
+	}
+}
+
+func TestFormatHistory(t *testing.T) {
+	turns := []Turn{
+		{Question: "最初の質問", Answer: "最初の回答"},
+		{Question: "次の質問", Answer: "次の回答"},
+	}
+
+	all := formatHistory(turns, 10000)
+	if !strings.HasPrefix(all, "ユーザー: 最初の質問") || !strings.HasSuffix(all, "アシスタント: 次の回答") {
+		t.Errorf("history = %q, want oldest first", all)
+	}
+
+	// 予算に収まらない場合は新しいターンを優先する
+	last := "ユーザー: 次の質問\nアシスタント: 次の回答"
+	budget := utils.NewJapaneseProcessor().CountJapaneseTokens(last)
+	if got := formatHistory(turns, budget); got != last {
+		t.Errorf("history = %q, want %q", got, last)
+	}
+	if got := formatHistory(turns, 0); got != "" {
+		t.Errorf("history = %q, want empty", got)
+	}
+}
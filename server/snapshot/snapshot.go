@@ -0,0 +1,242 @@
+// Package snapshot はベクトルストアのチャンクをベクトルを含めて書き出すスナップショットの形式を提供する。
+// スナップショットはgzipで圧縮したJSONLで、1行目がマニフェスト、2行目以降が1行に1つのオブジェクト
+package snapshot
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/imaikosuke/iput-tokyo-ai/server/ingest"
+	"github.com/imaikosuke/iput-tokyo-ai/server/pkg/vectorstore"
+)
+
+// スナップショットの形式の版
+const FormatVersion = 1
+
+// Manifestはスナップショットを作成したときのインデックスの情報。
+// 埋め込みモデルと設定のハッシュはチャンクに記録されているもので、復元するときに現在の設定と一致するかの確認に使う。
+// ChunkingとEmbedTemplateは書き出したときの設定で、ハッシュが一致しない場合にどの設定が異なるかを確認するために記録する
+type Manifest struct {
+	Format         int            `json:"format"`
+	CreatedAt      time.Time      `json:"createdAt"`
+	Class          string         `json:"class"`          // 書き出したクラス
+	SchemaVersion  int            `json:"schemaVersion"`  // 書き出したクラスのスキーマの版
+	EmbeddingModel string         `json:"embeddingModel"` // チャンクの埋め込みモデル（オブジェクトがない場合は空）
+	Dimension      int            `json:"dimension"`      // ベクトルの次元数（オブジェクトがない場合は0）
+	ChunkConfigs   map[string]int `json:"chunkConfigs"`   // チャンク分割の設定のハッシュごとのオブジェクト数
+	Objects        int            `json:"objects"`        // オブジェクト数
+
+	Chunking      ingest.ChunkSettings `json:"chunking"`      // 書き出したときのチャンク分割の設定
+	EmbedTemplate string               `json:"embedTemplate"` // 書き出したときの埋め込み用テキストのテンプレート
+}
+
+// Objectはスナップショットの1行。プロパティ名はWeaviateのDocumentクラスと同じ
+type Object struct {
+	ID         string     `json:"id"`
+	Properties Properties `json:"properties"`
+	Vector     []float32  `json:"vector"`
+}
+
+// PropertiesはDocumentクラスのプロパティ
+type Properties struct {
+	DocumentID  string   `json:"documentId"`
+	Title       string   `json:"title"`
+	Content     string   `json:"content"`
+	Category    string   `json:"category"`
+	Tags        []string `json:"tags"`
+	Department  string   `json:"department"`
+	UpdatedAt   string   `json:"updatedAt"`
+	ChunkIndex  int      `json:"chunkIndex"`
+	TotalChunks int      `json:"totalChunks"`
+	StartChar   int      `json:"startChar"`
+	EndChar     int      `json:"endChar"`
+	TokenCount  int      `json:"tokenCount"`
+	Precedence  int      `json:"precedence"`
+	Headings    []string `json:"headings"`
+	SectionPath string   `json:"sectionPath"`
+	ContentHash string   `json:"contentHash"`
+
+	EmbeddingModel string `json:"embeddingModel"`
+	EmbeddingDim   int    `json:"embeddingDim"`
+	ChunkConfig    string `json:"chunkConfig"`
+	IngestedAt     string `json:"ingestedAt"`
+}
+
+func objectFromChunk(c vectorstore.Chunk) Object {
+	return Object{
+		ID: c.UUID,
+		Properties: Properties{
+			DocumentID:  c.DocumentID,
+			Title:       c.Title,
+			Content:     c.Content,
+			Category:    c.Category,
+			Tags:        c.Tags,
+			Department:  c.Department,
+			UpdatedAt:   c.UpdatedAt,
+			ChunkIndex:  c.ChunkIndex,
+			TotalChunks: c.TotalChunks,
+			StartChar:   c.StartChar,
+			EndChar:     c.EndChar,
+			TokenCount:  c.TokenCount,
+			Precedence:  c.Precedence,
+			Headings:    c.Headings,
+			SectionPath: c.SectionPath,
+			ContentHash: c.ContentHash,
+
+			EmbeddingModel: c.EmbeddingModel,
+			EmbeddingDim:   c.EmbeddingDim,
+			ChunkConfig:    c.ChunkConfig,
+			IngestedAt:     c.IngestedAt,
+		},
+		Vector: c.Vector,
+	}
+}
+
+func (o Object) chunk() vectorstore.Chunk {
+	p := o.Properties
+	return vectorstore.Chunk{
+		UUID:        o.ID,
+		DocumentID:  p.DocumentID,
+		Title:       p.Title,
+		Content:     p.Content,
+		Category:    p.Category,
+		Tags:        p.Tags,
+		Department:  p.Department,
+		UpdatedAt:   p.UpdatedAt,
+		ChunkIndex:  p.ChunkIndex,
+		TotalChunks: p.TotalChunks,
+		StartChar:   p.StartChar,
+		EndChar:     p.EndChar,
+		TokenCount:  p.TokenCount,
+		Precedence:  p.Precedence,
+		Headings:    p.Headings,
+		SectionPath: p.SectionPath,
+		ContentHash: p.ContentHash,
+
+		EmbeddingModel: p.EmbeddingModel,
+		EmbeddingDim:   p.EmbeddingDim,
+		ChunkConfig:    p.ChunkConfig,
+		IngestedAt:     p.IngestedAt,
+
+		Vector: o.Vector,
+	}
+}
+
+// Writerはスナップショットを書き出す。マニフェストは最初のチャンクのベクトルの次元数を設定してから書き出す
+type Writer struct {
+	manifest Manifest
+	gz       *gzip.Writer
+	enc      *json.Encoder
+	started  bool
+	written  int
+}
+
+// NewWriterはwにスナップショットを書き出すWriterを作成する。書き終わったらCloseを呼ぶ
+func NewWriter(w io.Writer, manifest Manifest) *Writer {
+	manifest.Format = FormatVersion
+	gz := gzip.NewWriter(w)
+	return &Writer{manifest: manifest, gz: gz, enc: json.NewEncoder(gz)}
+}
+
+// Writeはチャンクを1行書き出す。次元数がマニフェストと異なるチャンクはエラーにする
+func (w *Writer) Write(c vectorstore.Chunk) error {
+	if w.manifest.Dimension == 0 {
+		w.manifest.Dimension = len(c.Vector)
+	}
+	if len(c.Vector) != w.manifest.Dimension {
+		return fmt.Errorf("object %s has %d dimensions, expected %d", c.UUID, len(c.Vector), w.manifest.Dimension)
+	}
+	if err := w.writeManifest(); err != nil {
+		return err
+	}
+	if err := w.enc.Encode(objectFromChunk(c)); err != nil {
+		return fmt.Errorf("writing object %s: %w", c.UUID, err)
+	}
+	w.written++
+	return nil
+}
+
+// Closeはマニフェストを書き出していなければ書き出し、圧縮を終える。
+// 書き出したオブジェクト数がマニフェストと異なる場合はエラーを返す
+func (w *Writer) Close() error {
+	if err := w.writeManifest(); err != nil {
+		return err
+	}
+	if err := w.gz.Close(); err != nil {
+		return fmt.Errorf("compressing snapshot: %w", err)
+	}
+	if w.written != w.manifest.Objects {
+		return fmt.Errorf("wrote %d objects, manifest says %d (was the index changed during export?)", w.written, w.manifest.Objects)
+	}
+	return nil
+}
+
+// Manifestは書き出すマニフェストを返す
+func (w *Writer) Manifest() Manifest {
+	return w.manifest
+}
+
+func (w *Writer) writeManifest() error {
+	if w.started {
+		return nil
+	}
+	w.started = true
+	if err := w.enc.Encode(w.manifest); err != nil {
+		return fmt.Errorf("writing manifest: %w", err)
+	}
+	return nil
+}
+
+// Readerはスナップショットを1行ずつ読み込む
+type Reader struct {
+	manifest Manifest
+	dec      *json.Decoder
+	read     int
+}
+
+// NewReaderはrからスナップショットを読み込むReaderを作成し、マニフェストを読み込む
+func NewReader(r io.Reader) (*Reader, error) {
+	gz, err := gzip.NewReader(bufio.NewReader(r))
+	if err != nil {
+		return nil, fmt.Errorf("reading snapshot: %w", err)
+	}
+	dec := json.NewDecoder(gz)
+	var manifest Manifest
+	if err := dec.Decode(&manifest); err != nil {
+		return nil, fmt.Errorf("reading manifest: %w", err)
+	}
+	if manifest.Format != FormatVersion {
+		return nil, fmt.Errorf("unsupported snapshot format %d (expected %d)", manifest.Format, FormatVersion)
+	}
+	return &Reader{manifest: manifest, dec: dec}, nil
+}
+
+// Manifestはスナップショットのマニフェストを返す
+func (r *Reader) Manifest() Manifest {
+	return r.manifest
+}
+
+// Nextは次のチャンクを返す。すべて読み込んだ場合はio.EOFを返す。
+// オブジェクト数や次元数がマニフェストと異なる場合はエラーを返す
+func (r *Reader) Next() (vectorstore.Chunk, error) {
+	var obj Object
+	if err := r.dec.Decode(&obj); err != nil {
+		if errors.Is(err, io.EOF) {
+			if r.read != r.manifest.Objects {
+				return vectorstore.Chunk{}, fmt.Errorf("snapshot has %d objects, manifest says %d", r.read, r.manifest.Objects)
+			}
+			return vectorstore.Chunk{}, io.EOF
+		}
+		return vectorstore.Chunk{}, fmt.Errorf("reading object %d: %w", r.read+1, err)
+	}
+	if len(obj.Vector) != r.manifest.Dimension {
+		return vectorstore.Chunk{}, fmt.Errorf("object %s has %d dimensions, manifest says %d", obj.ID, len(obj.Vector), r.manifest.Dimension)
+	}
+	r.read++
+	return obj.chunk(), nil
+}
@@ -0,0 +1,181 @@
+package snapshot
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/imaikosuke/iput-tokyo-ai/server/ingest"
+	"github.com/imaikosuke/iput-tokyo-ai/server/pkg/llm"
+	"github.com/imaikosuke/iput-tokyo-ai/server/pkg/vectorstore"
+	"github.com/imaikosuke/iput-tokyo-ai/server/universitydocs"
+)
+
+// パイプラインで2つのドキュメントを登録したMemoryStoreを作成する
+func newTestStore(t *testing.T) *vectorstore.MemoryStore {
+	t.Helper()
+	store := vectorstore.NewMemoryStore()
+	chunker, err := ingest.NewChunker()
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &ingest.Pipeline{Chunker: chunker, Embedder: llm.NewLocalEmbedder(16), Store: store}
+	docs := []universitydocs.Document{
+		{ID: "office-hours", Title: "オフィスアワー", Category: "授業", Tags: []string{"教員"}, Content: "# 授業時間等\n\n## オフィスアワー\n\nオフィスアワーは毎週水曜日の午後です。"},
+		{ID: "library", Title: "図書館", Category: "施設", Content: "# 図書館\n\n## 開館時間\n\n図書館は平日9時から20時まで開館しています。"},
+	}
+	for _, doc := range docs {
+		if _, err := p.Upsert(context.Background(), doc); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return store
+}
+
+// MemoryStoreのすべてのチャンクをスナップショットに書き出す
+func export(t *testing.T, store *vectorstore.MemoryStore, objects int) []byte {
+	t.Helper()
+	ctx := context.Background()
+	profile, _ := store.Profile(ctx)
+	var buf bytes.Buffer
+	w := NewWriter(&buf, Manifest{
+		Class:          "Document_v1",
+		EmbeddingModel: "local-hash",
+		ChunkConfigs:   profile.ChunkConfigs,
+		Objects:        objects,
+		Chunking:       ingest.Chunking,
+		EmbedTemplate:  ingest.DefaultEmbedTemplate,
+	})
+	docs, _ := store.ListDocuments(ctx)
+	for _, doc := range docs {
+		chunks, _ := store.GetDocument(ctx, doc.ID)
+		for _, c := range chunks {
+			if err := w.Write(c); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := w.Close(); err != nil && objects == profile.Objects {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	source := newTestStore(t)
+	profile, _ := source.Profile(ctx)
+	data := export(t, source, profile.Objects)
+
+	// gzipで圧縮したJSONLで、1行目がマニフェスト、2行目以降がオブジェクト
+	gz, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, _ := io.ReadAll(gz)
+	lines := strings.Split(strings.TrimSpace(string(raw)), "\n")
+	if len(lines) != profile.Objects+1 {
+		t.Fatalf("snapshot has %d lines, want %d", len(lines), profile.Objects+1)
+	}
+	var first map[string]any
+	if err := json.Unmarshal([]byte(lines[0]), &first); err != nil {
+		t.Fatal(err)
+	}
+	if first["format"] != float64(FormatVersion) || first["embedTemplate"] != ingest.DefaultEmbedTemplate || first["chunking"] == nil {
+		t.Errorf("manifest line = %s", lines[0])
+	}
+
+	r, err := NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifest := r.Manifest()
+	if manifest.Objects != profile.Objects || manifest.Dimension != 16 || manifest.EmbeddingModel != "local-hash" || manifest.Chunking != ingest.Chunking {
+		t.Errorf("manifest = %+v", manifest)
+	}
+
+	restored := vectorstore.NewMemoryStore()
+	byDocument := map[string][]vectorstore.Chunk{}
+	for {
+		c, err := r.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		byDocument[c.DocumentID] = append(byDocument[c.DocumentID], c)
+	}
+	for id, chunks := range byDocument {
+		if err := restored.UpsertDocument(ctx, id, chunks); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	docs, _ := source.ListDocuments(ctx)
+	for _, doc := range docs {
+		want, _ := source.GetDocument(ctx, doc.ID)
+		got, _ := restored.GetDocument(ctx, doc.ID)
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: restored chunks = %+v, want %+v", doc.ID, got, want)
+		}
+	}
+	if got, _ := restored.Profile(ctx); !reflect.DeepEqual(got, profile) {
+		t.Errorf("restored profile = %+v, want %+v", got, profile)
+	}
+}
+
+func TestObjectCountMismatch(t *testing.T) {
+	source := newTestStore(t)
+	profile, _ := source.Profile(context.Background())
+
+	// マニフェストより多いオブジェクトを書き出した場合はCloseがエラーを返す
+	var buf bytes.Buffer
+	w := NewWriter(&buf, Manifest{Objects: 0})
+	w.Write(vectorstore.Chunk{UUID: "x", Vector: []float32{1}})
+	if err := w.Close(); err == nil {
+		t.Error("Close succeeded with more objects than the manifest")
+	}
+
+	// マニフェストよりオブジェクトが少ないスナップショットは最後まで読むとエラーになる
+	data := export(t, source, profile.Objects+1)
+	r, err := NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range profile.Objects {
+		if _, err := r.Next(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := r.Next(); err == nil || errors.Is(err, io.EOF) {
+		t.Errorf("Next = %v, want an object count error", err)
+	}
+}
+
+func TestDimensionMismatch(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf, Manifest{Objects: 2})
+	if err := w.Write(vectorstore.Chunk{UUID: "a", Vector: []float32{1, 0}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Write(vectorstore.Chunk{UUID: "b", Vector: []float32{1}}); err == nil {
+		t.Error("Write accepted a chunk with a different dimension")
+	}
+}
+
+func TestUnsupportedFormat(t *testing.T) {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	json.NewEncoder(gz).Encode(Manifest{Format: FormatVersion + 1})
+	gz.Close()
+	if _, err := NewReader(&buf); err == nil {
+		t.Error("NewReader accepted an unsupported format")
+	}
+}
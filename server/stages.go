@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/imaikosuke/iput-tokyo-ai/server/ingest"
+)
+
+// リクエスト処理の段階。段階ごとに個別のタイムアウトを設定する
+const (
+	stageEmbedding  = ingest.StageEmbedding // 質問やチャンクの埋め込み
+	stageRetrieval  = "retrieval"           // ベクトルストアからの検索
+	stageGeneration = "generation"          // 生成モデルによる回答の生成
+	stageStorage    = ingest.StageStorage   // ベクトルストアへの書き込みと削除
+)
+
+// stageErrorは処理の段階で発生したエラー
+type stageError struct {
+	stage    string
+	timedOut bool
+	err      error
+}
+
+func (e *stageError) Error() string {
+	if e.timedOut {
+		return fmt.Sprintf("%s timed out: %v", e.stage, e.err)
+	}
+	return fmt.Sprintf("%s: %v", e.stage, e.err)
+}
+
+func (e *stageError) Unwrap() error {
+	return e.err
+}
+
+// 段階ごとのタイムアウトを返す
+func (rs *ragServer) stageTimeout(stage string) time.Duration {
+	switch stage {
+	case stageEmbedding:
+		return rs.cfg.EmbedTimeout
+	case stageRetrieval:
+		return rs.cfg.RetrieveTimeout
+	case stageGeneration:
+		return rs.cfg.GenerateTimeout
+	default:
+		return rs.cfg.StoreTimeout
+	}
+}
+
+// 段階のタイムアウトを設定したコンテキストでfnを実行する。
+// エラーはstageErrorで包み、段階のタイムアウトによるものかを記録する
+func (rs *ragServer) runStage(ctx context.Context, stage string, fn func(ctx context.Context) error) error {
+	stageCtx, cancel := context.WithTimeout(ctx, rs.stageTimeout(stage))
+	defer cancel()
+
+	err := fn(stageCtx)
+	if err == nil {
+		return nil
+	}
+	// 親のコンテキストが生きていて段階の期限だけが切れた場合をタイムアウトとみなす。
+	// gRPCのエラーはcontext.DeadlineExceededを包まないため、コンテキストの状態で判定する
+	timedOut := ctx.Err() == nil && errors.Is(stageCtx.Err(), context.DeadlineExceeded)
+	return &stageError{stage: stage, timedOut: timedOut, err: err}
+}
+
+// エラーに対応するHTTPステータスコードを返す。段階のタイムアウトは504、それ以外は500
+func errorStatus(err error) int {
+	var se *stageError
+	if errors.As(err, &se) && se.timedOut {
+		return http.StatusGatewayTimeout
+	}
+	return http.StatusInternalServerError
+}
+
+// エラーをHTTPレスポンスとして返す。
+// 段階のタイムアウトは504、クライアントの切断はレスポンスを書かずにログのみ残す
+func writeError(w http.ResponseWriter, req *http.Request, err error) {
+	if req.Context().Err() != nil {
+		log.Printf("request canceled by client: %v", err)
+		return
+	}
+
+	var se *stageError
+	if errors.As(err, &se) {
+		if se.timedOut {
+			log.Print(err)
+			w.Header().Set("X-Timeout-Stage", se.stage)
+			http.Error(w, fmt.Sprintf("%s timed out", se.stage), http.StatusGatewayTimeout)
+			return
+		}
+		if se.stage == stageGeneration {
+			log.Printf("calling generative model: %v", err)
+			http.Error(w, "generative model error", http.StatusInternalServerError)
+			return
+		}
+	}
+	http.Error(w, err.Error(), http.StatusInternalServerError)
+}
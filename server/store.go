@@ -0,0 +1,29 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/imaikosuke/iput-tokyo-ai/server/internal/envconfig"
+	"github.com/imaikosuke/iput-tokyo-ai/server/pkg/vectorstore"
+)
+
+// ベクトルストアの種類
+const (
+	storeWeaviate = "weaviate" // Weaviateに保存する
+	storeMemory   = "memory"   // プロセス内のメモリに保存する（再起動で消える）
+)
+
+// 設定に応じてベクトルストアを初期化する
+func newVectorStore(ctx context.Context, cfg *serverConfig) (vectorstore.VectorStore, error) {
+	switch cfg.VectorStore {
+	case storeWeaviate:
+		return vectorstore.NewWeaviateStore(ctx, envconfig.Weaviate("weaviate"))
+	case storeMemory:
+		log.Printf("Warning: using in-memory vector store, documents are lost on restart")
+		return vectorstore.NewMemoryStore(), nil
+	default:
+		return nil, fmt.Errorf("unknown VECTOR_STORE %q (expected %q or %q)", cfg.VectorStore, storeWeaviate, storeMemory)
+	}
+}
@@ -0,0 +1,113 @@
+package universitydocs
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// LoadDirectoryは引数の`dir`で指定されたディレクトリ内のMarkdownファイルを処理して`Document`のスライスを返す
+func LoadDirectory(dir string) ([]Document, error) {
+	var docs []Document
+
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if !info.IsDir() && strings.HasSuffix(path, ".md") {
+			rel, err := filepath.Rel(dir, path)
+			if err != nil {
+				return err
+			}
+			doc, err := LoadMarkdownFile(dir, rel)
+			if err != nil {
+				return err
+			}
+			docs = append(docs, doc)
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return docs, nil
+}
+
+// LoadMarkdownFileは`dir`からの相対パス`rel`のMarkdownファイルを読み込む。
+// ソースは相対パスで記録し、IDが指定されていない場合はパスから導出する
+func LoadMarkdownFile(dir, rel string) (Document, error) {
+	path := filepath.Join(dir, rel)
+	doc, err := ParseMarkdownFile(path)
+	if err != nil {
+		return Document{}, fmt.Errorf("processing %s: %w", path, err)
+	}
+	doc.Source = filepath.ToSlash(rel)
+	if doc.ID == "" {
+		doc.ID = IDFromPath(rel)
+	}
+	return doc, nil
+}
+
+// ParseMarkdownFileは引数の`filename`で指定されたフロントマター付きのMarkdownファイルを処理して`Document`を返す
+func ParseMarkdownFile(filename string) (Document, error) {
+	content, err := os.ReadFile(filename)
+	if err != nil {
+		return Document{}, err
+	}
+
+	parts := strings.Split(string(content), "---")
+	if len(parts) < 3 {
+		return Document{}, fmt.Errorf("invalid markdown format in %s", filename)
+	}
+
+	var doc Document
+	err = yaml.Unmarshal([]byte(parts[1]), &doc)
+	if err != nil {
+		return Document{}, fmt.Errorf("parsing frontmatter: %w", err)
+	}
+
+	// 更新日が指定されていない場合はファイルの更新日時を使用する。
+	// 現在時刻にすると内容のハッシュが日ごとに変わり、変更のないドキュメントも登録し直すことになる
+	if doc.UpdatedAt == "" {
+		info, err := os.Stat(filename)
+		if err != nil {
+			return Document{}, err
+		}
+		doc.UpdatedAt = info.ModTime().Format(time.DateOnly)
+	}
+
+	// Markdownコンテンツの取得（3番目のパート以降を結合）
+	doc.Content = strings.TrimSpace(strings.Join(parts[2:], "---"))
+
+	return doc, nil
+}
+
+// LoadはMarkdownのディレクトリ、またはmdconvertが出力したJSONファイルからドキュメントを読み込む
+func Load(path string) ([]Document, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return LoadDirectory(path)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var req AddDocumentsRequest
+	if err := json.Unmarshal(data, &req); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	return req.Documents, nil
+}
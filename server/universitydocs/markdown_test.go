@@ -0,0 +1,48 @@
+package universitydocs
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestParseMarkdownFileUpdatedAt(t *testing.T) {
+	dir := t.TempDir()
+	modified := time.Date(2024, 4, 1, 12, 0, 0, 0, time.Local)
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, modified, modified); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	tests := []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{name: "dated.md", content: "---\ntitle: 日付あり\nupdated_at: 2023-10-01\n---\n本文", want: "2023-10-01"},
+		{name: "undated.md", content: "---\ntitle: 日付なし\n---\n本文", want: "2024-04-01"},
+	}
+	for _, tt := range tests {
+		path := write(tt.name, tt.content)
+		doc, err := ParseMarkdownFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if doc.UpdatedAt != tt.want {
+			t.Errorf("%s: UpdatedAt = %q, want %q", tt.name, doc.UpdatedAt, tt.want)
+		}
+
+		// 変更のないファイルは何度読み込んでも同じハッシュになる
+		again, _ := ParseMarkdownFile(path)
+		if again.ContentHash() != doc.ContentHash() {
+			t.Errorf("%s: ContentHash changed between loads", tt.name)
+		}
+	}
+}
@@ -1,9 +1,24 @@
 package universitydocs
 
-import "github.com/weaviate/weaviate/entities/models"
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/weaviate/weaviate/entities/models"
+)
+
+// チャンクのUUIDを決定的に生成するための名前空間
+var chunkNamespace = uuid.MustParse("6f1c1a52-4d0e-4c1b-9a57-2f6b1e0c8d3a")
 
 type Document struct {
-	// ID         string   `json:"id" yaml:"id"`
+	ID         string   `json:"id" yaml:"id"`
+	Source     string   `json:"source,omitempty" yaml:"-"` // 元ファイルのパス（content/からの相対パス）
 	Title      string   `json:"title" yaml:"title"`
 	Content    string   `json:"content" yaml:"content"`
 	Category   string   `json:"category" yaml:"category"`
@@ -14,6 +29,61 @@ type Document struct {
 
 type AddDocumentsRequest struct {
 	Documents []Document `json:"documents"`
+	// ContinueOnErrorがtrueの場合、登録に失敗したドキュメントがあっても残りのドキュメントの登録を続ける
+	ContinueOnError bool `json:"continueOnError"`
+	// Asyncがtrueの場合、ドキュメントを非同期のジョブとして登録し、ジョブIDをすぐに返す
+	Async bool `json:"async"`
+}
+
+// ErrMissingIDはドキュメントにIDも元ファイルのパスもないことを表す
+var ErrMissingID = errors.New("document has no id or source")
+
+// DocumentIDはドキュメントの安定したIDを返す。
+// IDが指定されていない場合は元ファイルのパスから導出し、どちらもない場合は空を返す。
+// タイトルは重複しうるため、IDには使わない
+func (d Document) DocumentID() string {
+	if d.ID != "" {
+		return d.ID
+	}
+	if d.Source != "" {
+		return IDFromPath(d.Source)
+	}
+	return ""
+}
+
+// ValidateIDsはすべてのドキュメントのIDが空でないかを確認する
+func ValidateIDs(docs []Document) error {
+	for i, doc := range docs {
+		if doc.DocumentID() == "" {
+			return fmt.Errorf("documents[%d] (title %q): %w", i, doc.Title, ErrMissingID)
+		}
+	}
+	return nil
+}
+
+// ContentHashはドキュメントの内容とメタデータから計算したハッシュを返す。
+// 登録済みのドキュメントから変更されたかを判定するために使う
+func (d Document) ContentHash() string {
+	h := sha256.New()
+	for _, field := range []string{d.Title, d.Content, d.Category, strings.Join(d.Tags, "\x1f"), d.Department, d.UpdatedAt} {
+		h.Write([]byte(field))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// IDFromPathはファイルパスから拡張子を除いたスラッシュ区切りのIDを返す
+// 例: "academic/class-hours.md" -> "academic/class-hours"
+func IDFromPath(path string) string {
+	path = filepath.ToSlash(filepath.Clean(path))
+	path = strings.TrimPrefix(path, "./")
+	return strings.TrimSuffix(path, filepath.Ext(path))
+}
+
+// ChunkUUIDはドキュメントIDとチャンク番号から決定的なUUIDを生成する。
+// 同じドキュメントを再登録すると、同じ位置のチャンクは同じオブジェクトを上書きする
+func ChunkUUID(documentID string, chunkIndex int) string {
+	return uuid.NewSHA1(chunkNamespace, []byte(documentID+"#"+strconv.Itoa(chunkIndex))).String()
 }
 
 // Weaviateへの保存用にドキュメントを変換する関数
@@ -21,7 +91,7 @@ func ConvertToWeaviateObject(doc Document) *models.Object {
 	return &models.Object{
 		Class: "Document",
 		Properties: map[string]any{
-			// "id":         doc.ID,
+			"documentId": doc.DocumentID(),
 			"title":      doc.Title,
 			"content":    doc.Content,
 			"category":   doc.Category,
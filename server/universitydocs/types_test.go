@@ -0,0 +1,34 @@
+package universitydocs
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestDocumentID(t *testing.T) {
+	tests := []struct {
+		doc  Document
+		want string
+	}{
+		{Document{ID: "office-hours", Source: "academic/office.md", Title: "T"}, "office-hours"},
+		{Document{Source: "academic/office.md", Title: "T"}, "academic/office"},
+		{Document{Source: "./academic/office.md"}, "academic/office"},
+		// タイトルは重複しうるため、IDにしない
+		{Document{Title: "T"}, ""},
+	}
+	for _, tt := range tests {
+		if got := tt.doc.DocumentID(); got != tt.want {
+			t.Errorf("DocumentID(%+v) = %q, want %q", tt.doc, got, tt.want)
+		}
+	}
+}
+
+func TestValidateIDs(t *testing.T) {
+	if err := ValidateIDs([]Document{{ID: "a"}, {Source: "b.md"}}); err != nil {
+		t.Errorf("ValidateIDs = %v, want nil", err)
+	}
+	err := ValidateIDs([]Document{{ID: "a"}, {Title: "無題"}})
+	if !errors.Is(err, ErrMissingID) {
+		t.Errorf("ValidateIDs = %v, want ErrMissingID", err)
+	}
+}
@@ -0,0 +1,165 @@
+package universitydocs
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Changeは監視しているディレクトリでまとめて検出された変更
+type Change struct {
+	Updated []Document // 作成または変更されたファイルのドキュメント
+	Removed []string   // 削除されたファイル（またはIDが変更されたファイルの古いID）のドキュメントID
+	Errors  []error    // 読み込めなかったファイルのエラー
+}
+
+// Watcherはディレクトリ内のMarkdownファイルを定期的に走査し、作成・変更・削除を検出する。
+// Dockerのバインドマウントではファイルの変更通知が届かないことがあるため、ポーリングで検出する
+type Watcher struct {
+	Dir      string        // 監視するディレクトリ
+	Interval time.Duration // 走査の間隔
+	Debounce time.Duration // 最後の変更からこの時間が経つまで通知を待ち、連続した変更をまとめる
+
+	stamps map[string]fileStamp // 変更の検出の基準にするファイルの更新情報
+	ids    map[string]string    // ファイルごとのドキュメントID（削除されたファイルのIDを知るために使う）
+}
+
+// ファイルの変更の検出に使う情報
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+// Baselineは現在のファイルを変更の検出の基準として記録する。
+// 最初の登録の前に呼ぶと、登録中に変更されたファイルもRunで変更として検出される
+func (w *Watcher) Baseline() error {
+	stamps, err := w.scan()
+	if err != nil {
+		return err
+	}
+	ids := make(map[string]string, len(stamps))
+	for rel := range stamps {
+		if doc, err := LoadMarkdownFile(w.Dir, rel); err == nil {
+			ids[rel] = doc.DocumentID()
+		}
+	}
+	w.stamps, w.ids = stamps, ids
+	return nil
+}
+
+// Runはctxがキャンセルされるまでディレクトリを監視し、変更をまとめてfnに渡す。
+// Baselineで記録した時点（呼んでいない場合は開始時点）のファイルは変更として扱わない
+func (w *Watcher) Run(ctx context.Context, fn func(ctx context.Context, change Change)) error {
+	if w.stamps == nil {
+		if err := w.Baseline(); err != nil {
+			return err
+		}
+	}
+	stamps, ids := w.stamps, w.ids
+
+	ticker := time.NewTicker(w.Interval)
+	defer ticker.Stop()
+	dirty := make(map[string]bool)
+	var lastChange time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+
+		current, err := w.scan()
+		if err != nil {
+			fn(ctx, Change{Errors: []error{err}})
+			continue
+		}
+		for rel, stamp := range current {
+			if prev, ok := stamps[rel]; !ok || prev != stamp {
+				dirty[rel] = true
+				lastChange = time.Now()
+			}
+		}
+		for rel := range stamps {
+			if _, ok := current[rel]; !ok {
+				dirty[rel] = true
+				lastChange = time.Now()
+			}
+		}
+		stamps = current
+
+		if len(dirty) == 0 || time.Since(lastChange) < w.Debounce {
+			continue
+		}
+		paths := make([]string, 0, len(dirty))
+		for rel := range dirty {
+			paths = append(paths, rel)
+		}
+		slices.Sort(paths)
+		fn(ctx, w.collect(paths, stamps, ids))
+		clear(dirty)
+	}
+}
+
+// 変更されたファイルを読み込み、通知する変更を作成する。idsは読み込んだ結果で更新する
+func (w *Watcher) collect(paths []string, stamps map[string]fileStamp, ids map[string]string) Change {
+	var change Change
+	for _, rel := range paths {
+		oldID, known := ids[rel]
+		if _, exists := stamps[rel]; !exists {
+			if known {
+				change.Removed = append(change.Removed, oldID)
+				delete(ids, rel)
+			}
+			continue
+		}
+
+		doc, err := LoadMarkdownFile(w.Dir, rel)
+		if err != nil {
+			change.Errors = append(change.Errors, err)
+			continue
+		}
+		id := doc.DocumentID()
+		if known && oldID != id {
+			change.Removed = append(change.Removed, oldID)
+		}
+		ids[rel] = id
+		change.Updated = append(change.Updated, doc)
+	}
+	return change
+}
+
+// ディレクトリ内のMarkdownファイルの相対パスと更新情報を返す
+func (w *Watcher) scan() (map[string]fileStamp, error) {
+	stamps := make(map[string]fileStamp)
+	err := filepath.WalkDir(w.Dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || !strings.HasSuffix(path, ".md") {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			// 走査中に削除されたファイルは次の走査で削除として扱う
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		rel, err := filepath.Rel(w.Dir, path)
+		if err != nil {
+			return err
+		}
+		stamps[rel] = fileStamp{modTime: info.ModTime(), size: info.Size()}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("scanning %s: %w", w.Dir, err)
+	}
+	return stamps, nil
+}
@@ -0,0 +1,116 @@
+package universitydocs
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeDoc(t *testing.T, dir, name, title string) {
+	t.Helper()
+	content := "---\ntitle: " + title + "\nupdated_at: 2024-04-01\n---\n本文"
+	if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Watcherを開始し、通知された変更を受け取るチャネルを返す
+func startWatcher(t *testing.T, w *Watcher) <-chan Change {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	changes := make(chan Change, 10)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		w.Run(ctx, func(ctx context.Context, change Change) { changes <- change })
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	return changes
+}
+
+func nextChange(t *testing.T, changes <-chan Change) Change {
+	t.Helper()
+	select {
+	case change := <-changes:
+		return change
+	case <-time.After(5 * time.Second):
+		t.Fatal("no change was notified")
+		return Change{}
+	}
+}
+
+func expectNoChange(t *testing.T, changes <-chan Change, wait time.Duration) {
+	t.Helper()
+	select {
+	case change := <-changes:
+		t.Errorf("unexpected change %+v", change)
+	case <-time.After(wait):
+	}
+}
+
+func documentIDs(docs []Document) []string {
+	ids := make([]string, len(docs))
+	for i, doc := range docs {
+		ids[i] = doc.DocumentID()
+	}
+	return ids
+}
+
+func TestWatcher(t *testing.T) {
+	dir := t.TempDir()
+	writeDoc(t, dir, "existing.md", "既存")
+	if err := os.Mkdir(filepath.Join(dir, "sub"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	const debounce = 200 * time.Millisecond
+	w := &Watcher{Dir: dir, Interval: 10 * time.Millisecond, Debounce: debounce}
+	if err := w.Baseline(); err != nil {
+		t.Fatal(err)
+	}
+	changes := startWatcher(t, w)
+
+	// 開始時点からあるファイルは変更として通知しない
+	expectNoChange(t, changes, 3*debounce/2)
+
+	// 連続した変更は1回にまとめて通知する
+	writeDoc(t, dir, "a.md", "A")
+	writeDoc(t, dir, "sub/b.md", "B")
+	writeDoc(t, dir, "a.md", "A（改訂）")
+	os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("Markdown以外"), 0o644)
+
+	change := nextChange(t, changes)
+	if got := documentIDs(change.Updated); len(got) != 2 || got[0] != "a" || got[1] != "sub/b" {
+		t.Errorf("updated = %v, want [a sub/b]", got)
+	}
+	if len(change.Updated) == 2 && change.Updated[0].Title != "A（改訂）" {
+		t.Errorf("title = %q, want the latest version", change.Updated[0].Title)
+	}
+	if len(change.Removed) != 0 || len(change.Errors) != 0 {
+		t.Errorf("change = %+v, want only updates", change)
+	}
+	expectNoChange(t, changes, 3*debounce/2)
+
+	// 削除されたファイルはドキュメントIDで通知する
+	if err := os.Remove(filepath.Join(dir, "sub/b.md")); err != nil {
+		t.Fatal(err)
+	}
+	change = nextChange(t, changes)
+	if len(change.Updated) != 0 || len(change.Removed) != 1 || change.Removed[0] != "sub/b" {
+		t.Errorf("change = %+v, want sub/b removed", change)
+	}
+
+	// 読み込めないファイルはエラーとして通知する
+	if err := os.WriteFile(filepath.Join(dir, "broken.md"), []byte("フロントマターなし"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	change = nextChange(t, changes)
+	if len(change.Errors) != 1 || len(change.Updated) != 0 {
+		t.Errorf("change = %+v, want one error", change)
+	}
+}
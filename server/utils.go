@@ -1,53 +1,84 @@
 package main
 
 import (
+	"cmp"
 	"fmt"
 	"log"
+	"strings"
 
-	"github.com/weaviate/weaviate/entities/models"
+	"github.com/imaikosuke/iput-tokyo-ai/server/pkg/vectorstore"
 )
 
-// Weaviate GraphQLのレスポンスをデコードし、ドキュメントの内容を含む文字列のリストを返す
-func decodeGetResults(result *models.GraphQLResponse) ([]string, error) {
-	data, ok := result.Data["Get"]
-	if !ok {
-		return nil, fmt.Errorf("don't have get key in response")
-	}
-	document, ok := data.(map[string]any)
-	if !ok {
-		return nil, fmt.Errorf("invalid get key in response")
-	}
-	slices, ok := document["Document"].([]any)
-	if !ok {
-		return nil, fmt.Errorf("document is not a list of results")
-	}
+// 抜粋として返すコンテンツの最大文字数
+const excerptLength = 200
 
-	var out []string
-	for index, slice := range slices {
-		slicedData, ok := slice.(map[string]any)
-		if !ok {
-			return nil, fmt.Errorf("invalid element in list of documents")
-		}
+// Sourceは回答の根拠となったチャンクの情報
+type Source struct {
+	Index      int    `json:"index"` // プロンプト内で引用に使う番号（1始まり）
+	DocumentID string `json:"documentId"`
+	Title      string `json:"title"`
+	Category   string `json:"category"`
+	Department string `json:"department"`
+	ChunkIndex int    `json:"chunkIndex"`
+	// 前後のチャンクをまとめた場合の最後のチャンク番号（まとめていない場合はChunkIndexと同じ）
+	EndChunkIndex int      `json:"endChunkIndex"`
+	TotalChunks   int      `json:"totalChunks"`
+	Certainty     float64  `json:"certainty"`
+	Score         float64  `json:"score"`
+	Headings      []string `json:"headings"`
+	SectionPath   string   `json:"sectionPath"`
+	Excerpt       string   `json:"excerpt"`
+	Content       string   `json:"-"`
+}
 
-		title, _ := slicedData["title"].(string)
-		content, _ := slicedData["content"].(string)
-		category, _ := slicedData["category"].(string)
-		department, _ := slicedData["department"].(string)
-
-		additional, _ := slicedData["_additional"].(map[string]any)
-		certainty := 0.0
-		if additional != nil {
-			if cert, ok := additional["certainty"].(float64); ok {
-				certainty = cert
-			}
+// ベクトルストアの検索結果を、引用番号を付けたSourceのリストに変換する
+func sourcesFromResults(results []vectorstore.Result) []Source {
+	var out []Source
+	for i, r := range results {
+		src := Source{
+			Index:         i + 1,
+			DocumentID:    r.DocumentID,
+			Title:         r.Title,
+			Category:      r.Category,
+			Department:    r.Department,
+			ChunkIndex:    r.ChunkIndex,
+			EndChunkIndex: r.ChunkIndex,
+			TotalChunks:   r.TotalChunks,
+			Certainty:     r.Certainty,
+			Score:         r.Score,
+			Headings:      r.Headings,
+			SectionPath:   cmp.Or(r.SectionPath, vectorstore.SectionPath(r.Headings)),
+			Excerpt:       excerpt(r.Content, excerptLength),
+			Content:       r.Content,
 		}
 
-		log.Printf("Document %d: %s (certainty: %.3f)", index+1, title, certainty)
+		log.Printf("Document %d: %s (certainty: %.3f, score: %.3f)", src.Index, src.Title, src.Certainty, src.Score)
+		out = append(out, src)
+	}
+	return out
+}
 
-		docContent := fmt.Sprintf("タイトル: %s\nカテゴリ: %s\n所属: %s\n\n%s",
-			title, category, department, content)
+// 検索結果をプロンプトに埋め込むコンテキスト文字列に変換する。
+// 各ブロックには引用用の番号 [n] を付与する
+func formatContext(sources []Source) string {
+	blocks := make([]string, len(sources))
+	for i, src := range sources {
+		var b strings.Builder
+		fmt.Fprintf(&b, "[%d]\nタイトル: %s\nカテゴリ: %s\n所属: %s\n", src.Index, src.Title, src.Category, src.Department)
+		if src.SectionPath != "" {
+			fmt.Fprintf(&b, "見出し: %s\n", src.SectionPath)
+		}
+		fmt.Fprintf(&b, "\n%s", src.Content)
+		blocks[i] = b.String()
+	}
+	return strings.Join(blocks, "\n\n---\n\n")
+}
 
-		out = append(out, docContent)
+// テキストの先頭から最大n文字を切り出す
+func excerpt(text string, n int) string {
+	runes := []rune(strings.TrimSpace(text))
+	if len(runes) <= n {
+		return string(runes)
 	}
-	return out, nil
+	return string(runes[:n]) + "…"
 }